@@ -0,0 +1,163 @@
+// Package admin serves the API operators use to inspect and repair the connector. It is separate from
+// the routes called by connctd and every request needs the configured bearer token.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// Replayer replays archived uplinks
+type Replayer interface {
+	Replay(ctx context.Context, options lorawan.ReplayOptions) (*lorawan.ReplayReport, error)
+}
+
+// Provisioner replaces the things of devices
+type Provisioner interface {
+	ReprovisionDevice(ctx context.Context, instanceID string, devEUI []byte) (string, error)
+}
+
+// Store is the part of the storage operators inspect and edit through the admin API
+type Store interface {
+	storage.AdminStore
+}
+
+type Handler struct {
+	r           *mux.Router
+	store       Store
+	replayer    Replayer
+	provisioner Provisioner
+	token       string
+	logger      logrus.FieldLogger
+}
+
+// NewHandler registers the admin routes on the subrouter. Requests need to send the token as
+// bearer token.
+func NewHandler(subrouter *mux.Router, store Store, replayer Replayer, provisioner Provisioner, token string) *Handler {
+	h := &Handler{
+		r:           subrouter,
+		store:       store,
+		replayer:    replayer,
+		provisioner: provisioner,
+		token:       token,
+		logger:      logrus.WithField("component", "admin"),
+	}
+	if h.r == nil {
+		h.r = mux.NewRouter()
+	}
+	h.r.Use(h.authenticate)
+	h.r.Path("/replay").Methods(http.MethodPost).HandlerFunc(h.replay)
+	h.r.Path("/instances").Methods(http.MethodGet).HandlerFunc(h.listInstances)
+	h.r.Path("/instances/{instanceId}").Methods(http.MethodGet).HandlerFunc(h.getInstance)
+	h.r.Path("/instances/{instanceId}/devices").Methods(http.MethodGet).HandlerFunc(h.listDevices)
+	h.r.Path("/instances/{instanceId}/devices/{devEui}").Methods(http.MethodGet).HandlerFunc(h.getDevice)
+	h.r.Path("/instances/{instanceId}/devices/{devEui}/state").Methods(http.MethodGet).HandlerFunc(h.getState)
+	h.r.Path("/instances/{instanceId}/devices/{devEui}/state/{key}").Methods(http.MethodPut).HandlerFunc(h.setState)
+	h.r.Path("/instances/{instanceId}/devices/{devEui}/reprovision").Methods(http.MethodPost).HandlerFunc(h.reprovision)
+	h.r.Path("/instances/{instanceId}/devices/{devEui}/errors").Methods(http.MethodGet).HandlerFunc(h.listErrors)
+	return h
+}
+
+// authenticate rejects requests without the admin token. An empty token rejects all requests.
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if h.token == "" || token == header || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			h.logger.WithFields(logrus.Fields{
+				"client": r.RemoteAddr,
+				"url":    r.URL.String(),
+			}).Warn("Rejected unauthenticated admin request")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ReplayRequest selects the archived uplinks to replay, see lorawan.ReplayOptions
+type ReplayRequest struct {
+	InstanceID    string    `json:"instanceId"`
+	DevEUI        string    `json:"devEui,omitempty"`
+	ApplicationID uint64    `json:"applicationId,omitempty"`
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	DryRun        bool      `json:"dryRun"`
+}
+
+func (h *Handler) replay(w http.ResponseWriter, r *http.Request) {
+	logger := h.logger.WithFields(logrus.Fields{
+		"client": r.RemoteAddr,
+		"method": "replay",
+	})
+	var req ReplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("request body does not contain a valid replay request"))
+		return
+	}
+	options := lorawan.ReplayOptions{
+		InstanceID:    req.InstanceID,
+		ApplicationID: req.ApplicationID,
+		From:          req.From,
+		To:            req.To,
+		DryRun:        req.DryRun,
+	}
+	if req.DevEUI != "" {
+		devEUI, err := lorawan.ParseEUI(req.DevEUI)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		options.DevEUI = devEUI
+	}
+	logger = logger.WithFields(logrus.Fields{
+		"instanceId": req.InstanceID,
+		"dryRun":     req.DryRun,
+	})
+
+	report, err := h.replayer.Replay(r.Context(), options)
+	switch {
+	case errors.Is(err, lorawan.ErrMissingInstance):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, errors.New("instance does not exist"))
+	case err != nil:
+		logger.WithError(err).Error("Failed to replay archived uplinks")
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	b, _ := json.Marshal(errorBody{Error: err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
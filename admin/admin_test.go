@@ -0,0 +1,101 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "admintoken"
+
+func newTestRouter(store Store, replayer Replayer, provisioner Provisioner) *mux.Router {
+	r := mux.NewRouter()
+	NewHandler(r.PathPrefix("/admin").Subrouter(), store, replayer, provisioner, testToken)
+	return r
+}
+
+func request(r *mux.Router, token string, body string) *httptest.ResponseRecorder {
+	return requestPath(r, token, http.MethodPost, "/admin/replay", body)
+}
+
+func requestPath(r *mux.Router, token, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://localhost"+path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthentication(t *testing.T) {
+	store := new(mockStore)
+	replayer := new(mockReplayer)
+	r := newTestRouter(store, replayer, new(mockProvisioner))
+
+	for _, token := range []string{"", "wrong"} {
+		w := request(r, token, `{"instanceId":"bar"}`)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/admin/replay", bytes.NewBufferString(`{"instanceId":"bar"}`))
+	req.Header.Set("Authorization", testToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, requestPath(r, "wrong", http.MethodGet, "/admin/instances", "").Code)
+
+	// Without token the API is disabled
+	r = mux.NewRouter()
+	NewHandler(r.PathPrefix("/admin").Subrouter(), store, replayer, new(mockProvisioner), "")
+	assert.Equal(t, http.StatusUnauthorized, request(r, "", `{"instanceId":"bar"}`).Code)
+	replayer.AssertNotCalled(t, "Replay", mock.Anything, mock.Anything)
+	store.AssertNotCalled(t, "ListInstances", mock.Anything)
+}
+
+func TestReplay(t *testing.T) {
+	replayer := new(mockReplayer)
+	r := newTestRouter(new(mockStore), replayer, new(mockProvisioner))
+	from := time.Date(2021, 8, 15, 0, 0, 0, 0, time.UTC)
+	oldValue := "-24.900000"
+	replayer.On("Replay", mock.Anything, lorawan.ReplayOptions{
+		InstanceID: "bar",
+		DevEUI:     []byte{0xa8, 0x40, 0x41, 0x4d, 0x61, 0x82, 0xe0, 0x88},
+		From:       from,
+		DryRun:     true,
+	}).Return(&lorawan.ReplayReport{
+		DryRun:   true,
+		Replayed: 1,
+		Changes: []lorawan.PropertyChange{
+			{EventID: 1, ThingID: "thing", ComponentID: "waterlevel", PropertyID: "waterlevel", OldValue: &oldValue, NewValue: "275.100000"},
+		},
+	}, nil)
+	replayer.On("Replay", mock.Anything, lorawan.ReplayOptions{InstanceID: "unknown"}).Return(nil, storage.ErrNotFound)
+	replayer.On("Replay", mock.Anything, lorawan.ReplayOptions{}).Return(nil, lorawan.ErrMissingInstance)
+
+	w := request(r, testToken, `{"instanceId":"bar","devEui":"a8-40-41-4d-61-82-e0-88","from":"2021-08-15T00:00:00Z","dryRun":true}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var report lorawan.ReplayReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.True(t, report.DryRun)
+	require.Len(t, report.Changes, 1)
+	assert.Equal(t, oldValue, *report.Changes[0].OldValue)
+	assert.Equal(t, "275.100000", report.Changes[0].NewValue)
+
+	assert.Equal(t, http.StatusNotFound, request(r, testToken, `{"instanceId":"unknown"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request(r, testToken, `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request(r, testToken, `{"instanceId":"bar","devEui":"a840"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request(r, testToken, `not json`).Code)
+	replayer.AssertExpectations(t)
+}
@@ -0,0 +1,341 @@
+package admin
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultErrorLimit and maxErrorLimit limit the number of recent errors returned for a device
+const (
+	defaultErrorLimit = 20
+	maxErrorLimit     = 500
+)
+
+// InstanceConfig is the configuration of an instance. The ChirpStack API key is not returned.
+type InstanceConfig struct {
+	NetworkServer        lorawan.NetworkServer      `json:"networkServer"`
+	ChirpStackAPIURL     string                     `json:"chirpStackApiUrl,omitempty"`
+	ChirpStackAPIKeySet  bool                       `json:"chirpStackApiKeySet"`
+	DefaultDecoder       string                     `json:"defaultDecoder,omitempty"`
+	Timezone             string                     `json:"timezone"`
+	ProvisioningPolicy   lorawan.ProvisioningPolicy `json:"provisioningPolicy"`
+	ArchiveRetentionDays int                        `json:"archiveRetentionDays"`
+}
+
+// Instance describes an instance, the application decoders are only returned for single instances
+type Instance struct {
+	ID                  string                       `json:"id"`
+	InstallationID      string                       `json:"installationId"`
+	State               connector.InstantiationState `json:"state"`
+	CreatedAt           time.Time                    `json:"createdAt"`
+	LastEventAt         *time.Time                   `json:"lastEventAt,omitempty"`
+	Config              InstanceConfig               `json:"config"`
+	ApplicationDecoders map[uint64]string            `json:"applicationDecoders,omitempty"`
+}
+
+func newInstance(i storage.Instance) Instance {
+	instance := Instance{
+		ID:             i.ID,
+		InstallationID: i.InstallationID,
+		State:          i.State,
+		CreatedAt:      i.CreatedAt,
+		Config: InstanceConfig{
+			NetworkServer:        i.Config.NetworkServer,
+			ChirpStackAPIURL:     i.Config.ChirpStackAPIURL,
+			ChirpStackAPIKeySet:  i.Config.ChirpStackAPIKey != "",
+			DefaultDecoder:       i.Config.DefaultDecoder,
+			Timezone:             i.Config.Timezone,
+			ProvisioningPolicy:   i.Config.ProvisioningPolicy,
+			ArchiveRetentionDays: i.Config.ArchiveRetentionDays,
+		},
+	}
+	if !i.LastEventAt.IsZero() {
+		instance.LastEventAt = &i.LastEventAt
+	}
+	return instance
+}
+
+// Device describes a device and its thing, the decoder state is only returned for single devices
+type Device struct {
+	DevEUI          string            `json:"devEui"`
+	ThingID         string            `json:"thingId"`
+	DecoderName     string            `json:"decoder"`
+	ModelVersion    uint              `json:"modelVersion"`
+	Ignored         bool              `json:"ignored"`
+	Offline         bool              `json:"offline"`
+	LastUplinkAt    *time.Time        `json:"lastUplinkAt,omitempty"`
+	LearnedInterval string            `json:"learnedInterval,omitempty"`
+	State           map[string]string `json:"state,omitempty"`
+}
+
+func newDevice(d storage.Device) Device {
+	device := Device{
+		DevEUI:       hex.EncodeToString(d.DevEUI),
+		ThingID:      d.ThingID,
+		DecoderName:  d.DecoderName,
+		ModelVersion: d.ModelVersion,
+		Ignored:      d.Ignored,
+		Offline:      d.Offline,
+	}
+	if !d.LastUplinkAt.IsZero() {
+		device.LastUplinkAt = &d.LastUplinkAt
+	}
+	if d.LearnedInterval > 0 {
+		device.LearnedInterval = d.LearnedInterval.String()
+	}
+	return device
+}
+
+// EventError is an archived event which failed or has been rejected
+type EventError struct {
+	EventID    uint64               `json:"eventId"`
+	Event      string               `json:"event"`
+	FCnt       uint32               `json:"fCnt"`
+	ReceivedAt time.Time            `json:"receivedAt"`
+	Outcome    lorawan.EventOutcome `json:"outcome"`
+	Error      string               `json:"error"`
+}
+
+// StateValue is the body of requests setting a decoder state value
+type StateValue struct {
+	Value string `json:"value"`
+}
+
+func (h *Handler) listInstances(w http.ResponseWriter, r *http.Request) {
+	instances, err := h.store.ListInstances(r.Context())
+	if err != nil {
+		h.internalError(w, r, err, "Failed to list instances")
+		return
+	}
+	result := make([]Instance, 0, len(instances))
+	for _, i := range instances {
+		result = append(result, newInstance(i))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *Handler) getInstance(w http.ResponseWriter, r *http.Request) {
+	instanceID := mux.Vars(r)["instanceId"]
+	i, err := h.store.GetInstanceDetails(r.Context(), instanceID)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, errors.New("instance does not exist"))
+		return
+	}
+	if err != nil {
+		h.internalError(w, r, err, "Failed to retrieve instance")
+		return
+	}
+	instance := newInstance(i)
+	if instance.ApplicationDecoders, err = h.store.ListApplicationDecoders(r.Context(), instanceID); err != nil {
+		h.internalError(w, r, err, "Failed to list application decoders")
+		return
+	}
+	writeJSON(w, http.StatusOK, instance)
+}
+
+func (h *Handler) listDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := h.store.ListDevices(r.Context(), mux.Vars(r)["instanceId"])
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, errors.New("instance does not exist"))
+		return
+	}
+	if err != nil {
+		h.internalError(w, r, err, "Failed to list devices")
+		return
+	}
+	result := make([]Device, 0, len(devices))
+	for _, d := range devices {
+		result = append(result, newDevice(d))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// device looks up the device addressed by the request. It writes the error response and returns
+// false if the device can't be found.
+func (h *Handler) device(w http.ResponseWriter, r *http.Request) (storage.Device, bool) {
+	vars := mux.Vars(r)
+	devEUI, err := lorawan.ParseEUI(vars["devEui"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return storage.Device{}, false
+	}
+	d, err := h.store.GetDevice(r.Context(), vars["instanceId"], devEUI)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, errors.New("instance does not exist"))
+		return storage.Device{}, false
+	}
+	if err != nil {
+		h.internalError(w, r, err, "Failed to retrieve device")
+		return storage.Device{}, false
+	}
+	if d == nil {
+		writeError(w, http.StatusNotFound, errors.New("device does not exist"))
+		return storage.Device{}, false
+	}
+	return *d, true
+}
+
+func (h *Handler) getDevice(w http.ResponseWriter, r *http.Request) {
+	d, ok := h.device(w, r)
+	if !ok {
+		return
+	}
+	state, err := h.state(r.Context(), d.ThingID)
+	if err != nil {
+		h.internalError(w, r, err, "Failed to read decoder state")
+		return
+	}
+	device := newDevice(d)
+	device.State = state
+	writeJSON(w, http.StatusOK, device)
+}
+
+func (h *Handler) state(ctx context.Context, thingID string) (map[string]string, error) {
+	values, err := h.store.ListState(ctx, thingID)
+	if err != nil {
+		return nil, err
+	}
+	state := make(map[string]string, len(values))
+	for key, value := range values {
+		state[key] = string(value)
+	}
+	return state, nil
+}
+
+func (h *Handler) getState(w http.ResponseWriter, r *http.Request) {
+	d, ok := h.device(w, r)
+	if !ok {
+		return
+	}
+	state, err := h.state(r.Context(), d.ThingID)
+	if err != nil {
+		h.internalError(w, r, err, "Failed to read decoder state")
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+// setState stores a decoder state value of the thing of the device and returns the state of the thing.
+// Keys need to be in the namespace of the decoder of the device, so that other decoders' state is untouched.
+func (h *Handler) setState(w http.ResponseWriter, r *http.Request) {
+	d, ok := h.device(w, r)
+	if !ok {
+		return
+	}
+	var value StateValue
+	if err := json.NewDecoder(r.Body).Decode(&value); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("request body does not contain a valid state value"))
+		return
+	}
+	if d.DecoderName == "" {
+		writeError(w, http.StatusConflict, lorawan.ErrUnknownDecoder)
+		return
+	}
+	key := mux.Vars(r)["key"]
+	namespace := decoder.StateKey(d.DecoderName, "")
+	if !strings.HasPrefix(key, namespace) || key == namespace {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("state key needs to start with %q, the namespace of the decoder of the device", namespace))
+		return
+	}
+	if err := h.store.SetState(r.Context(), d.ThingID, key, []byte(value.Value)); err != nil {
+		h.internalError(w, r, err, "Failed to store decoder state")
+		return
+	}
+	h.logger.WithFields(logrus.Fields{
+		"client":  r.RemoteAddr,
+		"thingId": d.ThingID,
+		"key":     key,
+		"value":   value.Value,
+	}).Info("Decoder state changed by admin")
+	h.getState(w, r)
+}
+
+// reprovision replaces the thing of the device and returns the device with its new thing
+func (h *Handler) reprovision(w http.ResponseWriter, r *http.Request) {
+	d, ok := h.device(w, r)
+	if !ok {
+		return
+	}
+	thingID, err := h.provisioner.ReprovisionDevice(r.Context(), d.InstanceID, d.DevEUI)
+	switch {
+	case errors.Is(err, lorawan.ErrDeviceIgnored), errors.Is(err, lorawan.ErrUnknownDecoder):
+		writeError(w, http.StatusConflict, err)
+		return
+	case errors.Is(err, lorawan.ErrUnknownDevice):
+		writeError(w, http.StatusNotFound, errors.New("device does not exist"))
+		return
+	case err != nil:
+		h.internalError(w, r, err, "Failed to reprovision device")
+		return
+	}
+	h.logger.WithFields(logrus.Fields{
+		"client":       r.RemoteAddr,
+		"instanceId":   d.InstanceID,
+		"staleThingId": d.ThingID,
+		"thingId":      thingID,
+	}).Info("Device reprovisioned by admin")
+	d.ThingID = thingID
+	writeJSON(w, http.StatusOK, newDevice(d))
+}
+
+// listErrors returns the latest failed and rejected events of the device, the limit query parameter
+// sets the number of returned events
+func (h *Handler) listErrors(w http.ResponseWriter, r *http.Request) {
+	limit := defaultErrorLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 || limit > maxErrorLimit {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit needs to be between 1 and %d", maxErrorLimit))
+			return
+		}
+	}
+	d, ok := h.device(w, r)
+	if !ok {
+		return
+	}
+	events, err := h.store.ListArchivedEvents(r.Context(), lorawan.ArchiveFilter{
+		InstanceID: d.InstanceID,
+		DevEUI:     d.DevEUI,
+		Outcomes:   []lorawan.EventOutcome{lorawan.EventOutcomeFailed, lorawan.EventOutcomeRejected},
+		Limit:      limit,
+		Latest:     true,
+	})
+	if err != nil {
+		h.internalError(w, r, err, "Failed to list archived events")
+		return
+	}
+	result := make([]EventError, 0, len(events))
+	for _, e := range events {
+		result = append(result, EventError{
+			EventID:    e.ID,
+			Event:      e.Event,
+			FCnt:       e.FCnt,
+			ReceivedAt: e.ReceivedAt,
+			Outcome:    e.Outcome,
+			Error:      e.Error,
+		})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *Handler) internalError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	h.logger.WithError(err).WithFields(logrus.Fields{
+		"client": r.RemoteAddr,
+		"url":    r.URL.String(),
+	}).Error(msg)
+	writeError(w, http.StatusInternalServerError, err)
+}
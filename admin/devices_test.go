@@ -0,0 +1,156 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var testDevEUI = []byte{0xa8, 0x40, 0x41, 0x4d, 0x61, 0x82, 0xe0, 0x88}
+
+func testDevices() []storage.Device {
+	return []storage.Device{{
+		DeviceMapping: lorawan.DeviceMapping{
+			InstanceID:   "bar",
+			DevEUI:       testDevEUI,
+			ThingID:      "thing",
+			DecoderName:  "ldds75",
+			ModelVersion: 2,
+		},
+		LastUplinkAt:    time.Date(2021, 8, 15, 12, 6, 41, 0, time.UTC),
+		LearnedInterval: 20 * time.Minute,
+	}}
+}
+
+func TestInstances(t *testing.T) {
+	store := new(mockStore)
+	r := newTestRouter(store, new(mockReplayer), new(mockProvisioner))
+	config := lorawan.DefaultInstanceConfig()
+	config.ChirpStackAPIKey = "secret"
+	store.On("ListInstances", mock.Anything).Return([]storage.Instance{{ID: "bar", InstallationID: "foo", Config: config}}, nil)
+	store.On("GetInstanceDetails", mock.Anything, "bar").Return(storage.Instance{ID: "bar", InstallationID: "foo", Config: config}, nil)
+	store.On("GetInstanceDetails", mock.Anything, "unknown").Return(storage.Instance{}, storage.ErrNotFound)
+	store.On("ListApplicationDecoders", mock.Anything, "bar").Return(map[uint64]string{42: "dcl571"}, nil)
+
+	w := requestPath(r, testToken, http.MethodGet, "/admin/instances", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "secret")
+	var instances []Instance
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &instances))
+	require.Len(t, instances, 1)
+	assert.Equal(t, "foo", instances[0].InstallationID)
+	assert.True(t, instances[0].Config.ChirpStackAPIKeySet)
+	assert.Nil(t, instances[0].LastEventAt)
+
+	w = requestPath(r, testToken, http.MethodGet, "/admin/instances/bar", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var instance Instance
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &instance))
+	assert.Equal(t, map[uint64]string{42: "dcl571"}, instance.ApplicationDecoders)
+
+	assert.Equal(t, http.StatusNotFound, requestPath(r, testToken, http.MethodGet, "/admin/instances/unknown", "").Code)
+}
+
+func TestDevices(t *testing.T) {
+	store := new(mockStore)
+	r := newTestRouter(store, new(mockReplayer), new(mockProvisioner))
+	store.On("ListDevices", mock.Anything, "bar").Return(testDevices(), nil)
+	store.On("ListDevices", mock.Anything, "unknown").Return(nil, storage.ErrNotFound)
+	store.On("GetDevice", mock.Anything, "bar", testDevEUI).Return(&testDevices()[0], nil)
+	store.On("GetDevice", mock.Anything, "bar", []byte{0, 0, 0, 0, 0, 0, 0, 1}).Return(nil, nil)
+	store.On("ListState", mock.Anything, "thing").Return(map[string][]byte{"ldds75.mountingHeight": []byte("300")}, nil)
+
+	w := requestPath(r, testToken, http.MethodGet, "/admin/instances/bar/devices", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var devices []Device
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &devices))
+	require.Len(t, devices, 1)
+	assert.Equal(t, "a840414d6182e088", devices[0].DevEUI)
+	assert.Equal(t, "20m0s", devices[0].LearnedInterval)
+	assert.Nil(t, devices[0].State)
+
+	w = requestPath(r, testToken, http.MethodGet, "/admin/instances/bar/devices/a8-40-41-4d-61-82-e0-88", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var device Device
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &device))
+	assert.Equal(t, "thing", device.ThingID)
+	assert.Equal(t, map[string]string{"ldds75.mountingHeight": "300"}, device.State)
+
+	assert.Equal(t, http.StatusNotFound, requestPath(r, testToken, http.MethodGet, "/admin/instances/unknown/devices", "").Code)
+	assert.Equal(t, http.StatusNotFound, requestPath(r, testToken, http.MethodGet, "/admin/instances/bar/devices/0000000000000001", "").Code)
+	assert.Equal(t, http.StatusBadRequest, requestPath(r, testToken, http.MethodGet, "/admin/instances/bar/devices/a840", "").Code)
+}
+
+func TestSetState(t *testing.T) {
+	store := new(mockStore)
+	r := newTestRouter(store, new(mockReplayer), new(mockProvisioner))
+	store.On("GetDevice", mock.Anything, "bar", testDevEUI).Return(&testDevices()[0], nil)
+	store.On("SetState", mock.Anything, "thing", "ldds75.mountingHeight", []byte("310")).Return(nil)
+	store.On("ListState", mock.Anything, "thing").Return(map[string][]byte{"ldds75.mountingHeight": []byte("310")}, nil)
+
+	w := requestPath(r, testToken, http.MethodPut, "/admin/instances/bar/devices/a840414d6182e088/state/ldds75.mountingHeight", `{"value":"310"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var state map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	assert.Equal(t, "310", state["ldds75.mountingHeight"])
+
+	assert.Equal(t, http.StatusBadRequest, requestPath(r, testToken, http.MethodPut, "/admin/instances/bar/devices/a840414d6182e088/state/ldds75.mountingHeight", `310`).Code)
+	// State of other decoders can't be set
+	for _, key := range []string{"dcl571.waterLevelOffset", "mountingHeight", "ldds75."} {
+		assert.Equal(t, http.StatusBadRequest, requestPath(r, testToken, http.MethodPut, "/admin/instances/bar/devices/a840414d6182e088/state/"+key, `{"value":"310"}`).Code, key)
+	}
+	store.AssertNumberOfCalls(t, "SetState", 1)
+	store.AssertExpectations(t)
+}
+
+func TestReprovision(t *testing.T) {
+	store := new(mockStore)
+	provisioner := new(mockProvisioner)
+	r := newTestRouter(store, new(mockReplayer), provisioner)
+	ignored := storage.Device{DeviceMapping: lorawan.DeviceMapping{InstanceID: "bar", DevEUI: []byte{0, 0, 0, 0, 0, 0, 0, 1}, ThingID: "deleted"}, Ignored: true}
+	store.On("GetDevice", mock.Anything, "bar", testDevEUI).Return(&testDevices()[0], nil)
+	store.On("GetDevice", mock.Anything, "bar", ignored.DevEUI).Return(&ignored, nil)
+	provisioner.On("ReprovisionDevice", mock.Anything, "bar", testDevEUI).Return("newthing", nil)
+	provisioner.On("ReprovisionDevice", mock.Anything, "bar", ignored.DevEUI).Return("", lorawan.ErrDeviceIgnored)
+
+	w := requestPath(r, testToken, http.MethodPost, "/admin/instances/bar/devices/a840414d6182e088/reprovision", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var device Device
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &device))
+	assert.Equal(t, "newthing", device.ThingID)
+
+	assert.Equal(t, http.StatusConflict, requestPath(r, testToken, http.MethodPost, "/admin/instances/bar/devices/0000000000000001/reprovision", "").Code)
+	provisioner.AssertExpectations(t)
+}
+
+func TestListErrors(t *testing.T) {
+	store := new(mockStore)
+	r := newTestRouter(store, new(mockReplayer), new(mockProvisioner))
+	receivedAt := time.Date(2021, 8, 15, 12, 6, 41, 0, time.UTC)
+	store.On("GetDevice", mock.Anything, "bar", testDevEUI).Return(&testDevices()[0], nil)
+	store.On("ListArchivedEvents", mock.Anything, lorawan.ArchiveFilter{
+		InstanceID: "bar",
+		DevEUI:     testDevEUI,
+		Outcomes:   []lorawan.EventOutcome{lorawan.EventOutcomeFailed, lorawan.EventOutcomeRejected},
+		Limit:      5,
+		Latest:     true,
+	}).Return([]lorawan.ArchivedEvent{
+		{ID: 7, Event: "up", FCnt: 33, ReceivedAt: receivedAt, Outcome: lorawan.EventOutcomeFailed, Error: "connctd unavailable", Body: []byte("{}")},
+	}, nil)
+
+	w := requestPath(r, testToken, http.MethodGet, "/admin/instances/bar/devices/a840414d6182e088/errors?limit=5", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var errors []EventError
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errors))
+	assert.Equal(t, []EventError{{EventID: 7, Event: "up", FCnt: 33, ReceivedAt: receivedAt, Outcome: lorawan.EventOutcomeFailed, Error: "connctd unavailable"}}, errors)
+
+	assert.Equal(t, http.StatusBadRequest, requestPath(r, testToken, http.MethodGet, "/admin/instances/bar/devices/a840414d6182e088/errors?limit=0", "").Code)
+	store.AssertExpectations(t)
+}
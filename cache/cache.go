@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// entry is a cached lookup result. Errors are only cached if they mean that something does not exist.
+type entry struct {
+	value   interface{}
+	err     error
+	expires time.Time
+}
+
+// ttlCache holds entries until they expire or are invalidated. Keys ending with a slash invalidate
+// all keys they are a prefix of.
+type ttlCache struct {
+	mu      sync.Mutex
+	entries map[string]entry
+	// generation is increased by every invalidation, so that lookups which started before an
+	// invalidation don't store outdated results
+	generation uint64
+}
+
+func newTTLCache() *ttlCache {
+	return &ttlCache{
+		entries: map[string]entry{},
+	}
+}
+
+// get returns the entry for key, if there is one which has not expired. If there is none, it
+// returns the generation to pass to set.
+func (c *ttlCache) get(key string, now time.Time) (entry, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if ok && now.Before(e.expires) {
+		return e, c.generation, true
+	}
+	return entry{}, c.generation, false
+}
+
+// set stores the entry, unless an invalidation happened since the given generation
+func (c *ttlCache) set(key string, e entry, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	c.entries[key] = e
+}
+
+func (c *ttlCache) invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			delete(c.entries, key)
+			continue
+		}
+		for k := range c.entries {
+			if strings.HasPrefix(k, key) {
+				delete(c.entries, k)
+			}
+		}
+	}
+}
+
+func (c *ttlCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries = map[string]entry{}
+}
+
+// sweep drops all expired entries
+func (c *ttlCache) sweep(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+func (c *ttlCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
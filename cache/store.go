@@ -0,0 +1,296 @@
+// Package cache keeps the results of the lookups every uplink needs in memory, so that uplinks don't
+// wait for the database in sequence.
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// Invalidations distributes invalidated cache keys to the caches of other replicas
+type Invalidations interface {
+	PublishInvalidations(ctx context.Context, keys []string) error
+	// LatestInvalidation returns the position of the latest invalidation
+	LatestInvalidation(ctx context.Context) (uint64, error)
+	// Invalidations returns the keys invalidated after the given position and the new position
+	Invalidations(ctx context.Context, after uint64) ([]string, uint64, error)
+}
+
+// Options configure the cache
+type Options struct {
+	// TTL is how long lookup results are cached
+	TTL time.Duration
+	// NegativeTTL is how long results are cached which report that something does not exist
+	NegativeTTL time.Duration
+	// Invalidations shares invalidations with other replicas, if set. Without it, other replicas
+	// see changes when their entries expire.
+	Invalidations Invalidations
+	// PollInterval is the interval in which Run fetches invalidations and drops expired entries
+	PollInterval time.Duration
+}
+
+var _ storage.Store = (*Store)(nil)
+
+// Store caches instances, instance configs, decoder mappings, device mappings and decoder state of the wrapped store.
+// Writes through Store invalidate the affected entries.
+type Store struct {
+	storage.Store
+	options Options
+	cache   *ttlCache
+	logger  logrus.FieldLogger
+}
+
+// defaultPollInterval is used if Options.PollInterval is not set
+const defaultPollInterval = time.Second
+
+// New wraps the store with a cache
+func New(store storage.Store, options Options) *Store {
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaultPollInterval
+	}
+	return &Store{
+		Store:   store,
+		options: options,
+		cache:   newTTLCache(),
+		logger:  logrus.WithField("component", "cache"),
+	}
+}
+
+// Run applies invalidations of other replicas and drops expired entries until the context is done
+func (s *Store) Run(ctx context.Context) {
+	var position uint64
+	polling := false
+	ticker := time.NewTicker(s.options.PollInterval)
+	defer ticker.Stop()
+	for {
+		if s.options.Invalidations != nil {
+			if !polling {
+				latest, err := s.options.Invalidations.LatestInvalidation(ctx)
+				if err != nil {
+					s.logger.WithError(err).Warn("Failed to read latest cache invalidation")
+				} else {
+					position, polling = latest, true
+				}
+			} else {
+				keys, newPosition, err := s.options.Invalidations.Invalidations(ctx, position)
+				if err != nil {
+					s.logger.WithError(err).Warn("Failed to read cache invalidations")
+				} else if len(keys) > 0 {
+					s.cache.invalidate(keys...)
+					position = newPosition
+				}
+			}
+		}
+		s.cache.sweep(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func instanceKey(instanceID string) string {
+	return "instance/" + instanceID
+}
+
+func configKey(instanceID string) string {
+	return "config/" + instanceID
+}
+
+func appKey(instanceID string, appID uint64) string {
+	return "app/" + instanceID + "/" + strconv.FormatUint(appID, 10)
+}
+
+func deviceKey(instanceID string, devEUI []byte) string {
+	return fmt.Sprintf("device/%s/%x", instanceID, devEUI)
+}
+
+func stateKey(thingID, key string) string {
+	return "state/" + thingID + "/" + key
+}
+
+// load returns the cached result for key or loads and caches it. Results for which negative returns
+// true are cached for the negative TTL, all other errors aren't cached.
+func (s *Store) load(key string, load func() (interface{}, error), negative func(value interface{}, err error) bool) (interface{}, error) {
+	now := time.Now()
+	e, generation, ok := s.cache.get(key, now)
+	if ok {
+		return e.value, e.err
+	}
+	value, err := load()
+	ttl := s.options.TTL
+	if negative(value, err) {
+		ttl = s.options.NegativeTTL
+	} else if err != nil {
+		return value, err
+	}
+	if ttl > 0 {
+		s.cache.set(key, entry{value: value, err: err, expires: now.Add(ttl)}, generation)
+	}
+	return value, err
+}
+
+// invalidate drops the keys from the cache and publishes them to other replicas
+func (s *Store) invalidate(keys ...string) {
+	s.cache.invalidate(keys...)
+	if s.options.Invalidations == nil {
+		return
+	}
+	if err := s.options.Invalidations.PublishInvalidations(context.Background(), keys); err != nil {
+		s.logger.WithError(err).WithField("keys", keys).Warn("Failed to publish cache invalidation")
+	}
+}
+
+func isNotFound(value interface{}, err error) bool {
+	return errors.Is(err, storage.ErrNotFound)
+}
+
+func (s *Store) GetInstance(ctx context.Context, instanceID string) (connector.InstantiationRequest, error) {
+	v, err := s.load(instanceKey(instanceID), func() (interface{}, error) {
+		return s.Store.GetInstance(ctx, instanceID)
+	}, isNotFound)
+	return v.(connector.InstantiationRequest), err
+}
+
+func (s *Store) GetInstanceConfig(ctx context.Context, instanceID string) (lorawan.InstanceConfig, error) {
+	v, err := s.load(configKey(instanceID), func() (interface{}, error) {
+		return s.Store.GetInstanceConfig(ctx, instanceID)
+	}, isNotFound)
+	return v.(lorawan.InstanceConfig), err
+}
+
+func (s *Store) DecoderNameForApp(ctx context.Context, instanceID string, appID uint64) (string, error) {
+	v, err := s.load(appKey(instanceID, appID), func() (interface{}, error) {
+		return s.Store.DecoderNameForApp(ctx, instanceID, appID)
+	}, isNotFound)
+	return v.(string), err
+}
+
+// GetDeviceMapping returns the mapping of the device or nil if the device is unknown. Unknown devices
+// are cached for the negative TTL, ignored devices for the regular TTL.
+func (s *Store) GetDeviceMapping(ctx context.Context, instanceID string, devEUI []byte) (*lorawan.DeviceMapping, error) {
+	v, err := s.load(deviceKey(instanceID, devEUI), func() (interface{}, error) {
+		mapping, err := s.Store.GetDeviceMapping(ctx, instanceID, devEUI)
+		if errors.Is(err, lorawan.ErrDeviceIgnored) {
+			return (*lorawan.DeviceMapping)(nil), err
+		}
+		return mapping, err
+	}, func(value interface{}, err error) bool {
+		return err == nil && value.(*lorawan.DeviceMapping) == nil
+	})
+	if errors.Is(err, lorawan.ErrDeviceIgnored) {
+		return nil, err
+	}
+	mapping, _ := v.(*lorawan.DeviceMapping)
+	if mapping == nil {
+		return nil, err
+	}
+	// Callers must not change cached mappings
+	m := *mapping
+	return &m, err
+}
+
+func (s *Store) GetState(ctx context.Context, thingID, key string) ([]byte, error) {
+	v, err := s.load(stateKey(thingID, key), func() (interface{}, error) {
+		return s.Store.GetState(ctx, thingID, key)
+	}, func(value interface{}, err error) bool {
+		return errors.Is(err, decoder.ErrStateNotFound)
+	})
+	value, _ := v.([]byte)
+	return value, err
+}
+
+func (s *Store) SetState(ctx context.Context, thingID, key string, value []byte) error {
+	defer s.invalidate(stateKey(thingID, key))
+	return s.Store.SetState(ctx, thingID, key, value)
+}
+
+// LockDevice drops the cached mapping of the device once the lock is acquired. Callers check whether
+// the device has been provisioned while they were waiting, which possibly happened on another replica.
+func (s *Store) LockDevice(ctx context.Context, instanceID string, devEUI []byte) (func(), error) {
+	unlock, err := s.Store.LockDevice(ctx, instanceID, devEUI)
+	if err == nil {
+		s.cache.invalidate(deviceKey(instanceID, devEUI))
+	}
+	return unlock, err
+}
+
+func (s *Store) StoreDeviceMapping(ctx context.Context, mapping lorawan.DeviceMapping) error {
+	defer s.invalidate(deviceKey(mapping.InstanceID, mapping.DevEUI))
+	return s.Store.StoreDeviceMapping(ctx, mapping)
+}
+
+func (s *Store) SetDeviceDecoder(ctx context.Context, instanceID string, devEUI []byte, decoderName string, modelVersion uint) error {
+	defer s.invalidate(deviceKey(instanceID, devEUI))
+	return s.Store.SetDeviceDecoder(ctx, instanceID, devEUI, decoderName, modelVersion)
+}
+
+func (s *Store) IgnoreDevice(ctx context.Context, instanceID string, devEUI []byte) error {
+	defer s.invalidate(deviceKey(instanceID, devEUI))
+	return s.Store.IgnoreDevice(ctx, instanceID, devEUI)
+}
+
+// ReplaceThingID invalidates the device mapping and the decoder state of both things, since the state
+// is carried over to the new thing
+func (s *Store) ReplaceThingID(ctx context.Context, staleThingID string, mapping lorawan.DeviceMapping) error {
+	defer s.invalidate(
+		deviceKey(mapping.InstanceID, mapping.DevEUI),
+		stateKey(staleThingID, ""),
+		stateKey(mapping.ThingID, ""),
+	)
+	return s.Store.ReplaceThingID(ctx, staleThingID, mapping)
+}
+
+// AddInstance invalidates lookups of the instance, which might have failed before it existed
+func (s *Store) AddInstance(ctx context.Context, req connector.InstantiationRequest) error {
+	defer s.invalidate(instanceKey(req.ID), configKey(req.ID), "app/"+req.ID+"/")
+	return s.Store.AddInstance(ctx, req)
+}
+
+// PerformAction invalidates all decoder mappings and instance configs, since the instance of a config
+// thing is not known before the action is performed, and the decoder state of the thing
+func (s *Store) PerformAction(ctx context.Context, req connector.ActionRequest) (*connector.ActionResponse, error) {
+	defer s.invalidate("app/", "config/", stateKey(req.ThingID, ""))
+	return s.Store.PerformAction(ctx, req)
+}
+
+func (s *Store) RemoveInstallation(ctx context.Context, installationID string, dryRun bool) (*storage.RemovalReport, error) {
+	report, err := s.Store.RemoveInstallation(ctx, installationID, dryRun)
+	s.invalidateRemoved(report)
+	return report, err
+}
+
+func (s *Store) RemoveInstance(ctx context.Context, instanceID string, dryRun bool) (*storage.RemovalReport, error) {
+	report, err := s.Store.RemoveInstance(ctx, instanceID, dryRun)
+	s.invalidateRemoved(report)
+	return report, err
+}
+
+// invalidateRemoved invalidates everything related to removed instances and things, even if the
+// removal failed halfway
+func (s *Store) invalidateRemoved(report *storage.RemovalReport) {
+	if report == nil || report.DryRun {
+		return
+	}
+	var keys []string
+	for _, instanceID := range report.Instances {
+		keys = append(keys, instanceKey(instanceID), configKey(instanceID), "app/"+instanceID+"/", "device/"+instanceID+"/")
+	}
+	for _, thingID := range report.Things {
+		keys = append(keys, stateKey(thingID, ""))
+	}
+	if len(keys) > 0 {
+		s.invalidate(keys...)
+	}
+}
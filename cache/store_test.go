@@ -0,0 +1,285 @@
+package cache_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/cache"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/connctd/lora-connector/lorawan/decoder/ldds75"
+	"github.com/connctd/lora-connector/memstore"
+	"github.com/connctd/lora-connector/sqlstore"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/connctd/restapi-go"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubClient accepts all calls to connctd, it avoids the bookkeeping of mocks in benchmarks
+type stubClient struct {
+	connector.Client
+	things uint64
+}
+
+func (c *stubClient) CreateThing(ctx context.Context, token connector.InstantiationToken, thing restapi.Thing) (restapi.Thing, error) {
+	thing.ID = "thing" + strconv.FormatUint(atomic.AddUint64(&c.things, 1), 10)
+	return thing, nil
+}
+
+func (c *stubClient) UpdateThingPropertyValue(ctx context.Context, token connector.InstantiationToken, thingID, componentID, propertyID, value string, lastUpdate time.Time) error {
+	return nil
+}
+
+func (c *stubClient) DeleteThing(ctx context.Context, token connector.InstantiationToken, thingID string) error {
+	return nil
+}
+
+func (c *stubClient) UpdateInstallationState(ctx context.Context, token connector.InstallationToken, state connector.InstallationState, details json.RawMessage) error {
+	return nil
+}
+
+func (c *stubClient) UpdateInstanceState(ctx context.Context, token connector.InstantiationToken, state connector.InstantiationState, details json.RawMessage) error {
+	return nil
+}
+
+// countingStore counts the lookups which reach the wrapped store
+type countingStore struct {
+	storage.Store
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (c *countingStore) count(method string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[method]++
+}
+
+func (c *countingStore) get(method string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[method]
+}
+
+func (c *countingStore) GetInstance(ctx context.Context, instanceID string) (connector.InstantiationRequest, error) {
+	c.count("GetInstance")
+	return c.Store.GetInstance(ctx, instanceID)
+}
+
+func (c *countingStore) GetInstanceConfig(ctx context.Context, instanceID string) (lorawan.InstanceConfig, error) {
+	c.count("GetInstanceConfig")
+	return c.Store.GetInstanceConfig(ctx, instanceID)
+}
+
+func (c *countingStore) DecoderNameForApp(ctx context.Context, instanceID string, appID uint64) (string, error) {
+	c.count("DecoderNameForApp")
+	return c.Store.DecoderNameForApp(ctx, instanceID, appID)
+}
+
+func (c *countingStore) GetDeviceMapping(ctx context.Context, instanceID string, devEUI []byte) (*lorawan.DeviceMapping, error) {
+	c.count("GetDeviceMapping")
+	return c.Store.GetDeviceMapping(ctx, instanceID, devEUI)
+}
+
+func (c *countingStore) GetState(ctx context.Context, thingID, key string) ([]byte, error) {
+	c.count("GetState")
+	return c.Store.GetState(ctx, thingID, key)
+}
+
+var devEUI = []byte{0xa8, 0x40, 0x41, 0x4d, 0x61, 0x82, 0xe0, 0x88}
+
+// addInstance adds the installation foo with the instance bar, which decodes all applications with ldds75
+func addInstance(t testing.TB, store storage.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.AddInstallation(ctx, connector.InstallationRequest{
+		ID:    "foo",
+		Token: "installationtoken",
+		Configuration: []connector.Configuration{
+			{ID: lorawan.ConfigDefaultDecoder, Value: "ldds75"},
+		},
+	}))
+	require.NoError(t, store.AddInstance(ctx, connector.InstantiationRequest{
+		ID:             "bar",
+		InstallationID: "foo",
+		Token:          "instancetoken",
+	}))
+}
+
+func newCountingStore(t *testing.T) (*countingStore, *cache.Store) {
+	inner := &countingStore{Store: memstore.New(&stubClient{}, "lora.example.com"), calls: map[string]int{}}
+	return inner, cache.New(inner, cache.Options{TTL: time.Hour, NegativeTTL: time.Hour})
+}
+
+func TestCachedLookups(t *testing.T) {
+	inner, store := newCountingStore(t)
+	addInstance(t, store)
+
+	for i := 0; i < 3; i++ {
+		instance, err := store.GetInstance(context.Background(), "bar")
+		require.NoError(t, err)
+		assert.Equal(t, "foo", instance.InstallationID)
+		config, err := store.GetInstanceConfig(context.Background(), "bar")
+		require.NoError(t, err)
+		assert.Equal(t, lorawan.DefaultArchiveRetentionDays, config.ArchiveRetentionDays)
+		name, err := store.DecoderNameForApp(context.Background(), "bar", 1)
+		require.NoError(t, err)
+		assert.Equal(t, "ldds75", name)
+		mapping, err := store.GetDeviceMapping(context.Background(), "bar", devEUI)
+		require.NoError(t, err)
+		assert.Nil(t, mapping)
+		_, err = store.GetInstance(context.Background(), "unknown")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = store.GetState(context.Background(), "thing", "ldds75.mountingHeight")
+		assert.ErrorIs(t, err, decoder.ErrStateNotFound)
+	}
+	assert.Equal(t, 2, inner.get("GetInstance"))
+	assert.Equal(t, 1, inner.get("GetInstanceConfig"))
+	assert.Equal(t, 1, inner.get("DecoderNameForApp"))
+	assert.Equal(t, 1, inner.get("GetDeviceMapping"))
+	assert.Equal(t, 1, inner.get("GetState"))
+}
+
+func TestInvalidateOnWrite(t *testing.T) {
+	inner, store := newCountingStore(t)
+	addInstance(t, store)
+	mapping := lorawan.DeviceMapping{InstanceID: "bar", DevEUI: devEUI, ThingID: "thing1", DecoderName: "ldds75", ModelVersion: 1}
+
+	// Unknown devices are cached, until they are provisioned
+	m, err := store.GetDeviceMapping(context.Background(), "bar", devEUI)
+	require.NoError(t, err)
+	require.Nil(t, m)
+	require.NoError(t, store.StoreDeviceMapping(context.Background(), mapping))
+	m, err = store.GetDeviceMapping(context.Background(), "bar", devEUI)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	assert.Equal(t, "thing1", m.ThingID)
+
+	require.NoError(t, store.IgnoreDevice(context.Background(), "bar", devEUI))
+	_, err = store.GetDeviceMapping(context.Background(), "bar", devEUI)
+	assert.ErrorIs(t, err, lorawan.ErrDeviceIgnored)
+
+	_, err = store.GetState(context.Background(), "thing1", "ldds75.mountingHeight")
+	require.ErrorIs(t, err, decoder.ErrStateNotFound)
+	require.NoError(t, store.SetState(context.Background(), "thing1", "ldds75.mountingHeight", []byte("300")))
+	value, err := store.GetState(context.Background(), "thing1", "ldds75.mountingHeight")
+	require.NoError(t, err)
+	assert.Equal(t, "300", string(value))
+
+	// State carried over to a new thing
+	mapping.ThingID = "thing2"
+	_, err = store.GetState(context.Background(), "thing2", "ldds75.mountingHeight")
+	require.ErrorIs(t, err, decoder.ErrStateNotFound)
+	require.NoError(t, store.ReplaceThingID(context.Background(), "thing1", mapping))
+	value, err = store.GetState(context.Background(), "thing2", "ldds75.mountingHeight")
+	require.NoError(t, err)
+	assert.Equal(t, "300", string(value))
+
+	// Config actions change decoder mappings
+	name, err := store.DecoderNameForApp(context.Background(), "bar", 42)
+	require.NoError(t, err)
+	assert.Equal(t, "ldds75", name)
+	resp, err := store.PerformAction(context.Background(), connector.ActionRequest{
+		ThingID:     "thing1",
+		ComponentID: "lora",
+		ActionID:    "addmapping",
+		Parameters:  map[string]string{"ApplicationId": "42", "PayloadDecoder": "dcl571"},
+	})
+	require.NoError(t, err)
+	// thing1 is not the config thing of the instance
+	assert.Equal(t, restapi.ActionRequestStatusFailed, resp.Status)
+	calls := inner.get("DecoderNameForApp")
+	_, err = store.DecoderNameForApp(context.Background(), "bar", 42)
+	require.NoError(t, err)
+	assert.Equal(t, calls+1, inner.get("DecoderNameForApp"))
+
+	_, err = store.GetInstance(context.Background(), "bar")
+	require.NoError(t, err)
+	_, err = store.RemoveInstance(context.Background(), "bar", false)
+	require.NoError(t, err)
+	_, err = store.GetInstance(context.Background(), "bar")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func openSQLite(t testing.TB, client connector.Client) *sqlstore.DB {
+	db, err := sqlstore.NewDB("sqlite://"+filepath.Join(t.TempDir(), "lora.db"), client, "lora.example.com", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	require.NoError(t, db.CreateOrMigrate())
+	return db
+}
+
+func TestSharedInvalidation(t *testing.T) {
+	db := openSQLite(t, &stubClient{})
+	options := cache.Options{TTL: time.Hour, NegativeTTL: time.Hour, Invalidations: db, PollInterval: 10 * time.Millisecond}
+	replica1 := cache.New(db, options)
+	replica2 := cache.New(db, options)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go replica1.Run(ctx)
+	go replica2.Run(ctx)
+	addInstance(t, replica1)
+
+	_, err := replica2.GetState(context.Background(), "thing", "ldds75.mountingHeight")
+	require.ErrorIs(t, err, decoder.ErrStateNotFound)
+	// Wait until replica 2 polls invalidations published after its start
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, replica1.SetState(context.Background(), "thing", "ldds75.mountingHeight", []byte("300")))
+
+	assert.Eventually(t, func() bool {
+		value, err := replica2.GetState(context.Background(), "thing", "ldds75.mountingHeight")
+		return err == nil && string(value) == "300"
+	}, time.Second, 10*time.Millisecond)
+}
+
+// BenchmarkHandleRequest measures uplinks of a provisioned device against SQLite with and without cache
+func BenchmarkHandleRequest(b *testing.B) {
+	logrus.SetLevel(logrus.WarnLevel)
+	defer logrus.SetLevel(logrus.InfoLevel)
+
+	for _, cached := range []bool{false, true} {
+		name := "uncached"
+		if cached {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			client := &stubClient{}
+			var store storage.Store = openSQLite(b, client)
+			if cached {
+				store = cache.New(store, cache.Options{TTL: time.Minute, NegativeTTL: 10 * time.Second})
+			}
+			addInstance(b, store)
+			r := mux.NewRouter()
+			r.Path("/lorawan/{installationId}/{instanceId}").Methods(http.MethodPost).Handler(lorawan.NewLoRaWANHandler(client, true, store))
+			uplink := func() {
+				req := httptest.NewRequest(http.MethodPost, "http://localhost/lorawan/foo/bar?event=up", bytes.NewBufferString(ldds75.TestPayload))
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				if w.Code != http.StatusOK {
+					b.Fatalf("uplink failed with status %d", w.Code)
+				}
+			}
+			// Provision the device
+			uplink()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				uplink()
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Package connclient contains helpers around the connctd connector API client.
+package connclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/connctd/connector-go"
+)
+
+// thingsEndpoint is the path prefix under which the connector API exposes
+// single things
+const thingsEndpoint = "connectorhub/callback/instances/things/"
+
+// ErrThingNotFound is returned by the connector client if a request targeted
+// a thing which does not exist (anymore) on the connctd platform
+var ErrThingNotFound = errors.New("thing not found in connctd")
+
+// IsThingNotFound reports whether err indicates that a thing has been deleted
+// on the connctd platform.
+func IsThingNotFound(err error) bool {
+	return errors.Is(err, ErrThingNotFound)
+}
+
+// Options returns the default connector client options with a HTTP client
+// which reports missing things as ErrThingNotFound. The connector client itself
+// only reports unexpected status codes without any further details.
+func Options() *connector.ClientOptions {
+	opts := connector.DefaultOptions()
+	opts.HTTPClient = NewHTTPClient(opts.HTTPClient)
+	return opts
+}
+
+// Ping checks that the connctd API is reachable. Any response but a server error counts, since
+// the API has no endpoint which can be requested without the token of an instance.
+func Ping(ctx context.Context, opts *connector.ClientOptions) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, opts.ConnctdBaseURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("connctd API responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// NewHTTPClient wraps the transport of the given client, so that 404 responses
+// on thing endpoints are turned into ErrThingNotFound.
+func NewHTTPClient(client *http.Client) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = &statusTransport{next: next}
+	return &c
+}
+
+type statusTransport struct {
+	next http.RoundTripper
+}
+
+func (s *statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := s.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode == http.StatusNotFound && strings.Contains(req.URL.Path, thingsEndpoint) {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, ErrThingNotFound
+	}
+	return resp, nil
+}
@@ -0,0 +1,58 @@
+package connclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThingNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/connectorhub/callback/instances/things/existing/components/c/properties/p" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	opts := Options()
+	opts.ConnctdBaseURL, _ = url.Parse(srv.URL + "/api/v1/")
+	client, err := connector.NewClient(opts, connector.DefaultLogger)
+	require.NoError(t, err)
+
+	err = client.UpdateThingPropertyValue(context.Background(), "token", "existing", "c", "p", "1", time.Now())
+	assert.NoError(t, err)
+
+	err = client.UpdateThingPropertyValue(context.Background(), "token", "deleted", "c", "p", "1", time.Now())
+	assert.True(t, IsThingNotFound(err))
+
+	err = client.UpdateInstanceState(context.Background(), "token", connector.InstantiationStateComplete, nil)
+	assert.Error(t, err)
+	assert.False(t, IsThingNotFound(err))
+}
+
+func TestPing(t *testing.T) {
+	status := http.StatusUnauthorized
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	opts := Options()
+	opts.ConnctdBaseURL, _ = url.Parse(srv.URL + "/api/v1/")
+	assert.NoError(t, Ping(context.Background(), opts))
+
+	status = http.StatusBadGateway
+	assert.Error(t, Ping(context.Background(), opts))
+
+	srv.Close()
+	assert.Error(t, Ping(context.Background(), opts))
+}
@@ -2,47 +2,75 @@ package connhttp
 
 import (
 	"context"
-	"crypto/ed25519"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 
 	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/metrics"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/connctd/lora-connector/tracing"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ConnectorService handles the requests of connctd. Errors are mapped to HTTP status codes, see writeError.
 type ConnectorService interface {
 	AddInstallation(ctx context.Context, req connector.InstallationRequest) error
 	AddInstance(ctx context.Context, req connector.InstantiationRequest) error
 	PerformAction(ctx context.Context, req connector.ActionRequest) (*connector.ActionResponse, error)
+	RemoveInstallation(ctx context.Context, installationID string, dryRun bool) (*storage.RemovalReport, error)
+	RemoveInstance(ctx context.Context, instanceID string, dryRun bool) (*storage.RemovalReport, error)
 }
 
 type ConnectorHandler struct {
 	r       *mux.Router
 	service ConnectorService
+	keys    KeySet
 	logger  logrus.FieldLogger
 }
 
-func NewConnectorHandler(subrouter *mux.Router, service ConnectorService, host string, publicKey ed25519.PublicKey) *ConnectorHandler {
+// NewConnectorHandler registers the routes connctd calls on the subrouter. Requests need to be signed
+// with one of the keys, see KeySet.
+func NewConnectorHandler(subrouter *mux.Router, service ConnectorService, host string, keys KeySet) *ConnectorHandler {
 	c := &ConnectorHandler{
 		r:       subrouter,
 		service: service,
+		keys:    keys,
 		logger:  logrus.WithField("component", "connectorhandler"),
 	}
 	if c.r == nil {
 		c.r = mux.NewRouter()
 	}
-	c.r.Path("/installations").Methods(http.MethodPost).Handler(connector.NewSignatureValidationHandler(
-		connector.ProxiedRequestValidationPreProcessor("https", host, "/connector/installations"), publicKey, c.addInstallation))
-	c.r.Path("/instances").Methods(http.MethodPost).Handler(connector.NewSignatureValidationHandler(
-		connector.ProxiedRequestValidationPreProcessor("https", host, "/connector/instances"), publicKey, c.addInstance))
-	c.r.Path("/actions").Methods(http.MethodPost).Handler(connector.NewSignatureValidationHandler(
-		connector.ProxiedRequestValidationPreProcessor("https", host, "/connector/actions"), publicKey, c.performAction))
+	c.r.Path("/installations").Methods(http.MethodPost).Handler(tracing.Handler("connector.addInstallation", c.validateSignature(
+		connector.ProxiedRequestValidationPreProcessor("https", host, "/connector/installations"), c.addInstallation)))
+	c.r.Path("/instances").Methods(http.MethodPost).Handler(tracing.Handler("connector.addInstance", c.validateSignature(
+		connector.ProxiedRequestValidationPreProcessor("https", host, "/connector/instances"), c.addInstance)))
+	c.r.Path("/actions").Methods(http.MethodPost).Handler(tracing.Handler("connector.performAction", c.validateSignature(
+		connector.ProxiedRequestValidationPreProcessor("https", host, "/connector/actions"), c.performAction)))
+	c.r.Path("/installations/{installationId}").Methods(http.MethodDelete).Handler(tracing.Handler("connector.removeInstallation", c.validateSignature(
+		proxiedRequestURIPreProcessor("https", host), c.removeInstallation)))
+	c.r.Path("/instances/{instanceId}").Methods(http.MethodDelete).Handler(tracing.Handler("connector.removeInstance", c.validateSignature(
+		proxiedRequestURIPreProcessor("https", host), c.removeInstance)))
 	return c
 }
 
+// proxiedRequestURIPreProcessor validates signatures against the public host of the connector,
+// but keeps the request URI, since it carries the ID of the removed resource and the dry run flag
+func proxiedRequestURIPreProcessor(scheme string, host string) connector.ValidationPreProcessor {
+	return func(r *http.Request) connector.ValidationParameters {
+		return connector.ValidationParameters{
+			Scheme:     scheme,
+			Host:       host,
+			RequestURI: r.URL.RequestURI(),
+		}
+	}
+}
+
 func (c *ConnectorHandler) addInstallation(w http.ResponseWriter, r *http.Request) {
 	logger := c.logger.WithFields(logrus.Fields{
 		"client": r.RemoteAddr,
@@ -98,12 +126,18 @@ func (c *ConnectorHandler) performAction(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	trace.SpanFromContext(r.Context()).SetAttributes(
+		attribute.String("thing.id", req.ThingID),
+		attribute.String("action.id", req.ActionID),
+	)
 	resp, err := c.service.PerformAction(r.Context(), req)
 	if err != nil {
+		metrics.ActionRequests.WithLabelValues(req.ActionID, "error").Inc()
 		logger.WithError(err).Error("Failed to perform action")
 		writeError(w, err)
 		return
 	}
+	metrics.ActionRequests.WithLabelValues(req.ActionID, string(resp.Status)).Inc()
 	resp.ID = req.ID
 	b, err := json.Marshal(resp)
 	if err != nil {
@@ -117,6 +151,65 @@ func (c *ConnectorHandler) performAction(w http.ResponseWriter, r *http.Request)
 	w.Write(b)
 }
 
+func (c *ConnectorHandler) removeInstallation(w http.ResponseWriter, r *http.Request) {
+	installationID := mux.Vars(r)["installationId"]
+	dryRun := isDryRun(r)
+	logger := c.logger.WithFields(logrus.Fields{
+		"client":         r.RemoteAddr,
+		"url":            r.URL.String(),
+		"method":         "removeInstallation",
+		"installationId": installationID,
+		"dryRun":         dryRun,
+	})
+
+	report, err := c.service.RemoveInstallation(r.Context(), installationID, dryRun)
+	if err != nil {
+		logger.WithError(err).Error("Failed to remove installation")
+		writeError(w, err)
+		return
+	}
+	logger.WithField("report", report).Info("Removed installation")
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (c *ConnectorHandler) removeInstance(w http.ResponseWriter, r *http.Request) {
+	instanceID := mux.Vars(r)["instanceId"]
+	dryRun := isDryRun(r)
+	logger := c.logger.WithFields(logrus.Fields{
+		"client":     r.RemoteAddr,
+		"url":        r.URL.String(),
+		"method":     "removeInstance",
+		"instanceId": instanceID,
+		"dryRun":     dryRun,
+	})
+
+	report, err := c.service.RemoveInstance(r.Context(), instanceID, dryRun)
+	if err != nil {
+		logger.WithError(err).Error("Failed to remove instance")
+		writeError(w, err)
+		return
+	}
+	logger.WithField("report", report).Info("Removed instance")
+	writeJSON(w, http.StatusOK, report)
+}
+
+// isDryRun reports whether the request only asks what would be removed
+func isDryRun(r *http.Request) bool {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	return dryRun
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
 // helps to decode the request body
 func decodeJSONBody(w http.ResponseWriter, r *http.Request, dest interface{}) error {
 	if r.Header.Get("Content-Type") != "application/json" {
@@ -135,11 +228,18 @@ func decodeJSONBody(w http.ResponseWriter, r *http.Request, dest interface{}) er
 	return nil
 }
 
+// writeError writes errors of the connector service with the status code connctd expects
 func writeError(w http.ResponseWriter, err error) {
 	var e Error
-	if errors.As(err, &e) {
+	switch {
+	case errors.As(err, &e):
+		e.WriteBody(w)
+	case errors.Is(err, storage.ErrNotFound):
+		ErrorNotFound.WriteBody(w)
+	case errors.Is(err, storage.ErrInvalidConfiguration):
+		e = Error{Code: http.StatusBadRequest, Message: err.Error()}
 		e.WriteBody(w)
-	} else {
+	default:
 		e = Error{
 			Code:    http.StatusInternalServerError,
 			Message: err.Error(),
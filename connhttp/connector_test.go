@@ -0,0 +1,112 @@
+package connhttp
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/connctd/api-go/crypto"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testHost = "lora.example.com"
+
+func signRequest(t *testing.T, privateKey ed25519.PrivateKey, r *http.Request) {
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	payload, err := crypto.SignablePayload(r.Method, "https", testHost, r.URL.RequestURI(), r.Header, nil)
+	require.NoError(t, err)
+	r.Header.Set(crypto.SignatureHeaderKey, base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, payload)))
+}
+
+func newTestRouter(t *testing.T, service ConnectorService) (*mux.Router, ed25519.PrivateKey) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	r := mux.NewRouter()
+	NewConnectorHandler(r.PathPrefix("/connector").Subrouter(), service, testHost, StaticKeys{{ID: "test", Key: publicKey}})
+	return r, privateKey
+}
+
+func TestRemoveInstance(t *testing.T) {
+	service := new(MockConnectorService)
+	r, privateKey := newTestRouter(t, service)
+
+	service.On("RemoveInstance", mock.Anything, "inst", true).Return(&storage.RemovalReport{
+		DryRun:    true,
+		Instances: []string{"inst"},
+		Things:    []string{"thing", "config"},
+		Mappings:  1,
+	}, nil)
+	service.On("RemoveInstance", mock.Anything, "unknown", false).Return(nil, storage.ErrNotFound)
+
+	req := httptest.NewRequest(http.MethodDelete, "http://localhost/connector/instances/inst?dryRun=true", nil)
+	signRequest(t, privateKey, req)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var report storage.RemovalReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.True(t, report.DryRun)
+	assert.Equal(t, []string{"thing", "config"}, report.Things)
+
+	req = httptest.NewRequest(http.MethodDelete, "http://localhost/connector/instances/unknown", nil)
+	signRequest(t, privateKey, req)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	service.AssertExpectations(t)
+}
+
+func TestRemoveInstallationRequiresSignature(t *testing.T) {
+	service := new(MockConnectorService)
+	r, _ := newTestRouter(t, service)
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodDelete, "http://localhost/connector/installations/inst", nil)
+	signRequest(t, otherKey, req)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	service.AssertNotCalled(t, "RemoveInstallation", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestKeyRotation(t *testing.T) {
+	service := new(MockConnectorService)
+	service.On("RemoveInstallation", mock.Anything, "inst", true).Return(&storage.RemovalReport{DryRun: true}, nil)
+	oldPublic, oldPrivate, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	newPublic, newPrivate, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	futurePublic, futurePrivate, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	NewConnectorHandler(r.PathPrefix("/connector").Subrouter(), service, testHost, StaticKeys{
+		{ID: "old", Key: oldPublic, NotAfter: time.Now().Add(-time.Minute)},
+		{ID: "new", Key: newPublic, NotBefore: time.Now().Add(-time.Hour)},
+		{ID: "future", Key: futurePublic, NotBefore: time.Now().Add(time.Hour)},
+	})
+	status := func(privateKey ed25519.PrivateKey) int {
+		req := httptest.NewRequest(http.MethodDelete, "http://localhost/connector/installations/inst?dryRun=true", nil)
+		signRequest(t, privateKey, req)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, status(newPrivate))
+	assert.Equal(t, http.StatusBadRequest, status(oldPrivate))
+	assert.Equal(t, http.StatusBadRequest, status(futurePrivate))
+	service.AssertNumberOfCalls(t, "RemoveInstallation", 1)
+}
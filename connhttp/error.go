@@ -10,6 +10,7 @@ var (
 	ErrorMissingInstanceID = Error{Code: http.StatusBadRequest, Message: "Header X-Instance-ID is missing"}
 	ErrorBadRequestBody    = Error{Code: http.StatusBadRequest, Message: "Empty or malformed request body"}
 	ErrorInvalidJsonBody   = Error{Code: http.StatusBadRequest, Message: "Request body does not contain valid json"}
+	ErrorNotFound          = Error{Code: http.StatusNotFound, Message: "Installation or instance does not exist"}
 )
 
 type Error struct {
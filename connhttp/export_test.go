@@ -0,0 +1,7 @@
+package connhttp
+
+// Test helpers for the external tests running against real stores
+var (
+	NewTestRouter = newTestRouter
+	SignRequest   = signRequest
+)
@@ -0,0 +1,190 @@
+package connhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TrustedKey is a public key connctd signs requests with. The key is only trusted within its validity
+// window, a zero NotBefore or NotAfter leaves the window open on that side.
+type TrustedKey struct {
+	ID        string
+	Key       ed25519.PublicKey
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// ValidAt returns whether the key is trusted at t
+func (k TrustedKey) ValidAt(t time.Time) bool {
+	return (k.NotBefore.IsZero() || !t.Before(k.NotBefore)) && (k.NotAfter.IsZero() || t.Before(k.NotAfter))
+}
+
+// KeySet provides the keys signatures of connctd requests are validated with
+type KeySet interface {
+	TrustedKeys() []TrustedKey
+}
+
+// StaticKeys is a key set which never changes
+type StaticKeys []TrustedKey
+
+func (s StaticKeys) TrustedKeys() []TrustedKey {
+	return s
+}
+
+// trustedKeyJSON is a key in a trusted keys file
+type trustedKeyJSON struct {
+	ID        string     `json:"id"`
+	Key       string     `json:"key"`
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	NotAfter  *time.Time `json:"notAfter,omitempty"`
+}
+
+// ParseTrustedKeys parses a JSON list of keys with id, base64 encoded key and the optional RFC 3339
+// times notBefore and notAfter. Key IDs need to be unique, since they are logged to tell which key
+// validated a request.
+func ParseTrustedKeys(b []byte) ([]TrustedKey, error) {
+	var entries []trustedKeyJSON
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("invalid trusted keys: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no trusted keys")
+	}
+	keys := make([]TrustedKey, len(entries))
+	ids := make(map[string]bool, len(entries))
+	for i, e := range entries {
+		if e.ID == "" {
+			return nil, fmt.Errorf("trusted key %d has no id", i)
+		}
+		if ids[e.ID] {
+			return nil, fmt.Errorf("duplicate trusted key %s", e.ID)
+		}
+		ids[e.ID] = true
+		key, err := base64.StdEncoding.DecodeString(e.Key)
+		if err != nil {
+			return nil, fmt.Errorf("trusted key %s is not base64 encoded: %w", e.ID, err)
+		}
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("trusted key %s has %d bytes, expected %d", e.ID, len(key), ed25519.PublicKeySize)
+		}
+		keys[i] = TrustedKey{ID: e.ID, Key: ed25519.PublicKey(key)}
+		if e.NotBefore != nil {
+			keys[i].NotBefore = *e.NotBefore
+		}
+		if e.NotAfter != nil {
+			keys[i].NotAfter = *e.NotAfter
+		}
+		if k := keys[i]; !k.NotBefore.IsZero() && !k.NotAfter.IsZero() && !k.NotAfter.After(k.NotBefore) {
+			return nil, fmt.Errorf("trusted key %s expires before it becomes valid", e.ID)
+		}
+	}
+	return keys, nil
+}
+
+// RawKeyParser returns a parser for files containing a single raw ed25519 public key, which is
+// trusted under the given ID
+func RawKeyParser(id string) func(b []byte) ([]TrustedKey, error) {
+	return func(b []byte) ([]TrustedKey, error) {
+		if len(b) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("public key has %d bytes, expected %d", len(b), ed25519.PublicKeySize)
+		}
+		return []TrustedKey{{ID: id, Key: ed25519.PublicKey(b)}}, nil
+	}
+}
+
+// KeyFile is a key set read from a file, which is read again when it changes
+type KeyFile struct {
+	path   string
+	parse  func(b []byte) ([]TrustedKey, error)
+	logger logrus.FieldLogger
+
+	mu      sync.RWMutex
+	content []byte
+	keys    []TrustedKey
+}
+
+// NewKeyFile reads the keys from the file at path. It fails if the file can't be read or parsed.
+func NewKeyFile(path string, parse func(b []byte) ([]TrustedKey, error)) (*KeyFile, error) {
+	f := &KeyFile{
+		path:   path,
+		parse:  parse,
+		logger: logrus.WithFields(logrus.Fields{"component": "keyfile", "path": path}),
+	}
+	if _, err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *KeyFile) TrustedKeys() []TrustedKey {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.keys
+}
+
+// Reload reads the file and replaces the keys if its content changed. The keys are kept if the file
+// can't be read or parsed, so that a half written file doesn't lock out connctd.
+func (f *KeyFile) Reload() (changed bool, err error) {
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return false, err
+	}
+	f.mu.RLock()
+	unchanged := f.content != nil && bytes.Equal(b, f.content)
+	f.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	keys, err := f.parse(b)
+	if err != nil {
+		return false, err
+	}
+	f.mu.Lock()
+	f.content, f.keys = b, keys
+	f.mu.Unlock()
+	return true, nil
+}
+
+// Run reloads the file in the interval until ctx is done. Kubernetes updates mounted secrets by
+// replacing a symlink, which file system notifications on the file don't report reliably, so the
+// file is polled.
+func (f *KeyFile) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// Errors are logged once until they change, a broken file is polled until it is fixed
+	lastErr := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := f.Reload()
+		if err != nil {
+			if err.Error() != lastErr {
+				f.logger.WithError(err).Error("Failed to reload trusted keys, keeping the previous keys")
+			}
+			lastErr = err.Error()
+			continue
+		}
+		lastErr = ""
+		if changed {
+			keys := f.TrustedKeys()
+			ids := make([]string, 0, len(keys))
+			for _, key := range keys {
+				ids = append(ids, key.ID)
+			}
+			f.logger.WithField("keys", ids).Info("Reloaded trusted keys")
+		}
+	}
+}
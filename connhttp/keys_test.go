@@ -0,0 +1,76 @@
+package connhttp
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrustedKeys(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	key := base64.StdEncoding.EncodeToString(publicKey)
+
+	keys, err := ParseTrustedKeys([]byte(`[
+		{"id": "2023", "key": "` + key + `", "notAfter": "2024-01-01T00:00:00Z"},
+		{"id": "2024", "key": "` + key + `", "notBefore": "2023-12-01T00:00:00Z"}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "2023", keys[0].ID)
+	assert.Equal(t, publicKey, keys[0].Key)
+	assert.True(t, keys[0].ValidAt(time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, keys[0].ValidAt(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, keys[1].ValidAt(time.Date(2023, 11, 30, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, keys[1].ValidAt(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	for name, invalid := range map[string]string{
+		"empty":     `[]`,
+		"no id":     `[{"key": "` + key + `"}]`,
+		"duplicate": `[{"id": "a", "key": "` + key + `"}, {"id": "a", "key": "` + key + `"}]`,
+		"short":     `[{"id": "a", "key": "AAAA"}]`,
+		"window":    `[{"id": "a", "key": "` + key + `", "notBefore": "2024-01-01T00:00:00Z", "notAfter": "2023-01-01T00:00:00Z"}]`,
+		"no json":   `a`,
+	} {
+		_, err := ParseTrustedKeys([]byte(invalid))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestKeyFileReload(t *testing.T) {
+	first, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	second, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "pubkey")
+	require.NoError(t, ioutil.WriteFile(path, first, 0600))
+
+	f, err := NewKeyFile(path, RawKeyParser("pubkey"))
+	require.NoError(t, err)
+	assert.Equal(t, StaticKeys{{ID: "pubkey", Key: first}}.TrustedKeys(), f.TrustedKeys())
+
+	changed, err := f.Reload()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// Invalid content keeps the previous keys
+	require.NoError(t, ioutil.WriteFile(path, second[:16], 0600))
+	_, err = f.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, ed25519.PublicKey(first), f.TrustedKeys()[0].Key)
+
+	require.NoError(t, ioutil.WriteFile(path, second, 0600))
+	changed, err = f.Reload()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, ed25519.PublicKey(second), f.TrustedKeys()[0].Key)
+
+	_, err = NewKeyFile(filepath.Join(t.TempDir(), "missing"), RawKeyParser("pubkey"))
+	assert.Error(t, err)
+}
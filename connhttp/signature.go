@@ -0,0 +1,86 @@
+package connhttp
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/connctd/api-go/crypto"
+	"github.com/connctd/connector-go"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// signatureHandler validates the signature of connctd requests like connector.NewSignatureValidationHandler,
+// but accepts every key of the key set which is valid at the time of the request
+type signatureHandler struct {
+	preProcessor connector.ValidationPreProcessor
+	keys         KeySet
+	next         http.HandlerFunc
+	logger       logrus.FieldLogger
+}
+
+func (c *ConnectorHandler) validateSignature(preProcessor connector.ValidationPreProcessor, next http.HandlerFunc) http.Handler {
+	return &signatureHandler{
+		preProcessor: preProcessor,
+		keys:         c.keys,
+		next:         next,
+		logger:       c.logger,
+	}
+}
+
+func (h *signatureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := h.logger.WithFields(logrus.Fields{
+		"client": r.RemoteAddr,
+		"url":    r.URL.String(),
+	})
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get(crypto.SignatureHeaderKey))
+	if err != nil {
+		connector.ErrorBadSignature.Write(w)
+		return
+	}
+
+	var body []byte
+	if r.ContentLength != 0 {
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			connector.ErrorInvalidBody.Write(w)
+			return
+		}
+		r.Body.Close()
+	}
+
+	params := h.preProcessor(r)
+	payload, err := crypto.SignablePayload(r.Method, params.Scheme, params.Host, params.RequestURI, r.Header, body)
+	if err != nil {
+		if errors.Is(err, crypto.ErrorMissingHeader) {
+			crypto.ErrorMissingHeader.Write(w)
+			return
+		}
+		connector.ErrorSigningFailed.Write(w)
+		return
+	}
+
+	now := time.Now()
+	trusted := 0
+	for _, key := range h.keys.TrustedKeys() {
+		if !key.ValidAt(now) {
+			continue
+		}
+		trusted++
+		if ed25519.Verify(key.Key, payload, signature) {
+			logger.WithField("keyId", key.ID).Info("Validated signature of connctd request")
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("connector.key_id", key.ID))
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			h.next.ServeHTTP(w, r)
+			return
+		}
+	}
+	logger.WithField("trustedKeys", trusted).Warn("Rejected connctd request, signature does not match any trusted key")
+	connector.ErrorBadSignature.Write(w)
+}
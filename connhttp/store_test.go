@@ -0,0 +1,51 @@
+package connhttp_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/connhttp"
+	"github.com/connctd/lora-connector/memstore"
+	"github.com/connctd/lora-connector/mocks"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/connctd/restapi-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveInstallationFromStore(t *testing.T) {
+	ctx := context.Background()
+	client := &mocks.Client{}
+	client.On("UpdateInstallationState", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	client.On("UpdateInstanceState", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	client.On("UpdateThingPropertyValue", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	client.On("CreateThing", mock.Anything, mock.Anything, mock.Anything).Return(restapi.Thing{ID: "configthing"}, nil)
+	client.On("DeleteThing", mock.Anything, connector.InstantiationToken("instancetoken"), "configthing").Return(nil).Once()
+
+	store := memstore.New(client, "lora.example.com")
+	require.NoError(t, store.AddInstallation(ctx, connector.InstallationRequest{ID: "inst", Token: "installationtoken"}))
+	require.NoError(t, store.AddInstance(ctx, connector.InstantiationRequest{ID: "instance", InstallationID: "inst", Token: "instancetoken"}))
+
+	r, privateKey := connhttp.NewTestRouter(t, store)
+	req := httptest.NewRequest(http.MethodDelete, "http://localhost/connector/installations/inst", nil)
+	connhttp.SignRequest(t, privateKey, req)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var report storage.RemovalReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, []string{"instance"}, report.Instances)
+	assert.Equal(t, []string{"configthing"}, report.Things)
+
+	_, err := store.GetInstance(context.Background(), "instance")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = store.GetInstallationToken(context.Background(), "inst")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	client.AssertExpectations(t)
+}
@@ -0,0 +1,123 @@
+// Package health reports whether the connector is alive and whether it is ready to receive
+// traffic. Readiness is determined by checks of the dependencies of the connector.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Status of a check and of the whole report
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+// CheckFunc checks a single dependency, it needs to return once ctx is done
+type CheckFunc func(ctx context.Context) error
+
+// Result is the outcome of a single check
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report lists the results of all checks in the order they were added. Its status is failed if any
+// check failed.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type check struct {
+	name string
+	f    CheckFunc
+}
+
+// Checker runs all checks concurrently, checks taking longer than the timeout fail
+type Checker struct {
+	timeout time.Duration
+	checks  []check
+}
+
+// NewChecker returns a checker without any checks
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add adds a check, checks need to be added before the checker is used
+func (c *Checker) Add(name string, f CheckFunc) {
+	c.checks = append(c.checks, check{name: name, f: f})
+}
+
+// Run runs all checks
+func (c *Checker) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{
+		Status: StatusOK,
+		Checks: make([]Result, len(c.checks)),
+	}
+	var wg sync.WaitGroup
+	for i, chk := range c.checks {
+		wg.Add(1)
+		go func(i int, chk check) {
+			defer wg.Done()
+			report.Checks[i] = run(ctx, chk)
+		}(i, chk)
+	}
+	wg.Wait()
+	for _, result := range report.Checks {
+		if result.Status != StatusOK {
+			report.Status = StatusFailed
+		}
+	}
+	return report
+}
+
+func run(ctx context.Context, chk check) Result {
+	result := Result{
+		Name:   chk.name,
+		Status: StatusOK,
+	}
+	start := time.Now()
+	err := chk.f(ctx)
+	if err == nil {
+		// Checks ignoring ctx must not report success after the timeout
+		err = ctx.Err()
+	}
+	result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// ServeHTTP answers with the report of all checks, the status code is 503 Service Unavailable if
+// any check failed
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// LivenessHandler answers as long as the process is able to serve requests. Dependencies are not
+// checked, since restarting the connector doesn't fix them.
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Report{Status: StatusOK, Checks: []Result{}})
+}
@@ -0,0 +1,53 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker(t *testing.T) {
+	checker := NewChecker(50 * time.Millisecond)
+	checker.Add("database", func(ctx context.Context) error { return nil })
+	checker.Add("connctd", func(ctx context.Context) error { return errors.New("unreachable") })
+	checker.Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	checker.Add("ignoring", func(ctx context.Context) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	checker.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var report Report
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+	assert.Equal(t, StatusFailed, report.Status)
+	require.Len(t, report.Checks, 4)
+	assert.Equal(t, Result{Name: "database", Status: StatusOK, LatencyMs: report.Checks[0].LatencyMs}, report.Checks[0])
+	assert.Equal(t, "connctd", report.Checks[1].Name)
+	assert.Equal(t, "unreachable", report.Checks[1].Error)
+	assert.Equal(t, StatusFailed, report.Checks[2].Status)
+	assert.Equal(t, StatusFailed, report.Checks[3].Status)
+	assert.GreaterOrEqual(t, report.Checks[3].LatencyMs, float64(100))
+}
+
+func TestCheckerOK(t *testing.T) {
+	checker := NewChecker(time.Second)
+	checker.Add("database", func(ctx context.Context) error { return nil })
+
+	rec := httptest.NewRecorder()
+	checker.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"status":"ok"`)
+}
@@ -0,0 +1,154 @@
+package lorawan
+
+import (
+	"context"
+	"time"
+
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/connctd/lora-connector/metrics"
+)
+
+// EventOutcome describes how the connector processed a received event
+type EventOutcome string
+
+const (
+	// EventOutcomeProcessed events have been handled completely
+	EventOutcomeProcessed EventOutcome = "processed"
+	// EventOutcomeIgnored events have been dropped on purpose, e.g. uplinks of ignored devices
+	EventOutcomeIgnored EventOutcome = "ignored"
+	// EventOutcomeRejected events could not be parsed or are of an unknown type
+	EventOutcomeRejected EventOutcome = "rejected"
+	// EventOutcomeFailed events could not be processed because of an error of the connector,
+	// the database or connctd
+	EventOutcomeFailed EventOutcome = "failed"
+)
+
+// Encodings of archived events
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf"
+)
+
+// ArchivedEvent is a received event as it was sent by the network server, together with the
+// outcome of processing it. Archived events are kept for the retention configured for the instance.
+type ArchivedEvent struct {
+	ID         uint64
+	InstanceID string
+	// Event is the event type, e.g. up or error
+	Event    string
+	Encoding string
+	Body     []byte
+	// DevEUI and FCnt are only set for events which could be parsed
+	DevEUI     []byte
+	FCnt       uint32
+	ReceivedAt time.Time
+	Outcome    EventOutcome
+	// Error describes why the event was not processed
+	Error string
+	// Updates are the property updates pushed to connctd for the event, they are replaced when the
+	// event is replayed
+	Updates []decoder.PropertyUpdate
+}
+
+// ArchiveFilter selects archived events. Zero values match all events.
+type ArchiveFilter struct {
+	InstanceID string
+	DevEUI     []byte
+	// From and To limit the time the events were received, To is exclusive
+	From time.Time
+	To   time.Time
+	// Outcomes limits the events to the given outcomes
+	Outcomes []EventOutcome
+	// Limit is the maximum number of events returned, ordered by the time they were received
+	Limit int
+	// Latest returns the latest events first, so that Limit selects the most recent events
+	Latest bool
+	// ByID orders the events by ID instead of the time they were received, so that AfterID can
+	// page through the archive together with Limit
+	ByID bool
+	// AfterID returns only events with a larger ID
+	AfterID uint64
+}
+
+// setOutcome records the outcome of the event, err is optional
+func (e *ArchivedEvent) setOutcome(outcome EventOutcome, err error) {
+	e.Outcome = outcome
+	if err != nil {
+		e.Error = err.Error()
+	}
+}
+
+// setDevice records the device which sent the event
+func (e *ArchivedEvent) setDevice(devEUI []byte, fCnt uint32) {
+	e.DevEUI = devEUI
+	e.FCnt = fCnt
+}
+
+// archiveQueueSize limits the events waiting to be archived. Events are dropped while the queue is
+// full, so that a slow database doesn't hold up the responses to the network server.
+const archiveQueueSize = 1024
+
+// archiveRequest is an event waiting to be archived together with the context it was received in
+type archiveRequest struct {
+	ctx   context.Context
+	event ArchivedEvent
+}
+
+// archive queues the event to be stored by RunArchiver. Failures are logged, they don't fail the event.
+// Events are archived even if the network server canceled the request.
+func (l *LoRaWANHandler) archive(ctx context.Context, event ArchivedEvent) {
+	select {
+	case l.archiveQueue <- archiveRequest{ctx: detachedContext{ctx}, event: event}:
+		metrics.ArchiveQueueLength.Inc()
+	default:
+		metrics.ArchiveDropped.Inc()
+		l.logger.WithField("instanceId", event.InstanceID).Warn("Archive queue is full, dropping event")
+	}
+}
+
+// RunArchiver stores queued events until ctx is done. Events queued until then are stored before it
+// returns, so it should be stopped after the requests in flight have been handled.
+func (l *LoRaWANHandler) RunArchiver(ctx context.Context) {
+	for {
+		select {
+		case req := <-l.archiveQueue:
+			l.storeArchived(req)
+		case <-ctx.Done():
+			for {
+				select {
+				case req := <-l.archiveQueue:
+					l.storeArchived(req)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// storeArchived stores the event, unless archiving is disabled for the instance
+func (l *LoRaWANHandler) storeArchived(req archiveRequest) {
+	metrics.ArchiveQueueLength.Dec()
+	logger := l.logger.WithField("instanceId", req.event.InstanceID)
+	config, err := l.store.GetInstanceConfig(req.ctx, req.event.InstanceID)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to retrieve archive retention of instance")
+		return
+	}
+	if config.ArchiveRetentionDays <= 0 {
+		return
+	}
+	if err := l.store.ArchiveEvent(req.ctx, req.event); err != nil {
+		logger.WithError(err).Warn("Failed to archive event")
+	}
+}
+
+// detachedContext keeps the values of a context, like the current span, but is never canceled
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (d detachedContext) Value(key interface{}) interface{} { return d.parent.Value(key) }
@@ -0,0 +1,143 @@
+package lorawan
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/sirupsen/logrus"
+)
+
+// NetworkServer identifies the type of LoRaWAN network server an instance receives events from
+type NetworkServer string
+
+// NetworkServerChirpStack is currently the only supported network server
+const NetworkServerChirpStack NetworkServer = "chirpstack"
+
+// IDs of the configuration parameters declared for installations and instances of the connector
+const (
+	ConfigNetworkServer        = "network_server"
+	ConfigChirpStackAPIURL     = "chirpstack_api_url"
+	ConfigChirpStackAPIKey     = "chirpstack_api_key"
+	ConfigDefaultDecoder       = "default_decoder"
+	ConfigTimezone             = "timezone"
+	ConfigProvisioningPolicy   = "provisioning_policy"
+	ConfigArchiveRetentionDays = "archive_retention_days"
+)
+
+// DefaultArchiveRetentionDays is how long received events are archived if nothing is configured
+const DefaultArchiveRetentionDays = 30
+
+// InstanceConfig is the configuration of an instance set by the user in connctd.
+// Values configured for the installation act as defaults for all of its instances.
+type InstanceConfig struct {
+	NetworkServer    NetworkServer
+	ChirpStackAPIURL string
+	ChirpStackAPIKey string
+	// DefaultDecoder is used for LoRaWAN applications without an explicit decoder mapping
+	DefaultDecoder     string
+	Timezone           string
+	ProvisioningPolicy ProvisioningPolicy
+	// ArchiveRetentionDays is the number of days received events are archived, 0 disables the archive
+	ArchiveRetentionDays int
+}
+
+// DefaultInstanceConfig returns the configuration of instances which did not configure anything
+func DefaultInstanceConfig() InstanceConfig {
+	return InstanceConfig{
+		NetworkServer:        NetworkServerChirpStack,
+		Timezone:             "UTC",
+		ProvisioningPolicy:   ProvisioningPolicyRecreate,
+		ArchiveRetentionDays: DefaultArchiveRetentionDays,
+	}
+}
+
+// ConfigurationError describes an invalid configuration parameter
+type ConfigurationError struct {
+	Parameter string
+	Reason    string
+}
+
+func (c ConfigurationError) Error() string {
+	return fmt.Sprintf("invalid configuration parameter %s: %s", c.Parameter, c.Reason)
+}
+
+// ParseInstanceConfig applies the configuration parameters of installation and instance on top of the
+// default configuration and validates the result. Parameters of the instance take precedence.
+func ParseInstanceConfig(installation, instance []connector.Configuration) (InstanceConfig, error) {
+	config := DefaultInstanceConfig()
+	for _, params := range [][]connector.Configuration{installation, instance} {
+		for _, param := range params {
+			if err := config.set(param.ID, param.Value); err != nil {
+				return config, err
+			}
+		}
+	}
+	return config, config.Validate()
+}
+
+func (c *InstanceConfig) set(id, value string) error {
+	if value == "" {
+		// Parameters left empty in connctd keep their default
+		return nil
+	}
+	switch id {
+	case ConfigNetworkServer:
+		c.NetworkServer = NetworkServer(value)
+	case ConfigChirpStackAPIURL:
+		c.ChirpStackAPIURL = value
+	case ConfigChirpStackAPIKey:
+		c.ChirpStackAPIKey = value
+	case ConfigDefaultDecoder:
+		c.DefaultDecoder = value
+	case ConfigTimezone:
+		c.Timezone = value
+	case ConfigProvisioningPolicy:
+		c.ProvisioningPolicy = ProvisioningPolicy(value)
+	case ConfigArchiveRetentionDays:
+		days, err := strconv.Atoi(value)
+		if err != nil {
+			return ConfigurationError{Parameter: id, Reason: "needs to be a number of days"}
+		}
+		c.ArchiveRetentionDays = days
+	default:
+		// Parameters may be added to the connector in connctd before this version knows them, rejecting
+		// them would fail every new instance until the connector is updated
+		logrus.WithField("parameter", id).Warn("Ignoring unknown configuration parameter")
+	}
+	return nil
+}
+
+// Validate checks all values of the configuration
+func (c InstanceConfig) Validate() error {
+	if c.NetworkServer != NetworkServerChirpStack {
+		return ConfigurationError{Parameter: ConfigNetworkServer, Reason: "only chirpstack is supported"}
+	}
+	if c.ChirpStackAPIURL != "" {
+		u, err := url.Parse(c.ChirpStackAPIURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ConfigurationError{Parameter: ConfigChirpStackAPIURL, Reason: "needs to be an absolute http(s) URL"}
+		}
+		if c.ChirpStackAPIKey == "" {
+			return ConfigurationError{Parameter: ConfigChirpStackAPIKey, Reason: "required if an API URL is configured"}
+		}
+	} else if c.ChirpStackAPIKey != "" {
+		return ConfigurationError{Parameter: ConfigChirpStackAPIURL, Reason: "required if an API key is configured"}
+	}
+	if c.DefaultDecoder != "" && decoder.GetDecoder(c.DefaultDecoder) == nil {
+		return ConfigurationError{Parameter: ConfigDefaultDecoder, Reason: "no decoder with this name"}
+	}
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		return ConfigurationError{Parameter: ConfigTimezone, Reason: "unknown timezone"}
+	}
+	if _, err := ParseProvisioningPolicy(string(c.ProvisioningPolicy)); err != nil {
+		return ConfigurationError{Parameter: ConfigProvisioningPolicy, Reason: err.Error()}
+	}
+	if c.ArchiveRetentionDays < 0 {
+		return ConfigurationError{Parameter: ConfigArchiveRetentionDays, Reason: "must not be negative"}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package lorawan
+
+import (
+	"testing"
+
+	"github.com/connctd/connector-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInstanceConfig(t *testing.T) {
+	config, err := ParseInstanceConfig(nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultInstanceConfig(), config)
+
+	config, err = ParseInstanceConfig([]connector.Configuration{
+		{ID: ConfigTimezone, Value: "Europe/Berlin"},
+		{ID: ConfigDefaultDecoder, Value: "ldds75"},
+	}, []connector.Configuration{
+		{ID: ConfigDefaultDecoder, Value: "dcl571"},
+		{ID: ConfigProvisioningPolicy, Value: "ignore-deleted"},
+		{ID: ConfigChirpStackAPIURL, Value: "https://chirpstack.example.com"},
+		{ID: ConfigChirpStackAPIKey, Value: "secret"},
+		{ID: ConfigNetworkServer, Value: ""},
+		{ID: ConfigArchiveRetentionDays, Value: "0"},
+		{ID: "added_later", Value: "value"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, InstanceConfig{
+		NetworkServer:        NetworkServerChirpStack,
+		ChirpStackAPIURL:     "https://chirpstack.example.com",
+		ChirpStackAPIKey:     "secret",
+		DefaultDecoder:       "dcl571",
+		Timezone:             "Europe/Berlin",
+		ProvisioningPolicy:   ProvisioningPolicyIgnoreDeleted,
+		ArchiveRetentionDays: 0,
+	}, config)
+}
+
+func TestParseInvalidInstanceConfig(t *testing.T) {
+	for param, configuration := range map[string][]connector.Configuration{
+		ConfigNetworkServer:        {{ID: ConfigNetworkServer, Value: "ttn"}},
+		ConfigChirpStackAPIURL:     {{ID: ConfigChirpStackAPIURL, Value: "chirpstack:8080"}},
+		ConfigChirpStackAPIKey:     {{ID: ConfigChirpStackAPIURL, Value: "https://chirpstack.example.com"}},
+		ConfigDefaultDecoder:       {{ID: ConfigDefaultDecoder, Value: "unknown"}},
+		ConfigTimezone:             {{ID: ConfigTimezone, Value: "Mars/Olympus_Mons"}},
+		ConfigProvisioningPolicy:   {{ID: ConfigProvisioningPolicy, Value: "delete"}},
+		ConfigArchiveRetentionDays: {{ID: ConfigArchiveRetentionDays, Value: "-1"}},
+	} {
+		_, err := ParseInstanceConfig(nil, configuration)
+		var configErr ConfigurationError
+		require.ErrorAs(t, err, &configErr, param)
+		assert.Equal(t, param, configErr.Parameter)
+	}
+}
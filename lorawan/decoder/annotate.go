@@ -0,0 +1,63 @@
+package decoder
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Field describes a value a decoder reads from a payload
+type Field struct {
+	Name string
+	// Offsets of the bytes the value is composed of, in the order they are combined
+	Offsets []int
+	Value   string
+}
+
+// AnnotatingDecoder can be implemented by payload decoders to explain which bytes of a payload they
+// read. It is used to debug payloads offline, so it should also describe bytes which are ignored
+// by DecodeMessage.
+type AnnotatingDecoder interface {
+	Annotate(fport uint32, msg []byte) []Field
+}
+
+// Annotate returns the fields the decoder reads from the payload or nil if the decoder can't
+// annotate payloads
+func Annotate(d PayloadDecoder, fport uint32, msg []byte) []Field {
+	if a, ok := d.(AnnotatingDecoder); ok {
+		return a.Annotate(fport, msg)
+	}
+	return nil
+}
+
+// Bytes returns the bytes of the field in the order they are combined
+func (f Field) Bytes(msg []byte) []byte {
+	b := make([]byte, 0, len(f.Offsets))
+	for _, offset := range f.Offsets {
+		if offset >= 0 && offset < len(msg) {
+			b = append(b, msg[offset])
+		}
+	}
+	return b
+}
+
+// ParsePayload decodes a hex or base64 encoded payload. Hex bytes may be separated by spaces, dashes
+// or colons and prefixed with 0x. Strings which are valid hex are parsed as hex.
+func ParsePayload(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("empty payload")
+	}
+	hexString := strings.NewReplacer(" ", "", "-", "", ":", "", "\n", "", "\t", "").Replace(strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X"))
+	if b, err := hex.DecodeString(hexString); err == nil {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	if b, err := base64.RawStdEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return nil, errors.New("payload is neither hex nor base64 encoded")
+}
@@ -0,0 +1,26 @@
+package decoder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePayload(t *testing.T) {
+	expected := []byte{0x0d, 0x08, 0x00, 0xf9, 0x00}
+	for _, s := range []string{"0d0800f900", "0x0D0800F900", "0d 08 00 f9 00", "0d:08:00:f9:00", "DQgA+QA=", "DQgA+QA", " DQgA+QA=\n"} {
+		payload, err := ParsePayload(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, payload, s)
+	}
+	for _, s := range []string{"", "0x0d0", "not a payload!"} {
+		_, err := ParsePayload(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestFieldBytes(t *testing.T) {
+	field := Field{Offsets: []int{3, 0, 7}}
+	assert.Equal(t, []byte{0x03, 0x00}, field.Bytes([]byte{0x00, 0x01, 0x02, 0x03}))
+}
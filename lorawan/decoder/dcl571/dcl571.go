@@ -11,15 +11,16 @@ import (
 	"github.com/connctd/lora-connector/lorawan/decoder"
 	"github.com/connctd/restapi-go"
 	"github.com/sirupsen/logrus"
-	"gorm.io/gorm"
 )
 
 const metersPerBar = 10.1972
 
 const mmH2OPerBar = 0.0000980638
 
+const decoderName = "dcl571"
+
 func init() {
-	decoder.RegisterDecoder("dcl571", dcl571decoder{})
+	decoder.RegisterDecoder(decoderName, dcl571decoder{})
 }
 
 type dcl571decoder struct{}
@@ -129,12 +130,34 @@ func (d dcl571decoder) Device(attributes []restapi.ThingAttribute) (*restapi.Thi
 	}, nil
 }
 
+// MigrateLegacyState implements decoder.LegacyStateDecoder.
+// The water level offset used to be stored in tenths of centimeters as varint.
+func (d dcl571decoder) MigrateLegacyState(legacyKey string, value []byte) (string, []byte, bool, error) {
+	if legacyKey != "waterLevelOffset" {
+		return "", nil, false, nil
+	}
+	newValue, err := decoder.ParseLegacyTenths(value)
+	return legacyKey, newValue, true, err
+}
+
+// PerformAction implements decoder.ActionDecoder.
+func (d dcl571decoder) PerformAction(store decoder.DecoderStateStore, thingID, actionID string, parameters map[string]string) error {
+	if actionID != "setWaterLevelOffset" {
+		return decoder.ErrUnknownAction
+	}
+	value, err := decoder.FloatParameter(parameters, "offset", "offset in centimeters")
+	if err != nil {
+		return err
+	}
+	return decoder.NewState(store, decoderName, thingID).SetFloat("waterLevelOffset", value)
+}
+
 func (d dcl571decoder) DecodeMessage(store decoder.DecoderStateStore, fport uint32, msg []byte, thingID string) ([]decoder.PropertyUpdate, error) {
 	updates := []decoder.PropertyUpdate{}
 	logger := logrus.WithFields(logrus.Fields{
 		"thingId": thingID,
 		"fport":   fport,
-		"decoder": "dcl571",
+		"decoder": decoderName,
 		"msgLen":  len(msg),
 	})
 
@@ -151,22 +174,11 @@ func (d dcl571decoder) DecodeMessage(store decoder.DecoderStateStore, fport uint
 				UpdateTime:  time.Now(),
 			})
 
-			val, err := store.GetState(thingID, "waterLevelOffset")
+			waterLevelOffset, err := decoder.NewState(store, decoderName, thingID).Float("waterLevelOffset", 0)
 			if err != nil {
-				if err == gorm.ErrRecordNotFound {
-					val = make([]byte, 4)
-					n := binary.PutVarint(val, 0)
-					err = store.SetState(thingID, "waterLevelOffset", val[:n])
-					if err != nil {
-						return nil, err
-					}
-					val = val[:n]
-				} else {
-					return nil, err
-				}
+				return nil, err
 			}
-			waterLevelOffsetMM, _ := binary.Varint(val)
-			waterLevel := (float32(waterLevelOffsetMM) + pressure) / 10.0
+			waterLevel := float32(waterLevelOffset) + pressure/10.0
 
 			updates = append(updates, decoder.PropertyUpdate{
 				ThingID:     thingID,
@@ -182,6 +194,34 @@ func (d dcl571decoder) DecodeMessage(store decoder.DecoderStateStore, fport uint
 	return updates, nil
 }
 
+// Annotate implements decoder.AnnotatingDecoder.
+// The payload is a Modbus telegram of the BD-Sensor, of which only the sensor address, the serial
+// number and the actual pressure are known.
+func (d dcl571decoder) Annotate(fport uint32, msg []byte) []decoder.Field {
+	var fields []decoder.Field
+	if len(msg) > 47 {
+		fields = append(fields, decoder.Field{
+			Name:    "sensor address",
+			Offsets: []int{47},
+			Value:   fmt.Sprintf("0x%02x", msg[47]),
+		})
+	}
+	if len(msg) > 60 {
+		serial := decoder.Field{Name: "serial number", Offsets: []int{54, 53, 60, 59}}
+		serial.Value = fmt.Sprintf("%d", binary.BigEndian.Uint32(serial.Bytes(msg)))
+		fields = append(fields, serial)
+	}
+	if len(msg) >= 65+8 {
+		pressure, _ := decodePressureValue(msg[65:])
+		fields = append(fields, decoder.Field{
+			Name:    "actual pressure",
+			Offsets: []int{71, 72, 65, 66},
+			Value:   fmt.Sprintf("%f mmH2O", pressure),
+		})
+	}
+	return fields
+}
+
 func decodePressureValue(msg []byte) (float32, error) {
 	if len(msg) < 8 {
 		return 0.0, errors.New("message to small to contain valid pressure value")
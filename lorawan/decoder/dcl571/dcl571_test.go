@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"testing"
 
+	"github.com/connctd/lora-connector/lorawan/decoder"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -26,3 +27,57 @@ func TestDecodePressureValue(t *testing.T) {
 	pressure, _ = decodePressureValue(payload[65:])
 	assert.EqualValues(t, float32(131.486496), pressure)
 }
+
+type stateStore map[string][]byte
+
+func (s stateStore) GetState(thingID, key string) ([]byte, error) {
+	value, ok := s[thingID+"/"+key]
+	if !ok {
+		return nil, decoder.ErrStateNotFound
+	}
+	return value, nil
+}
+
+func (s stateStore) SetState(thingID, key string, value []byte) error {
+	s[thingID+"/"+key] = value
+	return nil
+}
+
+func TestSetWaterLevelOffset(t *testing.T) {
+	store := stateStore{}
+	d := dcl571decoder{}
+
+	err := d.PerformAction(store, "foothing", "setWaterLevelOffset", map[string]string{"offset": "12.5"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("12.5"), store["foothing/dcl571.waterLevelOffset"])
+
+	payload, err := base64.RawStdEncoding.DecodeString(base64Payloads[0])
+	require.NoError(t, err)
+	updates, err := d.DecodeMessage(store, 1, payload, "foothing")
+	require.NoError(t, err)
+	require.Len(t, updates, 2)
+	assert.Equal(t, "25.609730", updates[1].Value)
+
+	err = d.PerformAction(store, "foothing", "setWaterLevelOffset", map[string]string{"offset": "deep"})
+	assert.ErrorAs(t, err, &decoder.ParameterError{})
+
+	err = d.PerformAction(store, "foothing", "setMountingHeight", map[string]string{"mountingHeight": "1"})
+	assert.ErrorIs(t, err, decoder.ErrUnknownAction)
+}
+
+func TestAnnotate(t *testing.T) {
+	payload, err := base64.RawStdEncoding.DecodeString(base64Payloads[1])
+	require.NoError(t, err)
+
+	fields := decoder.Annotate(dcl571decoder{}, 1, payload)
+	require.Len(t, fields, 3)
+	assert.Equal(t, "sensor address", fields[0].Name)
+	assert.Equal(t, "0x01", fields[0].Value)
+	assert.Equal(t, "serial number", fields[1].Name)
+	assert.Equal(t, "11100492", fields[1].Value)
+	assert.Equal(t, []byte{0x00, 0xa9, 0x61, 0x4c}, fields[1].Bytes(payload))
+	assert.Equal(t, "actual pressure", fields[2].Name)
+	assert.Equal(t, "131.486496 mmH2O", fields[2].Value)
+
+	assert.Empty(t, decoder.Annotate(dcl571decoder{}, 1, payload[:10]))
+}
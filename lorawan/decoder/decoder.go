@@ -1,7 +1,11 @@
 package decoder
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/connctd/restapi-go"
@@ -14,6 +18,32 @@ type DecoderStateStore interface {
 	SetState(thingId, key string, value []byte) error
 }
 
+// ContextStateStore is implemented by storage backends, which need the context of the request
+// the state is read or written for
+type ContextStateStore interface {
+	GetState(ctx context.Context, thingID, key string) ([]byte, error)
+	SetState(ctx context.Context, thingID, key string, value []byte) error
+}
+
+// BindContext returns a DecoderStateStore which passes ctx to the store, so that decoders can use
+// the state without knowing about the request
+func BindContext(ctx context.Context, store ContextStateStore) DecoderStateStore {
+	return boundStateStore{ctx: ctx, store: store}
+}
+
+type boundStateStore struct {
+	ctx   context.Context
+	store ContextStateStore
+}
+
+func (b boundStateStore) GetState(thingID, key string) ([]byte, error) {
+	return b.store.GetState(b.ctx, thingID, key)
+}
+
+func (b boundStateStore) SetState(thingID, key string, value []byte) error {
+	return b.store.SetState(b.ctx, thingID, key, value)
+}
+
 func RegisterDecoder(name string, decoder PayloadDecoder) {
 	if _, exists := decoders[name]; exists {
 		panic(fmt.Errorf("PayloadDecoder with name %s alrady exists", name))
@@ -25,6 +55,16 @@ func GetDecoder(name string) PayloadDecoder {
 	return decoders[name]
 }
 
+// Names returns the sorted names of all registered decoders
+func Names() []string {
+	names := make([]string, 0, len(decoders))
+	for name := range decoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 type PropertyUpdate struct {
 	ThingID     string
 	ComponentID string
@@ -37,3 +77,71 @@ type PayloadDecoder interface {
 	Device(attributes []restapi.ThingAttribute) (*restapi.Thing, error)
 	DecodeMessage(store DecoderStateStore, fport uint32, msg []byte, thingID string) ([]PropertyUpdate, error)
 }
+
+// VersionedDecoder can be implemented by payload decoders to version the thing model returned by Device.
+// The version needs to be increased whenever the thing model changes, so that existing things can be
+// migrated to the new model.
+type VersionedDecoder interface {
+	ModelVersion() uint
+}
+
+// ModelVersion returns the version of the thing model of the decoder. Decoders which do not implement
+// VersionedDecoder are at version 1.
+func ModelVersion(d PayloadDecoder) uint {
+	if v, ok := d.(VersionedDecoder); ok {
+		return v.ModelVersion()
+	}
+	return 1
+}
+
+// ReportingDecoder can be implemented by payload decoders which know the default interval in which
+// their devices send uplinks. It is used to detect offline devices until their actual interval is learned.
+type ReportingDecoder interface {
+	ReportingInterval() time.Duration
+}
+
+// ReportingInterval returns the default reporting interval of devices of the decoder or zero if unknown
+func ReportingInterval(d PayloadDecoder) time.Duration {
+	if r, ok := d.(ReportingDecoder); ok {
+		return r.ReportingInterval()
+	}
+	return 0
+}
+
+// ErrUnknownAction is returned if a decoder does not handle the requested action
+var ErrUnknownAction = errors.New("unknown action")
+
+// ParameterError is returned by decoders if an action has been requested with an invalid parameter.
+// The message is reported back to the user requesting the action.
+type ParameterError struct {
+	Parameter string
+	Reason    string
+}
+
+func (p ParameterError) Error() string {
+	return fmt.Sprintf("invalid parameter '%s': %s", p.Parameter, p.Reason)
+}
+
+// ActionDecoder can be implemented by payload decoders whose thing model declares actions, e.g. to
+// configure calibration values which are kept in the decoder state of the thing.
+type ActionDecoder interface {
+	PerformAction(store DecoderStateStore, thingID, actionID string, parameters map[string]string) error
+}
+
+// PerformAction lets the decoder handle an action requested for one of its things. It returns
+// ErrUnknownAction if the decoder does not handle the action.
+func PerformAction(d PayloadDecoder, store DecoderStateStore, thingID, actionID string, parameters map[string]string) error {
+	if a, ok := d.(ActionDecoder); ok {
+		return a.PerformAction(store, thingID, actionID, parameters)
+	}
+	return ErrUnknownAction
+}
+
+// FloatParameter parses a float action parameter
+func FloatParameter(parameters map[string]string, name, description string) (float64, error) {
+	value, err := strconv.ParseFloat(parameters[name], 64)
+	if err != nil {
+		return 0, ParameterError{Parameter: name, Reason: fmt.Sprintf("needs to be %s as float number", description)}
+	}
+	return value, nil
+}
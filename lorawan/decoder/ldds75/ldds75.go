@@ -8,11 +8,12 @@ import (
 
 	"github.com/connctd/lora-connector/lorawan/decoder"
 	"github.com/connctd/restapi-go"
-	"gorm.io/gorm"
 )
 
+const decoderName = "ldds75"
+
 func init() {
-	decoder.RegisterDecoder("ldds75", ldds75decoder{})
+	decoder.RegisterDecoder(decoderName, ldds75decoder{})
 }
 
 var TestPayload = `{"applicationID":"2","applicationName":"newapp","deviceName":"ldds75","devEUI":"qEBBTWGC4Ig=","rxInfo":[{"gatewayID":"dP5I//5MnTc=","time":"2021-08-15T12:06:41.391514Z","timeSinceGPSEpoch":null,"rssi":-56,"loRaSNR":9.5,"channel":3,"rfChain":0,"board":0,"antenna":0,"location":{"latitude":0,"longitude":0,"altitude":0,"source":"UNKNOWN","accuracy":0},"fineTimestampType":"NONE","context":"XywUZA==","uplinkID":"ZRepk5N3QNWwfHYQQeBenQ==","crcStatus":"CRC_OK"}],"txInfo":{"frequency":867100000,"modulation":"LORA","loRaModulationInfo":{"bandwidth":125,"spreadingFactor":12,"codeRate":"4/5","polarizationInversion":false}},"adr":true,"dr":0,"fCnt":33,"fPort":2,"data":"DQgA+QA=","objectJSON":"{\"Bat\":3.336,\"Distance\":249,\"Interrupt_status\":0}","tags":{},"confirmedUplink":false,"devAddr":"AFOWqg==","publishedAt":"2021-08-15T12:06:41.521032244Z"}`
@@ -99,6 +100,69 @@ func (d ldds75decoder) Device(attributes []restapi.ThingAttribute) (*restapi.Thi
 	}, nil
 }
 
+// ModelVersion implements decoder.VersionedDecoder.
+// Version 2 added the battery component.
+func (d ldds75decoder) ModelVersion() uint {
+	return 2
+}
+
+// ReportingInterval implements decoder.ReportingDecoder.
+// The LDDS75 sends an uplink every 20 minutes by default.
+func (d ldds75decoder) ReportingInterval() time.Duration {
+	return 20 * time.Minute
+}
+
+// MigrateLegacyState implements decoder.LegacyStateDecoder.
+// The mounting height used to be stored in tenths of centimeters as varint.
+func (d ldds75decoder) MigrateLegacyState(legacyKey string, value []byte) (string, []byte, bool, error) {
+	if legacyKey != "mountingHeight" {
+		return "", nil, false, nil
+	}
+	newValue, err := decoder.ParseLegacyTenths(value)
+	return legacyKey, newValue, true, err
+}
+
+// PerformAction implements decoder.ActionDecoder.
+func (d ldds75decoder) PerformAction(store decoder.DecoderStateStore, thingID, actionID string, parameters map[string]string) error {
+	if actionID != "setMountingHeight" {
+		return decoder.ErrUnknownAction
+	}
+	value, err := decoder.FloatParameter(parameters, "mountingHeight", "mounting height in centimeters")
+	if err != nil {
+		return err
+	}
+	return decoder.NewState(store, decoderName, thingID).SetFloat("mountingHeight", value)
+}
+
+// Annotate implements decoder.AnnotatingDecoder.
+func (d ldds75decoder) Annotate(fport uint32, msg []byte) []decoder.Field {
+	if len(msg) < 2 {
+		return nil
+	}
+	fields := []decoder.Field{{
+		Name:    "battery",
+		Offsets: []int{0, 1},
+		Value:   fmt.Sprintf("%d mV", binary.BigEndian.Uint16(msg[:2])&0x3FFF),
+	}}
+	if len(msg) >= 5 {
+		distance := binary.BigEndian.Uint16(msg[2:4])
+		value := fmt.Sprintf("%d mm", distance)
+		if distance <= 20 {
+			value += " (invalid)"
+		}
+		fields = append(fields, decoder.Field{
+			Name:    "distance",
+			Offsets: []int{2, 3},
+			Value:   value,
+		}, decoder.Field{
+			Name:    "interrupt",
+			Offsets: []int{4},
+			Value:   fmt.Sprintf("%d", msg[4]),
+		})
+	}
+	return fields
+}
+
 func (d ldds75decoder) DecodeMessage(store decoder.DecoderStateStore, fport uint32, msg []byte, thingID string) ([]decoder.PropertyUpdate, error) {
 	// Ignore fport, device seems to only transmit on port 2
 	if len(msg) < 2 {
@@ -125,23 +189,10 @@ func (d ldds75decoder) DecodeMessage(store decoder.DecoderStateStore, fport uint
 		distanceRaw := binary.BigEndian.Uint16(msg[2:4]) // distance in mm
 		if distanceRaw > 20 {                            // Values smaller than 20 indicate invalid readings
 
-			val, err := store.GetState(thingID, "mountingHeight")
+			mountingHeight, err := decoder.NewState(store, decoderName, thingID).Float("mountingHeight", 0)
 			if err != nil {
-				if err == gorm.ErrRecordNotFound {
-					val = make([]byte, 4)
-					n := binary.PutVarint(val, 0)
-					err = store.SetState(thingID, "mountingHeight", val[:n])
-					if err != nil {
-						return nil, err
-					}
-					val = val[:n]
-				} else {
-					return nil, err
-				}
-
+				return nil, err
 			}
-			mountingHeightInt, _ := binary.Varint(val)
-			mountingHeight := float64(mountingHeightInt) / 10.0
 			updates = append(updates, decoder.PropertyUpdate{
 				ThingID:     thingID,
 				ComponentID: "waterlevel",
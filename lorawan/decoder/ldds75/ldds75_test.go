@@ -0,0 +1,23 @@
+package ldds75
+
+import (
+	"testing"
+
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotate(t *testing.T) {
+	payload := []byte{0x0d, 0x08, 0x00, 0xf9, 0x00}
+
+	fields := decoder.Annotate(ldds75decoder{}, 2, payload)
+	require.Len(t, fields, 3)
+	assert.Equal(t, decoder.Field{Name: "battery", Offsets: []int{0, 1}, Value: "3336 mV"}, fields[0])
+	assert.Equal(t, decoder.Field{Name: "distance", Offsets: []int{2, 3}, Value: "249 mm"}, fields[1])
+	assert.Equal(t, decoder.Field{Name: "interrupt", Offsets: []int{4}, Value: "0"}, fields[2])
+
+	fields = decoder.Annotate(ldds75decoder{}, 2, []byte{0x0d, 0x08, 0x00, 0x14, 0x00})
+	assert.Equal(t, "20 mm (invalid)", fields[1].Value)
+	assert.Len(t, decoder.Annotate(ldds75decoder{}, 2, payload[:2]), 1)
+}
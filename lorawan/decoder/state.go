@@ -0,0 +1,139 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// ErrStateNotFound is returned by a DecoderStateStore if no value is stored for a key
+var ErrStateNotFound = errors.New("decoder state not found")
+
+// State provides typed access to the state a decoder stores for a single thing. Keys are prefixed
+// with the name of the decoder, so decoders can't overwrite the state of other decoders.
+// Values are stored in their textual representation.
+type State struct {
+	store     DecoderStateStore
+	namespace string
+	thingID   string
+}
+
+// NewState returns the state of the thing in the namespace of the named decoder
+func NewState(store DecoderStateStore, decoderName, thingID string) *State {
+	return &State{
+		store:     store,
+		namespace: decoderName,
+		thingID:   thingID,
+	}
+}
+
+// StateKey returns the key under which a decoder stores a state value
+func StateKey(decoderName, key string) string {
+	return decoderName + "." + key
+}
+
+// Bytes returns the raw value stored for key or ErrStateNotFound
+func (s *State) Bytes(key string) ([]byte, error) {
+	return s.store.GetState(s.thingID, StateKey(s.namespace, key))
+}
+
+// SetBytes stores the raw value for key
+func (s *State) SetBytes(key string, value []byte) error {
+	return s.store.SetState(s.thingID, StateKey(s.namespace, key), value)
+}
+
+// String returns the value stored for key or def if nothing is stored
+func (s *State) String(key string, def string) (string, error) {
+	b, err := s.Bytes(key)
+	if errors.Is(err, ErrStateNotFound) {
+		return def, nil
+	}
+	return string(b), err
+}
+
+func (s *State) SetString(key string, value string) error {
+	return s.SetBytes(key, []byte(value))
+}
+
+// Float returns the value stored for key or def if nothing is stored
+func (s *State) Float(key string, def float64) (float64, error) {
+	str, err := s.String(key, "")
+	if err != nil || str == "" {
+		return def, err
+	}
+	return strconv.ParseFloat(str, 64)
+}
+
+func (s *State) SetFloat(key string, value float64) error {
+	return s.SetString(key, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// Int returns the value stored for key or def if nothing is stored
+func (s *State) Int(key string, def int64) (int64, error) {
+	str, err := s.String(key, "")
+	if err != nil || str == "" {
+		return def, err
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
+
+func (s *State) SetInt(key string, value int64) error {
+	return s.SetString(key, strconv.FormatInt(value, 10))
+}
+
+// JSON unmarshals the value stored for key into v. If nothing is stored, v is left untouched,
+// so it can be initialized with the default value by the caller.
+func (s *State) JSON(key string, v interface{}) error {
+	b, err := s.Bytes(key)
+	if errors.Is(err, ErrStateNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (s *State) SetJSON(key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.SetBytes(key, b)
+}
+
+// LegacyStateDecoder is implemented by decoders which stored state before keys were namespaced
+// and values were typed. Legacy state is stored without namespace, so legacy keys need to be
+// unique across all decoders.
+type LegacyStateDecoder interface {
+	// MigrateLegacyState converts a legacy value into a typed value stored under the given key
+	// in the namespace of the decoder. ok is false if the key is unknown to the decoder.
+	MigrateLegacyState(legacyKey string, value []byte) (key string, newValue []byte, ok bool, err error)
+}
+
+// MigrateLegacyState finds the decoder a legacy state key belongs to and converts the value.
+// The returned key includes the namespace of the decoder. ok is false if no decoder knows the key.
+func MigrateLegacyState(legacyKey string, value []byte) (key string, newValue []byte, ok bool, err error) {
+	for _, name := range Names() {
+		legacy, isLegacy := decoders[name].(LegacyStateDecoder)
+		if !isLegacy {
+			continue
+		}
+		key, newValue, ok, err = legacy.MigrateLegacyState(legacyKey, value)
+		if ok || err != nil {
+			return StateKey(name, key), newValue, ok, err
+		}
+	}
+	return "", nil, false, nil
+}
+
+// ParseLegacyTenths converts a legacy value, which stored tenths of a unit as varint, into the
+// textual representation of a float value.
+func ParseLegacyTenths(value []byte) ([]byte, error) {
+	tenths, n := binary.Varint(value)
+	if n <= 0 {
+		return nil, errors.New("invalid varint value")
+	}
+	return []byte(strconv.FormatFloat(float64(tenths)/10.0, 'g', -1, 64)), nil
+}
@@ -0,0 +1,76 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStateStore map[string][]byte
+
+func (m memoryStateStore) GetState(thingID, key string) ([]byte, error) {
+	v, ok := m[thingID+"/"+key]
+	if !ok {
+		return nil, ErrStateNotFound
+	}
+	return v, nil
+}
+
+func (m memoryStateStore) SetState(thingID, key string, value []byte) error {
+	m[thingID+"/"+key] = value
+	return nil
+}
+
+func TestState(t *testing.T) {
+	store := memoryStateStore{}
+	state := NewState(store, "foo", "thing")
+
+	f, err := state.Float("height", 12.5)
+	require.NoError(t, err)
+	assert.Equal(t, 12.5, f)
+	require.NoError(t, state.SetFloat("height", 42.1))
+	f, err = state.Float("height", 12.5)
+	require.NoError(t, err)
+	assert.Equal(t, 42.1, f)
+	assert.Equal(t, []byte("42.1"), store["thing/foo.height"])
+
+	i, err := state.Int("count", 3)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, i)
+	require.NoError(t, state.SetInt("count", -7))
+	i, err = state.Int("count", 3)
+	require.NoError(t, err)
+	assert.EqualValues(t, -7, i)
+
+	s, err := state.String("name", "default")
+	require.NoError(t, err)
+	assert.Equal(t, "default", s)
+
+	type calibration struct {
+		Offset float64
+		Factor float64
+	}
+	c := calibration{Factor: 1}
+	require.NoError(t, state.JSON("calibration", &c))
+	assert.Equal(t, calibration{Factor: 1}, c)
+	require.NoError(t, state.SetJSON("calibration", calibration{Offset: 2, Factor: 3}))
+	require.NoError(t, state.JSON("calibration", &c))
+	assert.Equal(t, calibration{Offset: 2, Factor: 3}, c)
+
+	// Other decoders don't see the state
+	_, err = NewState(store, "bar", "thing").Bytes("height")
+	assert.ErrorIs(t, err, ErrStateNotFound)
+}
+
+func TestParseLegacyTenths(t *testing.T) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(buf, -1234)
+	value, err := ParseLegacyTenths(buf[:n])
+	require.NoError(t, err)
+	assert.Equal(t, "-123.4", string(value))
+
+	_, err = ParseLegacyTenths([]byte{0xA1})
+	assert.Error(t, err)
+}
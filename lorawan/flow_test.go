@@ -0,0 +1,99 @@
+package lorawan_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/lorawan/decoder/ldds75"
+	"github.com/connctd/lora-connector/memstore"
+	"github.com/connctd/lora-connector/mocks"
+	"github.com/connctd/restapi-go"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// propertyRecorder records the property values pushed to connctd
+type propertyRecorder struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (p *propertyRecorder) record(args mock.Arguments) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.values[args.String(2)+"/"+args.String(3)+"/"+args.String(4)] = args.String(5)
+}
+
+func (p *propertyRecorder) get(thingID, componentID, propertyID string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.values[thingID+"/"+componentID+"/"+propertyID]
+}
+
+// TestProvisionConfigureDecode runs a device through provisioning, configuration of its mounting height
+// and decoding against the in-memory store
+func TestProvisionConfigureDecode(t *testing.T) {
+	ctx := context.Background()
+	client := &mocks.Client{}
+	properties := &propertyRecorder{values: map[string]string{}}
+	client.On("UpdateInstallationState", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	client.On("UpdateInstanceState", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	client.On("UpdateThingPropertyValue", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(properties.record).Return(nil)
+	client.On("CreateThing", mock.Anything, connector.InstantiationToken("instancetoken"), mock.MatchedBy(func(thing restapi.Thing) bool {
+		return thing.Name == "configuration Thing"
+	})).Return(restapi.Thing{ID: "configthing"}, nil).Once()
+	client.On("CreateThing", mock.Anything, connector.InstantiationToken("instancetoken"), mock.MatchedBy(func(thing restapi.Thing) bool {
+		return thing.Name == "LDDS75"
+	})).Return(restapi.Thing{ID: "ldds75thing"}, nil).Once()
+
+	store := memstore.New(client, "lora.example.com")
+	require.NoError(t, store.AddInstallation(ctx, connector.InstallationRequest{
+		ID:    "foo",
+		Token: "installationtoken",
+		Configuration: []connector.Configuration{
+			{ID: lorawan.ConfigDefaultDecoder, Value: "ldds75"},
+		},
+	}))
+	require.NoError(t, store.AddInstance(ctx, connector.InstantiationRequest{
+		ID:             "bar",
+		InstallationID: "foo",
+		Token:          "instancetoken",
+	}))
+	assert.Equal(t, "https://lora.example.com/lorawan/foo/bar", properties.get("configthing", "lora", "url"))
+
+	r := mux.NewRouter()
+	r.Path("/lorawan/{installationId}/{instanceId}").Methods(http.MethodPost).Handler(lorawan.NewLoRaWANHandler(client, true, store))
+	uplink := func() {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/lorawan/foo/bar?event=up", bytes.NewBufferString(ldds75.TestPayload))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	}
+
+	uplink()
+	assert.Equal(t, "-24.900000", properties.get("ldds75thing", "waterlevel", "waterlevel"))
+
+	resp, err := store.PerformAction(ctx, connector.ActionRequest{
+		ID:       "action",
+		ThingID:  "ldds75thing",
+		ActionID: "setMountingHeight",
+		Parameters: map[string]string{
+			"mountingHeight": "300",
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, restapi.ActionRequestStatusCompleted, resp.Status)
+
+	uplink()
+	assert.Equal(t, "275.100000", properties.get("ldds75thing", "waterlevel", "waterlevel"))
+	client.AssertExpectations(t)
+}
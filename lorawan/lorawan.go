@@ -2,39 +2,71 @@ package lorawan
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/brocaar/chirpstack-api/go/v3/as/integration"
 	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/connclient"
 	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/connctd/lora-connector/metrics"
+	"github.com/connctd/lora-connector/tracing"
 	"github.com/connctd/restapi-go"
 )
 
 type dataStore interface {
-	decoder.DecoderStateStore
-	MapDevEUIToThingID(instanceID string, devEUI []byte) (string, error)
-	StoreDEVUIToThingID(instanceID string, devEUI []byte, thingID string) error
-	GetInstallationToken(installationId string) (connector.InstallationToken, error)
-	GetInstance(instanceId string) (connector.InstantiationRequest, error)
-	DecoderNameForApp(instanceID string, appId uint64) (string, error)
+	decoder.ContextStateStore
+	GetDeviceMapping(ctx context.Context, instanceID string, devEUI []byte) (*DeviceMapping, error)
+	StoreDeviceMapping(ctx context.Context, mapping DeviceMapping) error
+	SetDeviceDecoder(ctx context.Context, instanceID string, devEUI []byte, decoderName string, modelVersion uint) error
+	OutdatedDeviceMappings(ctx context.Context, instanceID, decoderName string, modelVersion uint) ([]DeviceMapping, error)
+	LockDevice(ctx context.Context, instanceID string, devEUI []byte) (unlock func(), err error)
+	GetInstallationToken(ctx context.Context, installationId string) (connector.InstallationToken, error)
+	GetInstance(ctx context.Context, instanceId string) (connector.InstantiationRequest, error)
+	DecoderNameForApp(ctx context.Context, instanceID string, appId uint64) (string, error)
+	ProvisioningPolicy(ctx context.Context, instanceID string) (ProvisioningPolicy, error)
+	GetInstanceConfig(ctx context.Context, instanceID string) (InstanceConfig, error)
+	ReplaceThingID(ctx context.Context, staleThingID string, mapping DeviceMapping) error
+	IgnoreDevice(ctx context.Context, instanceID string, devEUI []byte) error
+	SetLastEvent(ctx context.Context, instanceID string, t time.Time) error
+	// RecordUplink keeps devices marked as offline until SetDeviceOnline, so that the thing is marked as
+	// available again with the next uplink if marking it failed
+	RecordUplink(ctx context.Context, instanceID string, devEUI []byte, t time.Time) (offline bool, err error)
+	SetDeviceOnline(ctx context.Context, instanceID string, devEUI []byte) error
+	ArchiveEvent(ctx context.Context, event ArchivedEvent) error
+	ListArchivedEvents(ctx context.Context, filter ArchiveFilter) ([]ArchivedEvent, error)
+	SetArchivedUpdates(ctx context.Context, eventID uint64, updates []decoder.PropertyUpdate) error
 }
+
+// lastEventInterval limits how often the time of the last event of an instance is stored
+const lastEventInterval = time.Minute
+
 type LoRaWANHandler struct {
 	json            bool
 	connectorClient connector.Client
 	logger          logrus.FieldLogger
 	store           dataStore
+	// lastEvents holds the time the last event of an instance has been stored
+	lastEvents sync.Map
+	// archiveQueue holds the events waiting to be archived by RunArchiver
+	archiveQueue chan archiveRequest
 }
 
 func NewLoRaWANHandler(connectorClient connector.Client, useJson bool, dataStore dataStore) *LoRaWANHandler {
@@ -43,6 +75,7 @@ func NewLoRaWANHandler(connectorClient connector.Client, useJson bool, dataStore
 		store:           dataStore,
 		json:            useJson,
 		logger:          logrus.WithField("component", "LoRaWANHandler"),
+		archiveQueue:    make(chan archiveRequest, archiveQueueSize),
 	}
 
 	return l
@@ -50,9 +83,12 @@ func NewLoRaWANHandler(connectorClient connector.Client, useJson bool, dataStore
 
 // Handler under form of .../{installationId}/{instanceId}
 func (l *LoRaWANHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w, r, span := tracing.StartRequest(w, r, "lorawan.event")
+	defer span.End()
 	vars := mux.Vars(r)
 	instanceID := vars["instanceId"]
 	installationID := vars["installationId"]
+	span.SetAttributes(attribute.String("connector.instance", instanceID))
 
 	if installationID == "" || instanceID == "" {
 		l.logger.WithField("url", r.RequestURI).Error("Invalid request URL. Either instanceId or installationId are missing")
@@ -63,7 +99,7 @@ func (l *LoRaWANHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"instanceId":     instanceID,
 		"installationId": installationID,
 	})
-	instance, err := l.store.GetInstance(instanceID)
+	instance, err := l.store.GetInstance(r.Context(), instanceID)
 	if err != nil {
 		logger.WithError(err).Error("Failed to retrieve installation token")
 		http.Error(w, "internal failure", http.StatusInternalServerError)
@@ -76,12 +112,29 @@ func (l *LoRaWANHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
+	l.recordEvent(r.Context(), instanceID)
+	metrics.UplinksInFlight.Inc()
+	defer metrics.UplinksInFlight.Dec()
 	l.HandleRequest(instance.Token, instanceID, w, r)
 }
 
+// recordEvent stores that the instance received an event, at most once per lastEventInterval
+func (l *LoRaWANHandler) recordEvent(ctx context.Context, instanceID string) {
+	now := time.Now()
+	if last, ok := l.lastEvents.Load(instanceID); ok && now.Sub(last.(time.Time)) < lastEventInterval {
+		return
+	}
+	l.lastEvents.Store(instanceID, now)
+	if err := l.store.SetLastEvent(ctx, instanceID, now); err != nil {
+		l.logger.WithError(err).WithField("instanceId", instanceID).Warn("Failed to store time of last event")
+	}
+}
+
 func (l *LoRaWANHandler) HandleRequest(token connector.InstantiationToken, instanceID string, w http.ResponseWriter, r *http.Request) {
 	event := r.URL.Query().Get("event")
 	logger := l.logger.WithField("event", event)
+	span := trace.SpanFromContext(r.Context())
+	span.SetAttributes(attribute.String("lorawan.event", metrics.EventType(event)))
 
 	b, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024*1024*1)) // Read up to 1 Megabyte, the calls shouldn't be bigger than this
 	if err != nil {
@@ -90,6 +143,23 @@ func (l *LoRaWANHandler) HandleRequest(token connector.InstantiationToken, insta
 		return
 	}
 
+	archived := ArchivedEvent{
+		InstanceID: instanceID,
+		Event:      event,
+		Encoding:   l.encoding(),
+		Body:       b,
+		ReceivedAt: time.Now(),
+		Outcome:    EventOutcomeProcessed,
+	}
+	defer func() {
+		metrics.EventsReceived.WithLabelValues(instanceID, metrics.EventType(event), string(archived.Outcome)).Inc()
+		span.SetAttributes(attribute.String("lorawan.outcome", string(archived.Outcome)))
+		if archived.Outcome == EventOutcomeFailed {
+			span.SetStatus(codes.Error, archived.Error)
+		}
+		l.archive(r.Context(), archived)
+	}()
+
 	switch event {
 	case "up":
 		//err = h.up(b)
@@ -97,18 +167,28 @@ func (l *LoRaWANHandler) HandleRequest(token connector.InstantiationToken, insta
 		err = l.unmarshal(b, &up)
 		if err != nil {
 			logger.WithError(err).Error("Failed to unmarshal http callback payload")
+			archived.setOutcome(EventOutcomeRejected, err)
 			http.Error(w, "unparseable payload", http.StatusBadRequest)
 			return
 		}
+		archived.setDevice(up.DevEui, up.FCnt)
+		metrics.DeviceUplink(instanceID, hex.EncodeToString(up.DevEui))
+		span.SetAttributes(
+			attribute.String("lorawan.dev_eui", hex.EncodeToString(up.DevEui)),
+			attribute.Int64("lorawan.f_cnt", int64(up.FCnt)),
+			attribute.Int64("lorawan.application", int64(up.ApplicationId)),
+		)
 		// applicationId := formatAppID(up.ApplicationId)
-		decoderName, err := l.store.DecoderNameForApp(instanceID, up.ApplicationId)
+		decoderName, err := l.store.DecoderNameForApp(r.Context(), instanceID, up.ApplicationId)
 		if err != nil {
 			logger.WithError(err).Error("Failed to retrieve decoder name for LoRaWAN application")
+			archived.setOutcome(EventOutcomeFailed, err)
 			return
 		}
 		payloadDecoder := decoder.GetDecoder(decoderName)
 		if payloadDecoder == nil {
 			logger.WithField("decoderName", decoderName).Error("For this name no payload decoder implementation is registered")
+			archived.setOutcome(EventOutcomeFailed, fmt.Errorf("unknown payload decoder %s", decoderName))
 			return
 		}
 		deviceID := up.DevEui
@@ -124,66 +204,96 @@ func (l *LoRaWANHandler) HandleRequest(token connector.InstantiationToken, insta
 			"fport":    fport,
 		})
 
-		thingID, err := l.store.MapDevEUIToThingID(instanceID, deviceID)
+		dev := device{
+			instanceID:   instanceID,
+			devEUI:       deviceID,
+			formattedEUI: formattedEUI,
+			decoderName:  decoderName,
+			decoder:      payloadDecoder,
+		}
+
+		mapping, err := l.store.GetDeviceMapping(r.Context(), instanceID, deviceID)
+		if errors.Is(err, ErrDeviceIgnored) {
+			logger.Debug("Thing of device has been deleted in connctd, ignoring uplink")
+			archived.setOutcome(EventOutcomeIgnored, nil)
+			return
+		}
 		if err != nil {
 			logger.WithError(err).Error("Failed to retrieve thingID for device EUI")
+			archived.setOutcome(EventOutcomeFailed, err)
 			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
 		}
 
-		if thingID == "" {
-			attributes := []restapi.ThingAttribute{
-				{
-					Name:  "lora.deveui",
-					Value: formattedEUI,
-				},
+		var thingID string
+		if mapping != nil {
+			thingID = mapping.ThingID
+			if mapping.DecoderName == "" {
+				// Mappings created before thing models were versioned don't know their decoder
+				if err := l.store.SetDeviceDecoder(r.Context(), instanceID, deviceID, decoderName, UnversionedModelVersion(decoderName)); err != nil {
+					logger.WithError(err).Warn("Failed to store decoder of device mapping")
+				}
 			}
-			thing, err := payloadDecoder.Device(attributes)
-			if err != nil {
-				logger.WithError(err).Error("Failed to create thing for LoRaWAN device")
-				http.Error(w, "unable to create thing", http.StatusInternalServerError)
+		} else {
+			thingID, err = l.provisionThing(r.Context(), token, dev)
+			if errors.Is(err, ErrDeviceIgnored) {
+				logger.Debug("Thing of device has been deleted in connctd, ignoring uplink")
+				archived.setOutcome(EventOutcomeIgnored, nil)
 				return
 			}
-			result, err := l.connectorClient.CreateThing(r.Context(), token, *thing)
 			if err != nil {
-				logger.WithError(err).Error("Failed to create thing")
-				http.Error(w, "upstream error", http.StatusInternalServerError)
-				return
-			}
-			if err := l.store.StoreDEVUIToThingID(instanceID, deviceID, result.ID); err != nil {
-				logger.WithError(err).Error("Failed to store deviceEUI to thing ID mapping")
-				http.Error(w, "internal error", http.StatusInternalServerError)
+				logger.WithError(err).Error("Failed to provision thing for LoRaWAN device")
+				archived.setOutcome(EventOutcomeFailed, err)
+				http.Error(w, "unable to create thing", http.StatusInternalServerError)
 				return
 			}
-			thingID = result.ID
 		}
 
 		logger = logger.WithField("thingID", thingID)
 
-		updates, err := payloadDecoder.DecodeMessage(l.store, fport, msg, thingID)
+		offline, err := l.store.RecordUplink(r.Context(), instanceID, deviceID, time.Now())
+		if err != nil {
+			logger.WithError(err).Warn("Failed to record uplink of device")
+		} else if offline {
+			if err := l.connectorClient.UpdateThingStatus(r.Context(), token, thingID, restapi.StatusTypeAvailable); err != nil {
+				logger.WithError(err).Error("Failed to mark thing as available again, retrying with the next uplink")
+			} else if err := l.store.SetDeviceOnline(r.Context(), instanceID, deviceID); err != nil {
+				logger.WithError(err).Warn("Failed to clear offline state of device")
+			} else {
+				logger.Info("Device is reporting again, marked thing as available")
+			}
+		}
+
+		updates, err := l.decode(r.Context(), dev, fport, msg, thingID)
 		if err != nil {
 			logger.WithField("deviceEUI", deviceID).WithError(err).Error("Failed to decode message of LoRaWAN device")
+			metrics.DecodeFailures.WithLabelValues(decoderName).Inc()
+			archived.setOutcome(EventOutcomeFailed, err)
 			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
 		}
+		archived.Updates = updates
 
-		for _, update := range updates {
-			updateTime := update.UpdateTime
-			if updateTime.IsZero() {
-				updateTime = time.Now()
+		if err := l.updateProperties(r.Context(), token, updates, logger); connclient.IsThingNotFound(err) {
+			newThingID, err := l.reprovisionThing(r.Context(), token, dev, thingID)
+			if err != nil {
+				logger.WithError(err).Error("Failed to reprovision deleted thing")
+				archived.setOutcome(EventOutcomeFailed, err)
+				http.Error(w, "unable to create thing", http.StatusInternalServerError)
+				return
+			}
+			if newThingID == "" {
+				archived.setOutcome(EventOutcomeIgnored, nil)
+				return
+			}
+			for i := range updates {
+				if updates[i].ThingID == thingID {
+					updates[i].ThingID = newThingID
+				}
 			}
-			if err := l.connectorClient.UpdateThingPropertyValue(
-				r.Context(),
-				token,
-				update.ThingID,
-				update.ComponentID,
-				update.PropertyID,
-				update.Value,
-				updateTime); err != nil {
-				logger.WithFields(logrus.Fields{
-					"componentId": update.ComponentID,
-					"propertyId":  update.PropertyID,
-				}).WithError(err).Error("Failed to update thing property")
+			if err := l.updateProperties(r.Context(), token, updates, logger.WithField("thingID", newThingID)); err != nil {
+				logger.WithError(err).Error("Failed to update properties of recreated thing")
+				archived.setOutcome(EventOutcomeFailed, err)
 			}
 		}
 
@@ -191,9 +301,11 @@ func (l *LoRaWANHandler) HandleRequest(token connector.InstantiationToken, insta
 		var errorEvent integration.ErrorEvent
 		if err := l.unmarshal(b, &errorEvent); err != nil {
 			logger.WithError(err).Error("Failed to unmarshal error event")
+			archived.setOutcome(EventOutcomeRejected, err)
 			http.Error(w, "unparseable payload", http.StatusBadRequest)
 			return
 		}
+		archived.setDevice(errorEvent.DevEui, errorEvent.FCnt)
 		formattedEUI, err := formatEUI(errorEvent.DevEui)
 		if err != nil {
 			logger.WithError(err).Error("Failed to format device EUI")
@@ -207,6 +319,7 @@ func (l *LoRaWANHandler) HandleRequest(token connector.InstantiationToken, insta
 		}).Error(errorEvent.Error)
 	default:
 		logger.Warn("Handler for event type not implemented")
+		archived.setOutcome(EventOutcomeRejected, errors.New("unknown event type"))
 		http.Error(w, "unknown event type", http.StatusBadRequest)
 		return
 	}
@@ -216,9 +329,64 @@ func (l *LoRaWANHandler) HandleRequest(token connector.InstantiationToken, insta
 	}
 }
 
+// decode decodes the payload of an uplink with the decoder of the device
+func (l *LoRaWANHandler) decode(ctx context.Context, dev device, fport uint32, msg []byte, thingID string) (updates []decoder.PropertyUpdate, err error) {
+	ctx, span := tracing.Start(ctx, "decoder.decode",
+		attribute.String("decoder.name", dev.decoderName),
+		attribute.Int64("lorawan.f_port", int64(fport)),
+		attribute.Int("decoder.payload_size", len(msg)),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("decoder.updates", len(updates)))
+		tracing.End(span, err)
+	}()
+	return dev.decoder.DecodeMessage(decoder.BindContext(ctx, l.store), fport, msg, thingID)
+}
+
+// updateProperties pushes all property updates to connctd. Failed updates are logged and skipped,
+// unless the thing does not exist anymore, in which case ErrThingNotFound is returned immediately.
+func (l *LoRaWANHandler) updateProperties(ctx context.Context, token connector.InstantiationToken, updates []decoder.PropertyUpdate, logger logrus.FieldLogger) error {
+	for _, update := range updates {
+		updateTime := update.UpdateTime
+		if updateTime.IsZero() {
+			updateTime = time.Now()
+		}
+		if err := l.connectorClient.UpdateThingPropertyValue(
+			ctx,
+			token,
+			update.ThingID,
+			update.ComponentID,
+			update.PropertyID,
+			update.Value,
+			updateTime); err != nil {
+			if connclient.IsThingNotFound(err) {
+				logger.WithField("thingID", update.ThingID).Warn("Thing does not exist in connctd anymore")
+				return err
+			}
+			logger.WithFields(logrus.Fields{
+				"componentId": update.ComponentID,
+				"propertyId":  update.PropertyID,
+			}).WithError(err).Error("Failed to update thing property")
+		}
+	}
+	return nil
+}
+
+func (l *LoRaWANHandler) encoding() string {
+	if l.json {
+		return EncodingJSON
+	}
+	return EncodingProtobuf
+}
+
 func (l *LoRaWANHandler) unmarshal(b []byte, v proto.Message) error {
 	// TODO figure out automaticall (via headers or something) if we should decode json or protobuf
-	if l.json {
+	return unmarshal(l.encoding(), b, v)
+}
+
+// unmarshal decodes an event sent with the given encoding
+func unmarshal(encoding string, b []byte, v proto.Message) error {
+	if encoding == EncodingJSON {
 		unmarshaler := &jsonpb.Unmarshaler{
 			AllowUnknownFields: true,
 		}
@@ -227,6 +395,15 @@ func (l *LoRaWANHandler) unmarshal(b []byte, v proto.Message) error {
 	return proto.Unmarshal(b, v)
 }
 
+// ParseUplink parses a ChirpStack uplink event in JSON encoding
+func ParseUplink(b []byte) (*integration.UplinkEvent, error) {
+	var up integration.UplinkEvent
+	if err := unmarshal(EncodingJSON, b, &up); err != nil {
+		return nil, err
+	}
+	return &up, nil
+}
+
 func formatEUI(eui []byte) (string, error) {
 	if len(eui) != 8 {
 		return "", errors.New("invalid EUI. Invalid length")
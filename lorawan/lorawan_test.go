@@ -2,14 +2,20 @@ package lorawan
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/connclient"
+	"github.com/connctd/lora-connector/lorawan/decoder"
 	_ "github.com/connctd/lora-connector/lorawan/decoder/dcl571"
 	"github.com/connctd/lora-connector/lorawan/decoder/ldds75"
 	"github.com/connctd/lora-connector/mocks"
+	"github.com/connctd/restapi-go"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -17,21 +23,44 @@ import (
 
 var dcl571Body = `{"applicationID":"1","applicationName":"AdvantechNode","deviceName":"4610PNode","devEUI":"dP5I//9Edu8=","rxInfo":[],"txInfo":{"frequency":868500000,"modulation":"LORA","loRaModulationInfo":{"bandwidth":125,"spreadingFactor":9,"codeRate":"4/5","polarizationInversion":false}},"adr":false,"dr":3,"fCnt":1316,"fPort":1,"data":"gc1PAAcHAgAAAAAAAAcnAgAAAAAANAofIACAAAAVgNx/NAo/IACAAAAGgPl/EAIBAIMEAACpAIMEAQBMYYMEAgADQ4MEAwDpGGAHFgJigKV2YTU=","objectJSON":"","tags":{},"confirmedUplink":false,"devAddr":"/0R27w==","publishedAt":"2021-09-15T12:43:37.823217391Z"}`
 
+// runArchiver runs the archiver of the handler, the returned function stops it once all queued events
+// have been archived
+func runArchiver(l *LoRaWANHandler) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		l.RunArchiver(ctx)
+		close(stopped)
+	}()
+	return func() {
+		cancel()
+		<-stopped
+	}
+}
+
 func TestLDDS75Handling(t *testing.T) {
 	connectorClient := new(mocks.Client)
 	store := new(mockDataStore)
 
 	loraHandler := NewLoRaWANHandler(connectorClient, true, store)
+	stopArchiver := runArchiver(loraHandler)
 
-	store.On("GetInstance", "bar").Return(connector.InstantiationRequest{
+	store.On("GetInstance", mock.Anything, "bar").Return(connector.InstantiationRequest{
 		InstallationID: "foo",
 		Token:          "abc",
 		ID:             "bar",
 	}, nil)
+	store.On("SetLastEvent", mock.Anything, "bar", mock.AnythingOfType("time.Time")).Return(nil)
+	store.On("GetInstanceConfig", mock.Anything, "bar").Return(DefaultInstanceConfig(), nil)
+	store.On("ArchiveEvent", mock.Anything, mock.MatchedBy(func(e ArchivedEvent) bool {
+		return e.InstanceID == "bar" && e.Event == "up" && e.Encoding == EncodingJSON && e.FCnt == 33 &&
+			e.Outcome == EventOutcomeProcessed && e.Error == "" && bytes.Equal(e.Body, []byte(ldds75.TestPayload))
+	})).Return(nil)
+	store.On("RecordUplink", mock.Anything, "bar", mock.Anything, mock.AnythingOfType("time.Time")).Return(false, nil)
 
-	store.On("DecoderNameForApp", "bar", uint64(2)).Return("ldds75", nil)
-	store.On("MapDevEUIToThingID", "bar", []byte{0xa8, 0x40, 0x41, 0x4d, 0x61, 0x82, 0xe0, 0x88}).Return("foothing", nil)
-	store.On("GetState", "foothing", "mountingHeight").Return([]byte{0xA1}, nil)
+	store.On("DecoderNameForApp", mock.Anything, "bar", uint64(2)).Return("ldds75", nil)
+	store.On("GetDeviceMapping", mock.Anything, "bar", []byte{0xa8, 0x40, 0x41, 0x4d, 0x61, 0x82, 0xe0, 0x88}).Return(&DeviceMapping{ThingID: "foothing", DecoderName: "ldds75", ModelVersion: 2}, nil)
+	store.On("GetState", mock.Anything, "foothing", "ldds75.mountingHeight").Return([]byte("0"), nil)
 
 	connectorClient.On("UpdateThingPropertyValue",
 		mock.MatchedBy(func(in interface{}) bool { return true }),
@@ -62,6 +91,8 @@ func TestLDDS75Handling(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
 
+	stopArchiver()
+
 	connectorClient.AssertExpectations(t)
 	store.AssertExpectations(t)
 }
@@ -71,16 +102,21 @@ func TestDCL571Handling(t *testing.T) {
 	store := new(mockDataStore)
 
 	loraHandler := NewLoRaWANHandler(connectorClient, true, store)
+	stopArchiver := runArchiver(loraHandler)
 
-	store.On("GetInstance", "bar").Return(connector.InstantiationRequest{
+	store.On("GetInstance", mock.Anything, "bar").Return(connector.InstantiationRequest{
 		InstallationID: "foo",
 		Token:          "abc",
 		ID:             "bar",
 	}, nil)
+	store.On("SetLastEvent", mock.Anything, "bar", mock.AnythingOfType("time.Time")).Return(nil)
+	// Archive disabled
+	store.On("GetInstanceConfig", mock.Anything, "bar").Return(InstanceConfig{}, nil)
+	store.On("RecordUplink", mock.Anything, "bar", mock.Anything, mock.AnythingOfType("time.Time")).Return(false, nil)
 
-	store.On("DecoderNameForApp", "bar", uint64(1)).Return("dcl571", nil)
-	store.On("MapDevEUIToThingID", "bar", []byte{0x74, 0xfe, 0x48, 0xff, 0xff, 0x44, 0x76, 0xef}).Return("foothing", nil)
-	store.On("GetState", "foothing", "waterLevelOffset").Return([]byte{0xA1}, nil)
+	store.On("DecoderNameForApp", mock.Anything, "bar", uint64(1)).Return("dcl571", nil)
+	store.On("GetDeviceMapping", mock.Anything, "bar", []byte{0x74, 0xfe, 0x48, 0xff, 0xff, 0x44, 0x76, 0xef}).Return(&DeviceMapping{ThingID: "foothing", DecoderName: "dcl571", ModelVersion: 1}, nil)
+	store.On("GetState", mock.Anything, "foothing", "dcl571.waterLevelOffset").Return(nil, decoder.ErrStateNotFound)
 
 	connectorClient.On("UpdateThingPropertyValue",
 		mock.MatchedBy(func(in interface{}) bool { return true }),
@@ -111,7 +147,365 @@ func TestDCL571Handling(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
 
+	stopArchiver()
+
+	connectorClient.AssertExpectations(t)
+	store.AssertExpectations(t)
+
+}
+
+func TestRecreateDeletedThing(t *testing.T) {
+	connectorClient := new(mocks.Client)
+	store := new(mockDataStore)
+
+	loraHandler := NewLoRaWANHandler(connectorClient, true, store)
+	stopArchiver := runArchiver(loraHandler)
+	devEUI := []byte{0x74, 0xfe, 0x48, 0xff, 0xff, 0x44, 0x76, 0xef}
+
+	store.On("GetInstance", mock.Anything, "bar").Return(connector.InstantiationRequest{
+		InstallationID: "foo",
+		Token:          "abc",
+		ID:             "bar",
+	}, nil)
+	store.On("SetLastEvent", mock.Anything, "bar", mock.AnythingOfType("time.Time")).Return(nil)
+	// Archive disabled
+	store.On("GetInstanceConfig", mock.Anything, "bar").Return(InstanceConfig{}, nil)
+	store.On("RecordUplink", mock.Anything, "bar", mock.Anything, mock.AnythingOfType("time.Time")).Return(false, nil)
+
+	store.On("DecoderNameForApp", mock.Anything, "bar", uint64(1)).Return("dcl571", nil)
+	store.On("GetDeviceMapping", mock.Anything, "bar", devEUI).Return(&DeviceMapping{ThingID: "foothing", DecoderName: "dcl571", ModelVersion: 1}, nil)
+	store.On("GetState", mock.Anything, "foothing", "dcl571.waterLevelOffset").Return(nil, decoder.ErrStateNotFound)
+	store.On("ProvisioningPolicy", mock.Anything, "bar").Return(ProvisioningPolicyRecreate, nil)
+	store.On("LockDevice", mock.Anything, "bar", devEUI).Return(func() {}, nil)
+	store.On("ReplaceThingID", mock.Anything, "foothing", DeviceMapping{InstanceID: "bar", DevEUI: devEUI, ThingID: "newthing", DecoderName: "dcl571", ModelVersion: 1}).Return(nil)
+
+	connectorClient.On("UpdateThingPropertyValue",
+		mock.Anything,
+		connector.InstantiationToken("abc"),
+		"foothing",
+		mock.Anything,
+		mock.Anything,
+		mock.Anything,
+		mock.AnythingOfType("time.Time")).Return(fmt.Errorf("failed to send request: %w", connclient.ErrThingNotFound)).Once()
+	connectorClient.On("CreateThing",
+		mock.Anything,
+		connector.InstantiationToken("abc"),
+		mock.AnythingOfType("restapi.Thing")).Return(restapi.Thing{ID: "newthing"}, nil)
+	connectorClient.On("UpdateThingPropertyValue",
+		mock.Anything,
+		connector.InstantiationToken("abc"),
+		"newthing",
+		"waterlevel",
+		"waterlevel",
+		"13.109731",
+		mock.AnythingOfType("time.Time")).Return(nil)
+	connectorClient.On("UpdateThingPropertyValue",
+		mock.Anything,
+		connector.InstantiationToken("abc"),
+		"newthing",
+		"pressure",
+		"pressure",
+		"0.012856",
+		mock.AnythingOfType("time.Time")).Return(nil)
+
+	fr := mux.NewRouter()
+	fr.Path("/lora/{installationId}/{instanceId}").Methods(http.MethodPost).Handler(loraHandler)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(dcl571Body)
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/lora/foo/bar?event=up", buf)
+	w := httptest.NewRecorder()
+
+	fr.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	stopArchiver()
+
+	connectorClient.AssertExpectations(t)
+	store.AssertExpectations(t)
+}
+
+func TestIgnoreDeletedThing(t *testing.T) {
+	connectorClient := new(mocks.Client)
+	store := new(mockDataStore)
+
+	loraHandler := NewLoRaWANHandler(connectorClient, true, store)
+	stopArchiver := runArchiver(loraHandler)
+	devEUI := []byte{0x74, 0xfe, 0x48, 0xff, 0xff, 0x44, 0x76, 0xef}
+
+	store.On("GetInstance", mock.Anything, "bar").Return(connector.InstantiationRequest{
+		InstallationID: "foo",
+		Token:          "abc",
+		ID:             "bar",
+	}, nil)
+	store.On("SetLastEvent", mock.Anything, "bar", mock.AnythingOfType("time.Time")).Return(nil)
+	store.On("GetInstanceConfig", mock.Anything, "bar").Return(DefaultInstanceConfig(), nil)
+	store.On("ArchiveEvent", mock.Anything, mock.MatchedBy(func(e ArchivedEvent) bool {
+		return e.Outcome == EventOutcomeIgnored
+	})).Return(nil)
+	store.On("RecordUplink", mock.Anything, "bar", mock.Anything, mock.AnythingOfType("time.Time")).Return(false, nil)
+
+	store.On("DecoderNameForApp", mock.Anything, "bar", uint64(1)).Return("dcl571", nil)
+	store.On("GetDeviceMapping", mock.Anything, "bar", devEUI).Return(&DeviceMapping{ThingID: "foothing", DecoderName: "dcl571", ModelVersion: 1}, nil).Once()
+	store.On("GetState", mock.Anything, "foothing", "dcl571.waterLevelOffset").Return(nil, decoder.ErrStateNotFound)
+	store.On("ProvisioningPolicy", mock.Anything, "bar").Return(ProvisioningPolicyIgnoreDeleted, nil)
+	store.On("IgnoreDevice", mock.Anything, "bar", devEUI).Return(nil)
+
+	connectorClient.On("UpdateThingPropertyValue",
+		mock.Anything,
+		connector.InstantiationToken("abc"),
+		"foothing",
+		mock.Anything,
+		mock.Anything,
+		mock.Anything,
+		mock.AnythingOfType("time.Time")).Return(fmt.Errorf("failed to send request: %w", connclient.ErrThingNotFound)).Once()
+
+	fr := mux.NewRouter()
+	fr.Path("/lora/{installationId}/{instanceId}").Methods(http.MethodPost).Handler(loraHandler)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(dcl571Body)
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/lora/foo/bar?event=up", buf)
+	w := httptest.NewRecorder()
+	fr.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	// Further uplinks of the device are dropped without contacting connctd
+	store.On("GetDeviceMapping", mock.Anything, "bar", devEUI).Return(nil, ErrDeviceIgnored)
+	buf = &bytes.Buffer{}
+	buf.WriteString(dcl571Body)
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/lora/foo/bar?event=up", buf)
+	w = httptest.NewRecorder()
+	fr.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	stopArchiver()
+
+	connectorClient.AssertExpectations(t)
+	store.AssertExpectations(t)
+}
+
+func TestConcurrentProvisioning(t *testing.T) {
+	connectorClient := new(mocks.Client)
+	store := new(mockDataStore)
+
+	loraHandler := NewLoRaWANHandler(connectorClient, true, store)
+	stopArchiver := runArchiver(loraHandler)
+	devEUI := []byte{0x74, 0xfe, 0x48, 0xff, 0xff, 0x44, 0x76, 0xef}
+
+	store.On("GetInstance", mock.Anything, "bar").Return(connector.InstantiationRequest{
+		InstallationID: "foo",
+		Token:          "abc",
+		ID:             "bar",
+	}, nil)
+	store.On("SetLastEvent", mock.Anything, "bar", mock.AnythingOfType("time.Time")).Return(nil)
+	// Archive disabled
+	store.On("GetInstanceConfig", mock.Anything, "bar").Return(InstanceConfig{}, nil)
+	store.On("RecordUplink", mock.Anything, "bar", mock.Anything, mock.AnythingOfType("time.Time")).Return(false, nil)
+
+	unlocked := false
+	store.On("DecoderNameForApp", mock.Anything, "bar", uint64(1)).Return("dcl571", nil)
+	store.On("GetDeviceMapping", mock.Anything, "bar", devEUI).Return(nil, nil).Twice()
+	store.On("LockDevice", mock.Anything, "bar", devEUI).Return(func() { unlocked = true }, nil)
+	store.On("StoreDeviceMapping", mock.Anything, DeviceMapping{InstanceID: "bar", DevEUI: devEUI, ThingID: "newthing", DecoderName: "dcl571", ModelVersion: 1}).Return(errors.New("duplicate entry"))
+	// The other uplink won the race
+	store.On("GetDeviceMapping", mock.Anything, "bar", devEUI).Return(&DeviceMapping{ThingID: "otherthing", DecoderName: "dcl571", ModelVersion: 1}, nil).Once()
+	store.On("GetState", mock.Anything, "otherthing", "dcl571.waterLevelOffset").Return(nil, decoder.ErrStateNotFound)
+
+	connectorClient.On("CreateThing",
+		mock.Anything,
+		connector.InstantiationToken("abc"),
+		mock.AnythingOfType("restapi.Thing")).Return(restapi.Thing{ID: "newthing"}, nil)
+	connectorClient.On("DeleteThing", mock.Anything, connector.InstantiationToken("abc"), "newthing").Return(nil)
+	connectorClient.On("UpdateThingPropertyValue",
+		mock.Anything,
+		connector.InstantiationToken("abc"),
+		"otherthing",
+		mock.Anything,
+		mock.Anything,
+		mock.Anything,
+		mock.AnythingOfType("time.Time")).Return(nil).Twice()
+
+	fr := mux.NewRouter()
+	fr.Path("/lora/{installationId}/{instanceId}").Methods(http.MethodPost).Handler(loraHandler)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(dcl571Body)
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/lora/foo/bar?event=up", buf)
+	w := httptest.NewRecorder()
+
+	fr.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.True(t, unlocked)
+
+	stopArchiver()
+
+	connectorClient.AssertExpectations(t)
+	store.AssertExpectations(t)
+}
+
+func TestMigrateThings(t *testing.T) {
+	connectorClient := new(mocks.Client)
+	store := new(mockDataStore)
+
+	loraHandler := NewLoRaWANHandler(connectorClient, true, store)
+	devEUI := []byte{0xa8, 0x40, 0x41, 0x4d, 0x61, 0x82, 0xe0, 0x88}
+	outdated := DeviceMapping{InstanceID: "bar", DevEUI: devEUI, ThingID: "oldthing", DecoderName: "ldds75", ModelVersion: 1}
+
+	store.On("OutdatedDeviceMappings", mock.Anything, "bar", "dcl571", uint(1)).Return(nil, nil)
+	store.On("OutdatedDeviceMappings", mock.Anything, "bar", "ldds75", uint(2)).Return([]DeviceMapping{outdated}, nil)
+	store.On("GetInstance", mock.Anything, "bar").Return(connector.InstantiationRequest{
+		InstallationID: "foo",
+		Token:          "abc",
+		ID:             "bar",
+	}, nil)
+	store.On("LockDevice", mock.Anything, "bar", devEUI).Return(func() {}, nil)
+	store.On("GetDeviceMapping", mock.Anything, "bar", devEUI).Return(&outdated, nil)
+	store.On("ReplaceThingID", mock.Anything, "oldthing", DeviceMapping{InstanceID: "bar", DevEUI: devEUI, ThingID: "newthing", DecoderName: "ldds75", ModelVersion: 2}).Return(nil)
+
+	connectorClient.On("CreateThing",
+		mock.Anything,
+		connector.InstantiationToken("abc"),
+		mock.MatchedBy(func(thing restapi.Thing) bool { return len(thing.Components) == 3 })).Return(restapi.Thing{ID: "newthing"}, nil)
+	connectorClient.On("DeleteThing", mock.Anything, connector.InstantiationToken("abc"), "oldthing").Return(nil)
+
+	store.On("GetInstance", mock.Anything, "unknown").Return(connector.InstantiationRequest{}, errors.New("not found"))
+
+	migrated, err := loraHandler.MigrateThings(context.Background(), "bar")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+	_, err = loraHandler.MigrateThings(context.Background(), "unknown")
+	assert.Error(t, err)
+
 	connectorClient.AssertExpectations(t)
 	store.AssertExpectations(t)
+}
+
+func TestReprovisionDevice(t *testing.T) {
+	connectorClient := new(mocks.Client)
+	store := new(mockDataStore)
+
+	loraHandler := NewLoRaWANHandler(connectorClient, true, store)
+	devEUI := []byte{0xa8, 0x40, 0x41, 0x4d, 0x61, 0x82, 0xe0, 0x88}
+	current := DeviceMapping{InstanceID: "bar", DevEUI: devEUI, ThingID: "oldthing", DecoderName: "ldds75", ModelVersion: 2}
+	unknownEUI := []byte{0, 0, 0, 0, 0, 0, 0, 1}
+	ignoredEUI := []byte{0, 0, 0, 0, 0, 0, 0, 2}
+
+	store.On("GetInstance", mock.Anything, "bar").Return(connector.InstantiationRequest{
+		InstallationID: "foo",
+		Token:          "abc",
+		ID:             "bar",
+	}, nil)
+	store.On("LockDevice", mock.Anything, "bar", devEUI).Return(func() {}, nil)
+	store.On("GetDeviceMapping", mock.Anything, "bar", devEUI).Return(&current, nil)
+	store.On("GetDeviceMapping", mock.Anything, "bar", unknownEUI).Return(nil, nil)
+	store.On("GetDeviceMapping", mock.Anything, "bar", ignoredEUI).Return(nil, ErrDeviceIgnored)
+	store.On("ReplaceThingID", mock.Anything, "oldthing", DeviceMapping{InstanceID: "bar", DevEUI: devEUI, ThingID: "newthing", DecoderName: "ldds75", ModelVersion: 2}).Return(nil)
+	connectorClient.On("CreateThing", mock.Anything, connector.InstantiationToken("abc"), mock.Anything).Return(restapi.Thing{ID: "newthing"}, nil)
+	connectorClient.On("DeleteThing", mock.Anything, connector.InstantiationToken("abc"), "oldthing").Return(nil)
+
+	thingID, err := loraHandler.ReprovisionDevice(context.Background(), "bar", devEUI)
+	assert.NoError(t, err)
+	assert.Equal(t, "newthing", thingID)
+
+	_, err = loraHandler.ReprovisionDevice(context.Background(), "bar", unknownEUI)
+	assert.ErrorIs(t, err, ErrUnknownDevice)
+	_, err = loraHandler.ReprovisionDevice(context.Background(), "bar", ignoredEUI)
+	assert.ErrorIs(t, err, ErrDeviceIgnored)
+
+	connectorClient.AssertExpectations(t)
+	store.AssertExpectations(t)
+}
+
+func TestDeviceBackOnline(t *testing.T) {
+	for name, statusErr := range map[string]error{
+		"marked available": nil,
+		// The device stays offline, so that the next uplink retries
+		"marking failed": errors.New("connctd unavailable"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			testDeviceBackOnline(t, statusErr)
+		})
+	}
+}
+
+func testDeviceBackOnline(t *testing.T, statusErr error) {
+	connectorClient := new(mocks.Client)
+	store := new(mockDataStore)
+
+	loraHandler := NewLoRaWANHandler(connectorClient, true, store)
+	stopArchiver := runArchiver(loraHandler)
+	devEUI := []byte{0xa8, 0x40, 0x41, 0x4d, 0x61, 0x82, 0xe0, 0x88}
+
+	store.On("GetInstance", mock.Anything, "bar").Return(connector.InstantiationRequest{
+		InstallationID: "foo",
+		Token:          "abc",
+		ID:             "bar",
+	}, nil)
+	store.On("SetLastEvent", mock.Anything, "bar", mock.AnythingOfType("time.Time")).Return(nil)
+	// Archive disabled
+	store.On("GetInstanceConfig", mock.Anything, "bar").Return(InstanceConfig{}, nil)
+	store.On("DecoderNameForApp", mock.Anything, "bar", uint64(2)).Return("ldds75", nil)
+	store.On("GetDeviceMapping", mock.Anything, "bar", devEUI).Return(&DeviceMapping{ThingID: "foothing", DecoderName: "ldds75", ModelVersion: 2}, nil)
+	store.On("RecordUplink", mock.Anything, "bar", devEUI, mock.AnythingOfType("time.Time")).Return(true, nil)
+	store.On("GetState", mock.Anything, "foothing", "ldds75.mountingHeight").Return([]byte("0"), nil)
+
+	connectorClient.On("UpdateThingStatus", mock.Anything, connector.InstantiationToken("abc"), "foothing", restapi.StatusTypeAvailable).Return(statusErr)
+	if statusErr == nil {
+		store.On("SetDeviceOnline", mock.Anything, "bar", devEUI).Return(nil)
+	}
+	connectorClient.On("UpdateThingPropertyValue",
+		mock.Anything,
+		connector.InstantiationToken("abc"),
+		"foothing",
+		mock.Anything,
+		mock.Anything,
+		mock.Anything,
+		mock.AnythingOfType("time.Time")).Return(nil).Twice()
+
+	fr := mux.NewRouter()
+	fr.Path("/lora/{installationId}/{instanceId}").Methods(http.MethodPost).Handler(loraHandler)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(ldds75.TestPayload)
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/lora/foo/bar?event=up", buf)
+	w := httptest.NewRecorder()
+
+	fr.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	stopArchiver()
+
+	connectorClient.AssertExpectations(t)
+	store.AssertExpectations(t)
+}
+
+func TestParseUplink(t *testing.T) {
+	up, err := ParseUplink([]byte(ldds75.TestPayload))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), up.FPort)
+	assert.Equal(t, []byte{0x0d, 0x08, 0x00, 0xf9, 0x00}, up.Data)
+
+	_, err = ParseUplink([]byte("not json"))
+	assert.Error(t, err)
+}
+
+// TestArchiveQueue drops events while the archive queue is full and archives the queued events when
+// the archiver is stopped
+func TestArchiveQueue(t *testing.T) {
+	store := new(mockDataStore)
+	loraHandler := NewLoRaWANHandler(new(mocks.Client), true, store)
+	store.On("GetInstanceConfig", mock.Anything, "bar").Return(DefaultInstanceConfig(), nil)
+	store.On("ArchiveEvent", mock.Anything, mock.Anything).Return(nil)
+
+	for i := 0; i <= archiveQueueSize; i++ {
+		loraHandler.archive(context.Background(), ArchivedEvent{InstanceID: "bar", Event: "up"})
+	}
+	stopArchiver := runArchiver(loraHandler)
+	stopArchiver()
 
+	store.AssertNumberOfCalls(t, "ArchiveEvent", archiveQueueSize)
 }
@@ -3,8 +3,11 @@
 package lorawan
 
 import (
+	context "context"
 	connector "github.com/connctd/connector-go"
+	decoder "github.com/connctd/lora-connector/lorawan/decoder"
 	mock "github.com/stretchr/testify/mock"
+	time "time"
 )
 
 // mockDataStore is an autogenerated mock type for the dataStore type
@@ -12,20 +15,34 @@ type mockDataStore struct {
 	mock.Mock
 }
 
-// DecoderNameForApp provides a mock function with given fields: instanceID, appId
-func (_m *mockDataStore) DecoderNameForApp(instanceID string, appId uint64) (string, error) {
-	ret := _m.Called(instanceID, appId)
+// ArchiveEvent provides a mock function with given fields: ctx, event
+func (_m *mockDataStore) ArchiveEvent(ctx context.Context, event ArchivedEvent) error {
+	ret := _m.Called(ctx, event)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, ArchivedEvent) error); ok {
+		r0 = rf(ctx, event)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DecoderNameForApp provides a mock function with given fields: ctx, instanceID, appId
+func (_m *mockDataStore) DecoderNameForApp(ctx context.Context, instanceID string, appId uint64) (string, error) {
+	ret := _m.Called(ctx, instanceID, appId)
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(string, uint64) string); ok {
-		r0 = rf(instanceID, appId)
+	if rf, ok := ret.Get(0).(func(context.Context, string, uint64) string); ok {
+		r0 = rf(ctx, instanceID, appId)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, uint64) error); ok {
-		r1 = rf(instanceID, appId)
+	if rf, ok := ret.Get(1).(func(context.Context, string, uint64) error); ok {
+		r1 = rf(ctx, instanceID, appId)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -33,20 +50,43 @@ func (_m *mockDataStore) DecoderNameForApp(instanceID string, appId uint64) (str
 	return r0, r1
 }
 
-// GetInstallationToken provides a mock function with given fields: installationId
-func (_m *mockDataStore) GetInstallationToken(installationId string) (connector.InstallationToken, error) {
-	ret := _m.Called(installationId)
+// GetDeviceMapping provides a mock function with given fields: ctx, instanceID, devEUI
+func (_m *mockDataStore) GetDeviceMapping(ctx context.Context, instanceID string, devEUI []byte) (*DeviceMapping, error) {
+	ret := _m.Called(ctx, instanceID, devEUI)
+
+	var r0 *DeviceMapping
+	if rf, ok := ret.Get(0).(func(context.Context, string, []byte) *DeviceMapping); ok {
+		r0 = rf(ctx, instanceID, devEUI)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*DeviceMapping)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, []byte) error); ok {
+		r1 = rf(ctx, instanceID, devEUI)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetInstallationToken provides a mock function with given fields: ctx, installationId
+func (_m *mockDataStore) GetInstallationToken(ctx context.Context, installationId string) (connector.InstallationToken, error) {
+	ret := _m.Called(ctx, installationId)
 
 	var r0 connector.InstallationToken
-	if rf, ok := ret.Get(0).(func(string) connector.InstallationToken); ok {
-		r0 = rf(installationId)
+	if rf, ok := ret.Get(0).(func(context.Context, string) connector.InstallationToken); ok {
+		r0 = rf(ctx, installationId)
 	} else {
 		r0 = ret.Get(0).(connector.InstallationToken)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(installationId)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, installationId)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -54,20 +94,41 @@ func (_m *mockDataStore) GetInstallationToken(installationId string) (connector.
 	return r0, r1
 }
 
-// GetInstance provides a mock function with given fields: instanceId
-func (_m *mockDataStore) GetInstance(instanceId string) (connector.InstantiationRequest, error) {
-	ret := _m.Called(instanceId)
+// GetInstance provides a mock function with given fields: ctx, instanceId
+func (_m *mockDataStore) GetInstance(ctx context.Context, instanceId string) (connector.InstantiationRequest, error) {
+	ret := _m.Called(ctx, instanceId)
 
 	var r0 connector.InstantiationRequest
-	if rf, ok := ret.Get(0).(func(string) connector.InstantiationRequest); ok {
-		r0 = rf(instanceId)
+	if rf, ok := ret.Get(0).(func(context.Context, string) connector.InstantiationRequest); ok {
+		r0 = rf(ctx, instanceId)
 	} else {
 		r0 = ret.Get(0).(connector.InstantiationRequest)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(instanceId)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, instanceId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetInstanceConfig provides a mock function with given fields: ctx, instanceID
+func (_m *mockDataStore) GetInstanceConfig(ctx context.Context, instanceID string) (InstanceConfig, error) {
+	ret := _m.Called(ctx, instanceID)
+
+	var r0 InstanceConfig
+	if rf, ok := ret.Get(0).(func(context.Context, string) InstanceConfig); ok {
+		r0 = rf(ctx, instanceID)
+	} else {
+		r0 = ret.Get(0).(InstanceConfig)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, instanceID)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -75,13 +136,13 @@ func (_m *mockDataStore) GetInstance(instanceId string) (connector.Instantiation
 	return r0, r1
 }
 
-// GetState provides a mock function with given fields: thingID, key
-func (_m *mockDataStore) GetState(thingID string, key string) ([]byte, error) {
-	ret := _m.Called(thingID, key)
+// GetState provides a mock function with given fields: ctx, thingID, key
+func (_m *mockDataStore) GetState(ctx context.Context, thingID string, key string) ([]byte, error) {
+	ret := _m.Called(ctx, thingID, key)
 
 	var r0 []byte
-	if rf, ok := ret.Get(0).(func(string, string) []byte); ok {
-		r0 = rf(thingID, key)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) []byte); ok {
+		r0 = rf(ctx, thingID, key)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]byte)
@@ -89,8 +150,8 @@ func (_m *mockDataStore) GetState(thingID string, key string) ([]byte, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(thingID, key)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, thingID, key)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -98,20 +159,59 @@ func (_m *mockDataStore) GetState(thingID string, key string) ([]byte, error) {
 	return r0, r1
 }
 
-// MapDevEUIToThingID provides a mock function with given fields: instanceID, devEUI
-func (_m *mockDataStore) MapDevEUIToThingID(instanceID string, devEUI []byte) (string, error) {
-	ret := _m.Called(instanceID, devEUI)
+// IgnoreDevice provides a mock function with given fields: ctx, instanceID, devEUI
+func (_m *mockDataStore) IgnoreDevice(ctx context.Context, instanceID string, devEUI []byte) error {
+	ret := _m.Called(ctx, instanceID, devEUI)
 
-	var r0 string
-	if rf, ok := ret.Get(0).(func(string, []byte) string); ok {
-		r0 = rf(instanceID, devEUI)
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []byte) error); ok {
+		r0 = rf(ctx, instanceID, devEUI)
 	} else {
-		r0 = ret.Get(0).(string)
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ListArchivedEvents provides a mock function with given fields: ctx, filter
+func (_m *mockDataStore) ListArchivedEvents(ctx context.Context, filter ArchiveFilter) ([]ArchivedEvent, error) {
+	ret := _m.Called(ctx, filter)
+
+	var r0 []ArchivedEvent
+	if rf, ok := ret.Get(0).(func(context.Context, ArchiveFilter) []ArchivedEvent); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]ArchivedEvent)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, ArchiveFilter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// LockDevice provides a mock function with given fields: ctx, instanceID, devEUI
+func (_m *mockDataStore) LockDevice(ctx context.Context, instanceID string, devEUI []byte) (func(), error) {
+	ret := _m.Called(ctx, instanceID, devEUI)
+
+	var r0 func()
+	if rf, ok := ret.Get(0).(func(context.Context, string, []byte) func()); ok {
+		r0 = rf(ctx, instanceID, devEUI)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(func())
+		}
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, []byte) error); ok {
-		r1 = rf(instanceID, devEUI)
+	if rf, ok := ret.Get(1).(func(context.Context, string, []byte) error); ok {
+		r1 = rf(ctx, instanceID, devEUI)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -119,13 +219,148 @@ func (_m *mockDataStore) MapDevEUIToThingID(instanceID string, devEUI []byte) (s
 	return r0, r1
 }
 
-// SetState provides a mock function with given fields: thingId, key, value
-func (_m *mockDataStore) SetState(thingId string, key string, value []byte) error {
-	ret := _m.Called(thingId, key, value)
+// OutdatedDeviceMappings provides a mock function with given fields: ctx, instanceID, decoderName, modelVersion
+func (_m *mockDataStore) OutdatedDeviceMappings(ctx context.Context, instanceID string, decoderName string, modelVersion uint) ([]DeviceMapping, error) {
+	ret := _m.Called(ctx, instanceID, decoderName, modelVersion)
+
+	var r0 []DeviceMapping
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, uint) []DeviceMapping); ok {
+		r0 = rf(ctx, instanceID, decoderName, modelVersion)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]DeviceMapping)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, uint) error); ok {
+		r1 = rf(ctx, instanceID, decoderName, modelVersion)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ProvisioningPolicy provides a mock function with given fields: ctx, instanceID
+func (_m *mockDataStore) ProvisioningPolicy(ctx context.Context, instanceID string) (ProvisioningPolicy, error) {
+	ret := _m.Called(ctx, instanceID)
+
+	var r0 ProvisioningPolicy
+	if rf, ok := ret.Get(0).(func(context.Context, string) ProvisioningPolicy); ok {
+		r0 = rf(ctx, instanceID)
+	} else {
+		r0 = ret.Get(0).(ProvisioningPolicy)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, instanceID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RecordUplink provides a mock function with given fields: ctx, instanceID, devEUI, t
+func (_m *mockDataStore) RecordUplink(ctx context.Context, instanceID string, devEUI []byte, t time.Time) (bool, error) {
+	ret := _m.Called(ctx, instanceID, devEUI, t)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string, []byte, time.Time) bool); ok {
+		r0 = rf(ctx, instanceID, devEUI, t)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, []byte, time.Time) error); ok {
+		r1 = rf(ctx, instanceID, devEUI, t)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReplaceThingID provides a mock function with given fields: ctx, staleThingID, mapping
+func (_m *mockDataStore) ReplaceThingID(ctx context.Context, staleThingID string, mapping DeviceMapping) error {
+	ret := _m.Called(ctx, staleThingID, mapping)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, DeviceMapping) error); ok {
+		r0 = rf(ctx, staleThingID, mapping)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetArchivedUpdates provides a mock function with given fields: ctx, eventID, updates
+func (_m *mockDataStore) SetArchivedUpdates(ctx context.Context, eventID uint64, updates []decoder.PropertyUpdate) error {
+	ret := _m.Called(ctx, eventID, updates)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uint64, []decoder.PropertyUpdate) error); ok {
+		r0 = rf(ctx, eventID, updates)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetDeviceDecoder provides a mock function with given fields: ctx, instanceID, devEUI, decoderName, modelVersion
+func (_m *mockDataStore) SetDeviceDecoder(ctx context.Context, instanceID string, devEUI []byte, decoderName string, modelVersion uint) error {
+	ret := _m.Called(ctx, instanceID, devEUI, decoderName, modelVersion)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []byte, string, uint) error); ok {
+		r0 = rf(ctx, instanceID, devEUI, decoderName, modelVersion)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetDeviceOnline provides a mock function with given fields: ctx, instanceID, devEUI
+func (_m *mockDataStore) SetDeviceOnline(ctx context.Context, instanceID string, devEUI []byte) error {
+	ret := _m.Called(ctx, instanceID, devEUI)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []byte) error); ok {
+		r0 = rf(ctx, instanceID, devEUI)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetLastEvent provides a mock function with given fields: ctx, instanceID, t
+func (_m *mockDataStore) SetLastEvent(ctx context.Context, instanceID string, t time.Time) error {
+	ret := _m.Called(ctx, instanceID, t)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) error); ok {
+		r0 = rf(ctx, instanceID, t)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetState provides a mock function with given fields: ctx, thingID, key, value
+func (_m *mockDataStore) SetState(ctx context.Context, thingID string, key string, value []byte) error {
+	ret := _m.Called(ctx, thingID, key, value)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, string, []byte) error); ok {
-		r0 = rf(thingId, key, value)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, []byte) error); ok {
+		r0 = rf(ctx, thingID, key, value)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -133,13 +368,13 @@ func (_m *mockDataStore) SetState(thingId string, key string, value []byte) erro
 	return r0
 }
 
-// StoreDEVUIToThingID provides a mock function with given fields: instanceID, devEUI, thingID
-func (_m *mockDataStore) StoreDEVUIToThingID(instanceID string, devEUI []byte, thingID string) error {
-	ret := _m.Called(instanceID, devEUI, thingID)
+// StoreDeviceMapping provides a mock function with given fields: ctx, mapping
+func (_m *mockDataStore) StoreDeviceMapping(ctx context.Context, mapping DeviceMapping) error {
+	ret := _m.Called(ctx, mapping)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, []byte, string) error); ok {
-		r0 = rf(instanceID, devEUI, thingID)
+	if rf, ok := ret.Get(0).(func(context.Context, DeviceMapping) error); ok {
+		r0 = rf(ctx, mapping)
 	} else {
 		r0 = ret.Error(0)
 	}
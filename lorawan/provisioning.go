@@ -0,0 +1,308 @@
+package lorawan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/connclient"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/connctd/lora-connector/metrics"
+	"github.com/connctd/restapi-go"
+	"github.com/sirupsen/logrus"
+)
+
+// ProvisioningPolicy defines how an instance reacts to things which have been
+// deleted on the connctd platform while the device is still sending uplinks
+type ProvisioningPolicy string
+
+const (
+	// ProvisioningPolicyRecreate creates a new thing for the device and carries
+	// over the stored decoder state
+	ProvisioningPolicyRecreate ProvisioningPolicy = "recreate"
+	// ProvisioningPolicyIgnoreDeleted treats the deletion as a request to ignore
+	// the device from now on
+	ProvisioningPolicyIgnoreDeleted ProvisioningPolicy = "ignore-deleted"
+)
+
+// ErrDeviceIgnored is returned by the data store if uplinks of a device
+// should be dropped, because its thing has been deleted.
+var ErrDeviceIgnored = errors.New("device is ignored")
+
+// ErrUnknownDevice is returned if a device has no thing yet
+var ErrUnknownDevice = errors.New("unknown device")
+
+// ErrUnknownDecoder is returned if the decoder of a device mapping created before decoder names were
+// stored is not known yet. It is recorded with the next uplink of the device.
+var ErrUnknownDecoder = errors.New("decoder of device is not known yet, it is recorded with the next uplink")
+
+// ParseProvisioningPolicy validates the given policy name
+func ParseProvisioningPolicy(s string) (ProvisioningPolicy, error) {
+	switch p := ProvisioningPolicy(s); p {
+	case ProvisioningPolicyRecreate, ProvisioningPolicyIgnoreDeleted:
+		return p, nil
+	}
+	return "", errors.New("unknown provisioning policy")
+}
+
+// DeviceMapping maps a LoRaWAN device to its thing in connctd. The decoder name and
+// the model version describe the thing model the thing was created with.
+type DeviceMapping struct {
+	InstanceID   string
+	DevEUI       []byte
+	ThingID      string
+	DecoderName  string
+	ModelVersion uint
+}
+
+// device bundles everything known about a LoRaWAN device which is needed to provision its thing
+type device struct {
+	instanceID   string
+	devEUI       []byte
+	formattedEUI string
+	decoderName  string
+	decoder      decoder.PayloadDecoder
+}
+
+// unversionedModels are the thing model versions of decoders at the time thing models were versioned.
+// Mappings created before have no version, but their things have the model of this version.
+var unversionedModels = map[string]uint{
+	"ldds75": 2,
+}
+
+// UnversionedModelVersion returns the thing model version of things created by the decoder before
+// thing models were versioned
+func UnversionedModelVersion(decoderName string) uint {
+	if version, ok := unversionedModels[decoderName]; ok {
+		return version
+	}
+	return 1
+}
+
+func (d device) mapping(thingID string) DeviceMapping {
+	return DeviceMapping{
+		InstanceID:   d.instanceID,
+		DevEUI:       d.devEUI,
+		ThingID:      thingID,
+		DecoderName:  d.decoderName,
+		ModelVersion: decoder.ModelVersion(d.decoder),
+	}
+}
+
+// createThing creates a new thing for a LoRaWAN device based on the model of the payload decoder
+func (l *LoRaWANHandler) createThing(ctx context.Context, token connector.InstantiationToken, dev device) (string, error) {
+	attributes := []restapi.ThingAttribute{
+		{
+			Name:  "lora.deveui",
+			Value: dev.formattedEUI,
+		},
+	}
+	thing, err := dev.decoder.Device(attributes)
+	if err != nil {
+		return "", err
+	}
+	result, err := l.connectorClient.CreateThing(ctx, token, *thing)
+	if err != nil {
+		return "", err
+	}
+	metrics.ThingsCreated.WithLabelValues(dev.decoderName).Inc()
+	return result.ID, nil
+}
+
+// lockDevice serializes provisioning of the device, see dataStore.LockDevice
+func (l *LoRaWANHandler) lockDevice(ctx context.Context, dev device) (func(), error) {
+	metrics.DeviceLockWaiters.Inc()
+	defer metrics.DeviceLockWaiters.Dec()
+	return l.store.LockDevice(ctx, dev.instanceID, dev.devEUI)
+}
+
+// provisionThing creates a thing for a yet unknown device and stores the mapping. Provisioning is
+// serialized per device, so that concurrent uplinks of a new device do not create several things.
+func (l *LoRaWANHandler) provisionThing(ctx context.Context, token connector.InstantiationToken, dev device) (string, error) {
+	unlock, err := l.lockDevice(ctx, dev)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// Another uplink might have provisioned the device while we were waiting for the lock
+	mapping, err := l.store.GetDeviceMapping(ctx, dev.instanceID, dev.devEUI)
+	if err != nil {
+		return "", err
+	}
+	if mapping != nil {
+		return mapping.ThingID, nil
+	}
+
+	thingID, err := l.createThing(ctx, token, dev)
+	if err != nil {
+		return "", err
+	}
+	if err := l.store.StoreDeviceMapping(ctx, dev.mapping(thingID)); err != nil {
+		// The thing we just created is orphaned, either because storing failed or because the
+		// device has been provisioned concurrently without holding the lock
+		l.deleteOrphanedThing(ctx, token, thingID)
+		if existing, lookupErr := l.store.GetDeviceMapping(ctx, dev.instanceID, dev.devEUI); lookupErr == nil && existing != nil {
+			l.logger.WithField("thingID", existing.ThingID).Warn("Device has been provisioned concurrently, using existing thing")
+			return existing.ThingID, nil
+		}
+		return "", err
+	}
+	return thingID, nil
+}
+
+func (l *LoRaWANHandler) deleteOrphanedThing(ctx context.Context, token connector.InstantiationToken, thingID string) {
+	logger := l.logger.WithField("thingID", thingID)
+	if err := l.connectorClient.DeleteThing(ctx, token, thingID); err != nil {
+		logger.WithError(err).Error("Failed to delete orphaned thing")
+		return
+	}
+	logger.Info("Deleted orphaned thing")
+}
+
+// reprovisionThing handles a thing which has been deleted on the connctd platform according to the
+// provisioning policy of the instance. It returns the ID of the newly created thing or an empty string
+// if the device is ignored from now on.
+func (l *LoRaWANHandler) reprovisionThing(ctx context.Context, token connector.InstantiationToken, dev device, staleThingID string) (string, error) {
+	logger := l.logger.WithFields(logrus.Fields{
+		"instanceId":   dev.instanceID,
+		"deviceID":     dev.formattedEUI,
+		"staleThingID": staleThingID,
+	})
+	policy, err := l.store.ProvisioningPolicy(ctx, dev.instanceID)
+	if err != nil {
+		return "", err
+	}
+	if policy == ProvisioningPolicyIgnoreDeleted {
+		logger.Info("Thing has been deleted in connctd, ignoring device from now on")
+		return "", l.store.IgnoreDevice(ctx, dev.instanceID, dev.devEUI)
+	}
+
+	thingID, err := l.replaceThing(ctx, token, dev, staleThingID)
+	if err != nil {
+		return "", err
+	}
+	logger.WithField("thingID", thingID).Info("Thing has been deleted in connctd, recreated thing")
+	return thingID, nil
+}
+
+// replaceThing creates a new thing for the device based on the current model of its decoder and
+// maps the device to it. The decoder state of the stale thing is carried over.
+func (l *LoRaWANHandler) replaceThing(ctx context.Context, token connector.InstantiationToken, dev device, staleThingID string) (string, error) {
+	unlock, err := l.lockDevice(ctx, dev)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// Another uplink might have replaced the thing while we were waiting for the lock
+	current, err := l.store.GetDeviceMapping(ctx, dev.instanceID, dev.devEUI)
+	if err != nil {
+		return "", err
+	}
+	if current != nil && current.ThingID != staleThingID {
+		return current.ThingID, nil
+	}
+
+	thingID, err := l.createThing(ctx, token, dev)
+	if err != nil {
+		return "", err
+	}
+	if err := l.store.ReplaceThingID(ctx, staleThingID, dev.mapping(thingID)); err != nil {
+		l.deleteOrphanedThing(ctx, token, thingID)
+		return "", err
+	}
+	return thingID, nil
+}
+
+// MigrateThings brings the things of the instance up to the current thing model of their decoder.
+// Since the connctd API does not allow to change the components of existing things, outdated things
+// are replaced by new things. This changes the IDs users refer to the things with, so instances need
+// to opt in explicitly. Decoder state is carried over and the outdated things are deleted.
+// It returns the number of migrated things.
+func (l *LoRaWANHandler) MigrateThings(ctx context.Context, instanceID string) (int, error) {
+	if _, err := l.store.GetInstance(ctx, instanceID); err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, decoderName := range decoder.Names() {
+		payloadDecoder := decoder.GetDecoder(decoderName)
+		version := decoder.ModelVersion(payloadDecoder)
+		mappings, err := l.store.OutdatedDeviceMappings(ctx, instanceID, decoderName, version)
+		if err != nil {
+			return migrated, err
+		}
+		for _, mapping := range mappings {
+			if err := ctx.Err(); err != nil {
+				return migrated, err
+			}
+			if _, err := l.migrateThing(ctx, payloadDecoder, mapping); err != nil {
+				l.logger.WithError(err).WithFields(logrus.Fields{
+					"instanceId":   mapping.InstanceID,
+					"thingID":      mapping.ThingID,
+					"decoder":      decoderName,
+					"modelVersion": mapping.ModelVersion,
+				}).Error("Failed to migrate thing to current thing model")
+				continue
+			}
+			migrated++
+		}
+	}
+	return migrated, nil
+}
+
+// ReprovisionDevice replaces the thing of a device by a new thing based on the current model of its
+// decoder, e.g. after the thing has been modified in connctd. Decoder state is carried over and the
+// old thing is deleted. It returns the ID of the new thing.
+func (l *LoRaWANHandler) ReprovisionDevice(ctx context.Context, instanceID string, devEUI []byte) (string, error) {
+	mapping, err := l.store.GetDeviceMapping(ctx, instanceID, devEUI)
+	if err != nil {
+		return "", err
+	}
+	if mapping == nil {
+		return "", ErrUnknownDevice
+	}
+	if mapping.DecoderName == "" {
+		return "", ErrUnknownDecoder
+	}
+	payloadDecoder := decoder.GetDecoder(mapping.DecoderName)
+	if payloadDecoder == nil {
+		return "", fmt.Errorf("no payload decoder %q", mapping.DecoderName)
+	}
+	return l.migrateThing(ctx, payloadDecoder, *mapping)
+}
+
+// migrateThing replaces the thing of the mapping by a new thing of the decoder and returns its ID
+func (l *LoRaWANHandler) migrateThing(ctx context.Context, payloadDecoder decoder.PayloadDecoder, mapping DeviceMapping) (string, error) {
+	instance, err := l.store.GetInstance(ctx, mapping.InstanceID)
+	if err != nil {
+		return "", err
+	}
+	formattedEUI, err := formatEUI(mapping.DevEUI)
+	if err != nil {
+		return "", err
+	}
+	dev := device{
+		instanceID:   mapping.InstanceID,
+		devEUI:       mapping.DevEUI,
+		formattedEUI: formattedEUI,
+		decoderName:  mapping.DecoderName,
+		decoder:      payloadDecoder,
+	}
+	thingID, err := l.replaceThing(ctx, instance.Token, dev, mapping.ThingID)
+	if err != nil {
+		return "", err
+	}
+	logger := l.logger.WithFields(logrus.Fields{
+		"instanceId":   mapping.InstanceID,
+		"deviceID":     formattedEUI,
+		"staleThingID": mapping.ThingID,
+		"thingID":      thingID,
+	})
+	if err := l.connectorClient.DeleteThing(ctx, instance.Token, mapping.ThingID); err != nil && !connclient.IsThingNotFound(err) {
+		logger.WithError(err).Warn("Failed to delete outdated thing")
+	}
+	logger.Info("Replaced thing by a thing of the current thing model")
+	return thingID, nil
+}
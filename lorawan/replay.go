@@ -0,0 +1,244 @@
+package lorawan
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/connclient"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrMissingInstance is returned if a replay does not select an instance
+var ErrMissingInstance = errors.New("replay needs an instance")
+
+// replayPageSize is the number of archived events Replay reads at once
+const replayPageSize = 500
+
+// ParseEUI parses a hex encoded EUI, bytes may be separated by dashes or colons
+func ParseEUI(s string) ([]byte, error) {
+	eui, err := hex.DecodeString(strings.NewReplacer("-", "", ":", "").Replace(s))
+	if err != nil || len(eui) != 8 {
+		return nil, fmt.Errorf("invalid EUI %q, expected 8 hex encoded bytes", s)
+	}
+	return eui, nil
+}
+
+// ReplayOptions select the archived uplinks to replay. Zero values match all uplinks of the instance.
+type ReplayOptions struct {
+	InstanceID    string
+	DevEUI        []byte
+	ApplicationID uint64
+	// From and To limit the time the uplinks were received, To is exclusive
+	From time.Time
+	To   time.Time
+	// DryRun only reports the changed property values without pushing them to connctd
+	DryRun bool
+}
+
+// PropertyChange is a property value which differs between the original processing of an uplink
+// and the replay
+type PropertyChange struct {
+	EventID     uint64    `json:"eventId"`
+	DevEUI      string    `json:"devEui"`
+	ThingID     string    `json:"thingId"`
+	ComponentID string    `json:"componentId"`
+	PropertyID  string    `json:"propertyId"`
+	Time        time.Time `json:"time"`
+	// OldValue is missing if the original processing did not update the property
+	OldValue *string `json:"oldValue,omitempty"`
+	NewValue string  `json:"newValue"`
+}
+
+// ReplayReport lists the changes of a replay, or the changes a dry run would push
+type ReplayReport struct {
+	DryRun bool `json:"dryRun"`
+	// Replayed is the number of decoded uplinks
+	Replayed int `json:"replayed"`
+	// Skipped counts archived events which are no uplinks, can't be parsed, belong to another
+	// application or to devices without thing
+	Skipped int              `json:"skipped"`
+	Changes []PropertyChange `json:"changes,omitempty"`
+	// Errors contains uplinks which failed to decode or whose changes could not be pushed
+	Errors []string `json:"errors,omitempty"`
+}
+
+// replayState lets decoders read the current decoder state, but drops changes, since replayed
+// uplinks are older than the stored state
+type replayState struct {
+	decoder.ContextStateStore
+}
+
+func (replayState) SetState(ctx context.Context, thingID, key string, value []byte) error {
+	return nil
+}
+
+// Replay decodes archived uplinks with the current decoder of their device and pushes property values
+// which differ from the values pushed originally to connctd, with the time the uplink was received.
+// Uplinks are replayed in the order they were archived.
+// Replays have no side effects besides the property updates: no things are created, and uplinks of
+// devices without thing are skipped.
+func (l *LoRaWANHandler) Replay(ctx context.Context, options ReplayOptions) (*ReplayReport, error) {
+	if options.InstanceID == "" {
+		return nil, ErrMissingInstance
+	}
+	instance, err := l.store.GetInstance(ctx, options.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	logger := l.logger.WithFields(logrus.Fields{
+		"instanceId": options.InstanceID,
+		"dryRun":     options.DryRun,
+	})
+
+	report := &ReplayReport{DryRun: options.DryRun}
+	// The archive is paged by ID, so that replays of long time ranges don't load all events at once
+	filter := ArchiveFilter{
+		InstanceID: options.InstanceID,
+		DevEUI:     options.DevEUI,
+		From:       options.From,
+		To:         options.To,
+		Limit:      replayPageSize,
+		ByID:       true,
+	}
+	for {
+		events, err := l.store.ListArchivedEvents(ctx, filter)
+		if err != nil {
+			return report, err
+		}
+		for _, event := range events {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			filter.AfterID = event.ID
+			l.replayEvent(ctx, instance.Token, options, event, report, logger)
+		}
+		if len(events) < replayPageSize {
+			break
+		}
+	}
+	logger.WithFields(logrus.Fields{
+		"replayed": report.Replayed,
+		"skipped":  report.Skipped,
+		"changes":  len(report.Changes),
+		"errors":   len(report.Errors),
+	}).Info("Replayed archived uplinks")
+	return report, nil
+}
+
+// replayEvent replays a single archived event and records the result in the report
+func (l *LoRaWANHandler) replayEvent(ctx context.Context, token connector.InstantiationToken, options ReplayOptions, event ArchivedEvent, report *ReplayReport, logger logrus.FieldLogger) {
+	if event.Event != "up" {
+		report.Skipped++
+		return
+	}
+	var up integration.UplinkEvent
+	if err := unmarshal(event.Encoding, event.Body, &up); err != nil {
+		report.Skipped++
+		return
+	}
+	if options.ApplicationID != 0 && up.ApplicationId != options.ApplicationID {
+		report.Skipped++
+		return
+	}
+	changes, updates, err := l.replayUplink(ctx, options.InstanceID, event, &up)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("event %d: %s", event.ID, err))
+		return
+	}
+	if updates == nil {
+		report.Skipped++
+		return
+	}
+	report.Replayed++
+	report.Changes = append(report.Changes, changes...)
+	if options.DryRun || len(changes) == 0 {
+		return
+	}
+
+	if err := l.pushChanges(ctx, token, changes); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("event %d: %s", event.ID, err))
+		return
+	}
+	if err := l.store.SetArchivedUpdates(ctx, event.ID, updates); err != nil {
+		logger.WithError(err).WithField("eventId", event.ID).Warn("Failed to store replayed property updates")
+	}
+}
+
+// replayUplink decodes the uplink and returns the property values which differ from the archived
+// updates, together with all updates. Updates are nil if the device has no thing.
+func (l *LoRaWANHandler) replayUplink(ctx context.Context, instanceID string, event ArchivedEvent, up *integration.UplinkEvent) ([]PropertyChange, []decoder.PropertyUpdate, error) {
+	mapping, err := l.store.GetDeviceMapping(ctx, instanceID, up.DevEui)
+	if errors.Is(err, ErrDeviceIgnored) || (err == nil && mapping == nil) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	decoderName := mapping.DecoderName
+	if decoderName == "" {
+		if decoderName, err = l.store.DecoderNameForApp(ctx, instanceID, up.ApplicationId); err != nil {
+			return nil, nil, err
+		}
+	}
+	payloadDecoder := decoder.GetDecoder(decoderName)
+	if payloadDecoder == nil {
+		return nil, nil, fmt.Errorf("no payload decoder %s", decoderName)
+	}
+	updates, err := payloadDecoder.DecodeMessage(decoder.BindContext(ctx, replayState{l.store}), up.FPort, up.Data, mapping.ThingID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if updates == nil {
+		updates = []decoder.PropertyUpdate{}
+	}
+
+	old := make(map[string]string, len(event.Updates))
+	for _, update := range event.Updates {
+		old[update.ComponentID+"/"+update.PropertyID] = update.Value
+	}
+	var changes []PropertyChange
+	for i := range updates {
+		update := &updates[i]
+		if update.UpdateTime.IsZero() {
+			update.UpdateTime = event.ReceivedAt
+		}
+		change := PropertyChange{
+			EventID:     event.ID,
+			DevEUI:      fmt.Sprintf("%x", up.DevEui),
+			ThingID:     update.ThingID,
+			ComponentID: update.ComponentID,
+			PropertyID:  update.PropertyID,
+			Time:        update.UpdateTime,
+			NewValue:    update.Value,
+		}
+		if oldValue, ok := old[update.ComponentID+"/"+update.PropertyID]; ok {
+			if oldValue == update.Value {
+				continue
+			}
+			change.OldValue = &oldValue
+		}
+		changes = append(changes, change)
+	}
+	return changes, updates, nil
+}
+
+// pushChanges updates the changed property values in connctd. Deleted things are not recreated.
+func (l *LoRaWANHandler) pushChanges(ctx context.Context, token connector.InstantiationToken, changes []PropertyChange) error {
+	for _, change := range changes {
+		err := l.connectorClient.UpdateThingPropertyValue(ctx, token, change.ThingID, change.ComponentID, change.PropertyID, change.NewValue, change.Time)
+		if connclient.IsThingNotFound(err) {
+			return fmt.Errorf("thing %s does not exist anymore", change.ThingID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update %s/%s of thing %s: %w", change.ComponentID, change.PropertyID, change.ThingID, err)
+		}
+	}
+	return nil
+}
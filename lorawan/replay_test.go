@@ -0,0 +1,145 @@
+package lorawan_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/lorawan/decoder/ldds75"
+	"github.com/connctd/lora-connector/memstore"
+	"github.com/connctd/lora-connector/mocks"
+	"github.com/connctd/restapi-go"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReplay fixes the water level of an archived uplink by replaying it after the mounting height
+// has been configured
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	client := &mocks.Client{}
+	properties := &propertyRecorder{values: map[string]string{}}
+	client.On("UpdateInstallationState", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	client.On("UpdateInstanceState", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	client.On("CreateThing", mock.Anything, mock.Anything, mock.Anything).Return(restapi.Thing{ID: "configthing"}, nil).Once()
+	client.On("CreateThing", mock.Anything, mock.Anything, mock.Anything).Return(restapi.Thing{ID: "ldds75thing"}, nil).Once()
+	client.On("UpdateThingPropertyValue", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(properties.record).Return(nil)
+
+	store := memstore.New(client, "lora.example.com")
+	require.NoError(t, store.AddInstallation(ctx, connector.InstallationRequest{
+		ID:            "foo",
+		Token:         "installationtoken",
+		Configuration: []connector.Configuration{{ID: lorawan.ConfigDefaultDecoder, Value: "ldds75"}},
+	}))
+	require.NoError(t, store.AddInstance(ctx, connector.InstantiationRequest{ID: "bar", InstallationID: "foo", Token: "instancetoken"}))
+
+	handler := lorawan.NewLoRaWANHandler(client, true, store)
+	archiverCtx, stopArchiver := context.WithCancel(ctx)
+	archiverStopped := make(chan struct{})
+	go func() {
+		handler.RunArchiver(archiverCtx)
+		close(archiverStopped)
+	}()
+	r := mux.NewRouter()
+	r.Path("/lorawan/{installationId}/{instanceId}").Methods(http.MethodPost).Handler(handler)
+	for _, event := range []string{"up", "unknown"} {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/lorawan/foo/bar?event="+event, bytes.NewBufferString(ldds75.TestPayload))
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t, "-24.900000", properties.get("ldds75thing", "waterlevel", "waterlevel"))
+	stopArchiver()
+	<-archiverStopped
+	archived, err := store.ListArchivedEvents(ctx, lorawan.ArchiveFilter{InstanceID: "bar"})
+	require.NoError(t, err)
+	require.Len(t, archived, 2)
+	receivedAt := archived[0].ReceivedAt
+
+	resp, err := store.PerformAction(ctx, connector.ActionRequest{
+		ID:         "action",
+		ThingID:    "ldds75thing",
+		ActionID:   "setMountingHeight",
+		Parameters: map[string]string{"mountingHeight": "300"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, restapi.ActionRequestStatusCompleted, resp.Status)
+
+	_, err = handler.Replay(ctx, lorawan.ReplayOptions{})
+	assert.ErrorIs(t, err, lorawan.ErrMissingInstance)
+
+	// A dry run only reports the difference
+	pushed := len(client.Calls)
+	report, err := handler.Replay(ctx, lorawan.ReplayOptions{InstanceID: "bar", DryRun: true})
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 1, report.Replayed)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Empty(t, report.Errors)
+	require.Len(t, report.Changes, 1)
+	change := report.Changes[0]
+	assert.Equal(t, "ldds75thing", change.ThingID)
+	assert.Equal(t, "waterlevel", change.PropertyID)
+	require.NotNil(t, change.OldValue)
+	assert.Equal(t, "-24.900000", *change.OldValue)
+	assert.Equal(t, "275.100000", change.NewValue)
+	assert.Equal(t, pushed, len(client.Calls))
+
+	// Uplinks of other applications are not replayed
+	report, err = handler.Replay(ctx, lorawan.ReplayOptions{InstanceID: "bar", ApplicationID: 42, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Replayed)
+
+	report, err = handler.Replay(ctx, lorawan.ReplayOptions{InstanceID: "bar", From: receivedAt.Add(-time.Minute)})
+	require.NoError(t, err)
+	assert.Len(t, report.Changes, 1)
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, "275.100000", properties.get("ldds75thing", "waterlevel", "waterlevel"))
+	client.AssertCalled(t, "UpdateThingPropertyValue", mock.Anything, connector.InstantiationToken("instancetoken"),
+		"ldds75thing", "waterlevel", "waterlevel", "275.100000", mock.MatchedBy(func(updated time.Time) bool {
+			return updated.Equal(receivedAt)
+		}))
+	// Replays don't create things
+	client.AssertNumberOfCalls(t, "CreateThing", 2)
+
+	// The replayed values are the new baseline
+	report, err = handler.Replay(ctx, lorawan.ReplayOptions{InstanceID: "bar", DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Replayed)
+	assert.Empty(t, report.Changes)
+}
+
+func TestReplayPagesThroughArchive(t *testing.T) {
+	ctx := context.Background()
+	client := &mocks.Client{}
+	client.On("UpdateInstallationState", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	client.On("UpdateInstanceState", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	client.On("CreateThing", mock.Anything, mock.Anything, mock.Anything).Return(restapi.Thing{ID: "configthing"}, nil).Once()
+	client.On("UpdateThingPropertyValue", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	store := memstore.New(client, "lora.example.com")
+	require.NoError(t, store.AddInstallation(ctx, connector.InstallationRequest{ID: "foo", Token: "installationtoken"}))
+	require.NoError(t, store.AddInstance(ctx, connector.InstantiationRequest{ID: "bar", InstallationID: "foo", Token: "instancetoken"}))
+	// More than two pages of events, archived newest first
+	now := time.Now()
+	for i := 0; i < 1001; i++ {
+		require.NoError(t, store.ArchiveEvent(ctx, lorawan.ArchivedEvent{
+			InstanceID: "bar",
+			Event:      "status",
+			Encoding:   lorawan.EncodingJSON,
+			ReceivedAt: now.Add(-time.Duration(i) * time.Minute),
+			Outcome:    lorawan.EventOutcomeIgnored,
+		}))
+	}
+
+	report, err := lorawan.NewLoRaWANHandler(client, true, store).Replay(ctx, lorawan.ReplayOptions{InstanceID: "bar", DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Replayed)
+	assert.Equal(t, 1001, report.Skipped)
+}
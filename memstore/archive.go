@@ -0,0 +1,119 @@
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/connctd/lora-connector/storage"
+)
+
+// copyEvent returns a copy of the event, so that callers can't modify the stored body
+func copyEvent(e lorawan.ArchivedEvent) lorawan.ArchivedEvent {
+	e.Body = append([]byte(nil), e.Body...)
+	e.DevEUI = append([]byte(nil), e.DevEUI...)
+	e.Updates = append([]decoder.PropertyUpdate(nil), e.Updates...)
+	return e
+}
+
+func (s *Store) ArchiveEvent(ctx context.Context, event lorawan.ArchivedEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastArchiveID++
+	event = copyEvent(event)
+	event.ID = s.lastArchiveID
+	s.archive = append(s.archive, event)
+	return nil
+}
+
+func (s *Store) SetArchivedUpdates(ctx context.Context, eventID uint64, updates []decoder.PropertyUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.archive {
+		if s.archive[i].ID == eventID {
+			s.archive[i].Updates = append([]decoder.PropertyUpdate(nil), updates...)
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *Store) ListArchivedEvents(ctx context.Context, filter lorawan.ArchiveFilter) ([]lorawan.ArchivedEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := []lorawan.ArchivedEvent{}
+	for _, e := range s.archive {
+		if filter.InstanceID != "" && e.InstanceID != filter.InstanceID {
+			continue
+		}
+		if len(filter.DevEUI) > 0 && !bytes.Equal(e.DevEUI, filter.DevEUI) {
+			continue
+		}
+		if !filter.From.IsZero() && e.ReceivedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !e.ReceivedAt.Before(filter.To) {
+			continue
+		}
+		if len(filter.Outcomes) > 0 && !hasOutcome(filter.Outcomes, e.Outcome) {
+			continue
+		}
+		if e.ID <= filter.AfterID {
+			continue
+		}
+		events = append(events, copyEvent(e))
+	}
+	// Events are appended in the order of their IDs
+	if !filter.ByID {
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].ReceivedAt.Before(events[j].ReceivedAt)
+		})
+	}
+	if filter.Latest {
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+	}
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, nil
+}
+
+func hasOutcome(outcomes []lorawan.EventOutcome, outcome lorawan.EventOutcome) bool {
+	for _, o := range outcomes {
+		if o == outcome {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) PruneArchive(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.filterArchive(func(e lorawan.ArchivedEvent) bool {
+		i, exists := s.instances[e.InstanceID]
+		return exists && !e.ReceivedAt.Before(now.AddDate(0, 0, -i.config.ArchiveRetentionDays))
+	}), nil
+}
+
+// filterArchive keeps the events for which keep returns true and returns the number of dropped events.
+// The caller needs to hold the lock.
+func (s *Store) filterArchive(keep func(e lorawan.ArchivedEvent) bool) int {
+	kept := s.archive[:0]
+	for _, e := range s.archive {
+		if keep(e) {
+			kept = append(kept, e)
+		}
+	}
+	dropped := len(s.archive) - len(kept)
+	for i := len(kept); i < len(s.archive); i++ {
+		s.archive[i] = lorawan.ArchivedEvent{}
+	}
+	s.archive = kept
+	return dropped
+}
@@ -0,0 +1,803 @@
+// Package memstore keeps installations, instances and devices in memory. It is meant for tests and
+// single node demos, everything is lost when the process exits.
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/connclient"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/connctd/lora-connector/monitor"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// DSN selects the in-memory store in the service configuration
+const DSN = "memory://"
+
+// lockTimeout is the maximum time to wait for a named lock
+const lockTimeout = 10 * time.Second
+
+var _ storage.Store = (*Store)(nil)
+
+type installation struct {
+	token         connector.InstallationToken
+	configuration []connector.Configuration
+}
+
+type instance struct {
+	id             string
+	token          connector.InstantiationToken
+	installationID string
+	configThingID  string
+	config         lorawan.InstanceConfig
+	state          connector.InstantiationState
+	createdAt      time.Time
+	lastEventAt    time.Time
+}
+
+func (i *instance) details() storage.Instance {
+	return storage.Instance{
+		ID:             i.id,
+		InstallationID: i.installationID,
+		State:          i.state,
+		CreatedAt:      i.createdAt,
+		LastEventAt:    i.lastEventAt,
+		Config:         i.config,
+	}
+}
+
+type deviceKey struct {
+	instanceID string
+	devEUI     string
+}
+
+type device struct {
+	mapping         lorawan.DeviceMapping
+	ignored         bool
+	lastUplinkAt    time.Time
+	learnedInterval time.Duration
+	offline         bool
+}
+
+func (d *device) details() storage.Device {
+	return storage.Device{
+		DeviceMapping:   copyMapping(d.mapping),
+		Ignored:         d.ignored,
+		Offline:         d.offline,
+		LastUplinkAt:    d.lastUplinkAt,
+		LearnedInterval: d.learnedInterval,
+	}
+}
+
+type decoderConfigKey struct {
+	instanceID string
+	appID      uint64
+}
+
+type stateKey struct {
+	thingID string
+	key     string
+}
+
+// Store is a thread-safe in-memory implementation of storage.Store
+type Store struct {
+	mu             sync.Mutex
+	installations  map[string]*installation
+	instances      map[string]*instance
+	devices        map[deviceKey]*device
+	decoderConfigs map[decoderConfigKey]string
+	state          map[stateKey][]byte
+	// archive holds the archived events in the order they were archived
+	archive       []lorawan.ArchivedEvent
+	lastArchiveID uint64
+
+	locks           *storage.LocalLocks
+	connectorClient connector.Client
+	states          storage.StateReporter
+	host            string
+	logger          logrus.FieldLogger
+}
+
+// New returns an empty store. The host is the public host of the connector.
+func New(connectorClient connector.Client, host string) *Store {
+	logger := logrus.WithField("component", "memstore")
+	return &Store{
+		installations:   make(map[string]*installation),
+		instances:       make(map[string]*instance),
+		devices:         make(map[deviceKey]*device),
+		decoderConfigs:  make(map[decoderConfigKey]string),
+		state:           make(map[stateKey][]byte),
+		locks:           storage.NewLocalLocks(),
+		connectorClient: connectorClient,
+		states:          storage.StateReporter{Client: connectorClient, Logger: logger},
+		host:            host,
+		logger:          logger,
+	}
+}
+
+// CreateOrMigrate does nothing, since there is no schema
+func (s *Store) CreateOrMigrate() error {
+	return nil
+}
+
+func (s *Store) Close() error {
+	return nil
+}
+
+// Ping always succeeds, since the store lives in memory
+func (s *Store) Ping(ctx context.Context) error {
+	return nil
+}
+
+// CheckSchema always succeeds, since there is no schema
+func (s *Store) CheckSchema(ctx context.Context) error {
+	return nil
+}
+
+func newDeviceKey(instanceID string, devEUI []byte) deviceKey {
+	return deviceKey{instanceID: instanceID, devEUI: string(devEUI)}
+}
+
+// copyMapping returns a copy of the mapping, so that callers can't modify the stored EUI
+func copyMapping(m lorawan.DeviceMapping) lorawan.DeviceMapping {
+	m.DevEUI = append([]byte(nil), m.DevEUI...)
+	return m
+}
+
+func (s *Store) AddInstallation(ctx context.Context, req connector.InstallationRequest) (err error) {
+	defer func() {
+		if err != nil {
+			s.states.InstallationFailed(ctx, req.Token, err)
+		} else {
+			s.states.Installation(ctx, req.Token, connector.InstallationStateComplete, nil)
+		}
+	}()
+	if _, err := lorawan.ParseInstanceConfig(req.Configuration, nil); err != nil {
+		return storage.ConfigurationError(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.installations[req.ID]; exists {
+		return fmt.Errorf("installation %s already exists", req.ID)
+	}
+	s.installations[req.ID] = &installation{
+		token:         req.Token,
+		configuration: req.Configuration,
+	}
+	return nil
+}
+
+// AddInstance creates the config thing of a new instance and stores the instance. Like the SQL
+// store, repeated requests for an existing instance only update its token.
+func (s *Store) AddInstance(ctx context.Context, req connector.InstantiationRequest) error {
+	logger := s.logger.WithFields(logrus.Fields{
+		"instanceId":     req.ID,
+		"installationId": req.InstallationID,
+	})
+	unlock, err := s.locks.Lock(ctx, "instance/"+req.ID, lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	s.mu.Lock()
+	existing, exists := s.instances[req.ID]
+	if exists && existing.installationID == req.InstallationID {
+		existing.token = req.Token
+	}
+	installation := s.installations[req.InstallationID]
+	s.mu.Unlock()
+
+	if exists {
+		if existing.installationID != req.InstallationID {
+			return storage.ErrInstanceExists
+		}
+		logger.Info("Instance already exists, skipping provisioning")
+		s.states.Instance(ctx, req.Token, connector.InstantiationStateComplete, nil)
+		return nil
+	}
+	if installation == nil {
+		return storage.ErrNotFound
+	}
+
+	config, err := lorawan.ParseInstanceConfig(installation.configuration, req.Configuration)
+	if err != nil {
+		s.states.InstanceFailed(ctx, req.Token, "parseConfiguration", err)
+		return storage.ConfigurationError(err)
+	}
+
+	s.states.Instance(ctx, req.Token, connector.InstantiationStateOngoing, nil)
+	configThing, err := s.connectorClient.CreateThing(ctx, req.Token, storage.NewConfigThing(config))
+	if err != nil {
+		s.states.InstanceFailed(ctx, req.Token, "createConfigThing", err)
+		return err
+	}
+	callbackURL := storage.CallbackURL(s.host, req.InstallationID, req.ID)
+	err = s.connectorClient.UpdateThingPropertyValue(ctx, req.Token, configThing.ID, storage.ConfigComponentID, "url", callbackURL, time.Now())
+	if err != nil {
+		logger.WithError(err).Error("Failed to add instance, deleting config thing")
+		if err := s.connectorClient.DeleteThing(ctx, req.Token, configThing.ID); err != nil {
+			logger.WithError(err).Error("Failed to delete config thing of failed instance")
+		}
+		s.states.InstanceFailed(ctx, req.Token, "setCallbackUrl", err)
+		return err
+	}
+
+	s.mu.Lock()
+	s.instances[req.ID] = &instance{
+		id:             req.ID,
+		token:          req.Token,
+		installationID: req.InstallationID,
+		configThingID:  configThing.ID,
+		config:         config,
+		state:          connector.InstantiationStateComplete,
+		createdAt:      time.Now(),
+	}
+	s.mu.Unlock()
+
+	s.states.Instance(ctx, req.Token, connector.InstantiationStateComplete, nil)
+	return nil
+}
+
+func (s *Store) PerformAction(ctx context.Context, req connector.ActionRequest) (*connector.ActionResponse, error) {
+	logger := s.logger.WithFields(logrus.Fields{
+		"actionRequestId": req.ID,
+		"thingId":         req.ThingID,
+	})
+	s.mu.Lock()
+	var configInstance *instance
+	for _, i := range s.instances {
+		if i.configThingID == req.ThingID {
+			configInstance = i
+			break
+		}
+	}
+	decoderName, isDevice := "", false
+	for _, d := range s.devices {
+		if d.mapping.ThingID == req.ThingID {
+			decoderName, isDevice = d.mapping.DecoderName, true
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	switch {
+	case configInstance != nil:
+		return s.performConfigThingAction(ctx, configInstance, req, logger)
+	case isDevice:
+		return storage.PerformDecoderAction(ctx, s, decoderName, req, logger)
+	}
+	logger.Error("Thing does not exist, replying with failed action request status")
+	return storage.ActionFailed("thing does not exist"), nil
+}
+
+func (s *Store) performConfigThingAction(ctx context.Context, i *instance, req connector.ActionRequest, logger logrus.FieldLogger) (*connector.ActionResponse, error) {
+	logger = logger.WithFields(logrus.Fields{
+		"actionId":    req.ActionID,
+		"componentId": req.ComponentID,
+	})
+	if req.ComponentID != storage.ConfigComponentID {
+		logger.Error("Invalid component id")
+		return storage.ActionFailed("Invalid action or component ID"), nil
+	}
+	switch req.ActionID {
+	case storage.ActionAddMapping:
+		appID, decoderName, err := storage.ParseAddMapping(req)
+		if err != nil {
+			return storage.ActionFailed(err.Error()), nil
+		}
+		s.mu.Lock()
+		s.decoderConfigs[decoderConfigKey{instanceID: i.id, appID: appID}] = decoderName
+		s.mu.Unlock()
+	case storage.ActionSetProvisioningPolicy:
+		policy, err := storage.ParseSetProvisioningPolicy(req)
+		if err != nil {
+			return storage.ActionFailed(err.Error()), nil
+		}
+		s.mu.Lock()
+		i.config.ProvisioningPolicy = policy
+		token, configThingID := i.token, i.configThingID
+		s.mu.Unlock()
+		err = s.connectorClient.UpdateThingPropertyValue(ctx, token, configThingID, storage.ConfigComponentID, "provisioningPolicy", string(policy), time.Now())
+		if err != nil {
+			logger.WithError(err).Warn("Failed to update provisioning policy property of config thing")
+		}
+	default:
+		logger.Error("Invalid action id")
+		return storage.ActionFailed("Invalid action or component ID"), nil
+	}
+	logger.Info("Config thing action completed successfully")
+	return storage.ActionCompleted(), nil
+}
+
+// RemoveInstallation removes all instances of the installation and the installation itself
+func (s *Store) RemoveInstallation(ctx context.Context, installationID string, dryRun bool) (*storage.RemovalReport, error) {
+	s.mu.Lock()
+	_, exists := s.installations[installationID]
+	var instanceIDs []string
+	for id, i := range s.instances {
+		if i.installationID == installationID {
+			instanceIDs = append(instanceIDs, id)
+		}
+	}
+	s.mu.Unlock()
+	if !exists {
+		return nil, storage.ErrNotFound
+	}
+	sort.Strings(instanceIDs)
+
+	report := &storage.RemovalReport{
+		DryRun:        dryRun,
+		Installations: []string{installationID},
+	}
+	for _, instanceID := range instanceIDs {
+		instanceReport, err := s.RemoveInstance(ctx, instanceID, dryRun)
+		if err != nil {
+			return report, fmt.Errorf("failed to remove instance %s: %w", instanceID, err)
+		}
+		report.Add(instanceReport)
+	}
+	if !dryRun {
+		s.mu.Lock()
+		delete(s.installations, installationID)
+		s.mu.Unlock()
+	}
+	return report, nil
+}
+
+// RemoveInstance deletes all things of the instance in connctd and removes the instance together with
+// its mappings, decoder configs and decoder state
+func (s *Store) RemoveInstance(ctx context.Context, instanceID string, dryRun bool) (*storage.RemovalReport, error) {
+	unlock, err := s.locks.Lock(ctx, "instance/"+instanceID, lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	s.mu.Lock()
+	i, exists := s.instances[instanceID]
+	if !exists {
+		s.mu.Unlock()
+		return nil, storage.ErrNotFound
+	}
+	report := &storage.RemovalReport{
+		DryRun:    dryRun,
+		Instances: []string{instanceID},
+	}
+	things := map[string]bool{}
+	for _, d := range s.devices {
+		if d.mapping.InstanceID == instanceID {
+			report.Things = append(report.Things, d.mapping.ThingID)
+			things[d.mapping.ThingID] = true
+			report.Mappings++
+		}
+	}
+	sort.Strings(report.Things)
+	for k := range s.decoderConfigs {
+		if k.instanceID == instanceID {
+			report.DecoderConfigs++
+		}
+	}
+	for k := range s.state {
+		if things[k.thingID] {
+			report.DecoderStates++
+		}
+	}
+	for _, e := range s.archive {
+		if e.InstanceID == instanceID {
+			report.ArchivedEvents++
+		}
+	}
+	report.Things = append(report.Things, i.configThingID)
+	token := i.token
+	s.mu.Unlock()
+
+	if dryRun {
+		return report, nil
+	}
+	for _, thingID := range report.Things {
+		if err := s.connectorClient.DeleteThing(ctx, token, thingID); err != nil && !connclient.IsThingNotFound(err) {
+			s.logger.WithError(err).WithField("thingId", thingID).Warn("Failed to delete thing in connctd")
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to delete thing %s: %s", thingID, err))
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, d := range s.devices {
+		if d.mapping.InstanceID == instanceID {
+			delete(s.devices, k)
+		}
+	}
+	for k := range s.decoderConfigs {
+		if k.instanceID == instanceID {
+			delete(s.decoderConfigs, k)
+		}
+	}
+	for k := range s.state {
+		if things[k.thingID] {
+			delete(s.state, k)
+		}
+	}
+	s.filterArchive(func(e lorawan.ArchivedEvent) bool {
+		return e.InstanceID != instanceID
+	})
+	delete(s.instances, instanceID)
+	return report, nil
+}
+
+func (s *Store) GetState(ctx context.Context, thingID, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.state[stateKey{thingID: thingID, key: key}]
+	if !ok {
+		return nil, decoder.ErrStateNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (s *Store) SetState(ctx context.Context, thingID, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state[stateKey{thingID: thingID, key: key}] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *Store) ListState(ctx context.Context, thingID string) (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := map[string][]byte{}
+	for key, value := range s.state {
+		if key.thingID == thingID {
+			values[key.key] = append([]byte(nil), value...)
+		}
+	}
+	return values, nil
+}
+
+func (s *Store) TryLeadership(ctx context.Context, name string) (monitor.Leadership, error) {
+	return s.locks.TryLeadership(ctx, name)
+}
+
+func (s *Store) LockDevice(ctx context.Context, instanceID string, devEUI []byte) (func(), error) {
+	return s.locks.Lock(ctx, fmt.Sprintf("device/%s/%x", instanceID, devEUI), lockTimeout)
+}
+
+func (s *Store) GetInstallationToken(ctx context.Context, installationID string) (connector.InstallationToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.installations[installationID]
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+	return i.token, nil
+}
+
+// instance returns the instance or storage.ErrNotFound, the caller needs to hold the lock
+func (s *Store) instance(instanceID string) (*instance, error) {
+	i, ok := s.instances[instanceID]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return i, nil
+}
+
+func (s *Store) GetInstance(ctx context.Context, instanceID string) (connector.InstantiationRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.instance(instanceID)
+	if err != nil {
+		return connector.InstantiationRequest{}, err
+	}
+	return connector.InstantiationRequest{
+		ID:             i.id,
+		Token:          i.token,
+		InstallationID: i.installationID,
+	}, nil
+}
+
+func (s *Store) GetInstanceToken(ctx context.Context, instanceID string) (connector.InstantiationToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.instance(instanceID)
+	if err != nil {
+		return "", err
+	}
+	return i.token, nil
+}
+
+func (s *Store) GetInstanceConfig(ctx context.Context, instanceID string) (lorawan.InstanceConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.instance(instanceID)
+	if err != nil {
+		return lorawan.InstanceConfig{}, err
+	}
+	return i.config, nil
+}
+
+func (s *Store) ProvisioningPolicy(ctx context.Context, instanceID string) (lorawan.ProvisioningPolicy, error) {
+	config, err := s.GetInstanceConfig(ctx, instanceID)
+	return config.ProvisioningPolicy, err
+}
+
+// DecoderNameForApp returns the name of the decoder mapped to the LoRaWAN application or the
+// default decoder of the instance, if the application is not mapped
+func (s *Store) DecoderNameForApp(ctx context.Context, instanceID string, appID uint64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name, ok := s.decoderConfigs[decoderConfigKey{instanceID: instanceID, appID: appID}]; ok {
+		return name, nil
+	}
+	if i, ok := s.instances[instanceID]; ok && i.config.DefaultDecoder != "" {
+		return i.config.DefaultDecoder, nil
+	}
+	return "", storage.ErrNotFound
+}
+
+// GetDeviceMapping returns the mapping of the device or nil if the device is unknown
+func (s *Store) ListApplicationDecoders(ctx context.Context, instanceID string) (map[uint64]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	decoders := map[uint64]string{}
+	for key, name := range s.decoderConfigs {
+		if key.instanceID == instanceID {
+			decoders[key.appID] = name
+		}
+	}
+	return decoders, nil
+}
+
+func (s *Store) GetDeviceMapping(ctx context.Context, instanceID string, devEUI []byte) (*lorawan.DeviceMapping, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[newDeviceKey(instanceID, devEUI)]
+	if !ok {
+		return nil, nil
+	}
+	if d.ignored {
+		return nil, lorawan.ErrDeviceIgnored
+	}
+	m := copyMapping(d.mapping)
+	return &m, nil
+}
+
+func (s *Store) StoreDeviceMapping(ctx context.Context, mapping lorawan.DeviceMapping) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := newDeviceKey(mapping.InstanceID, mapping.DevEUI)
+	if _, exists := s.devices[key]; exists {
+		return fmt.Errorf("device %x is already mapped", mapping.DevEUI)
+	}
+	for _, d := range s.devices {
+		if d.mapping.ThingID == mapping.ThingID {
+			return fmt.Errorf("thing %s is already mapped", mapping.ThingID)
+		}
+	}
+	s.devices[key] = &device{mapping: copyMapping(mapping)}
+	return nil
+}
+
+// SetDeviceDecoder records the decoder and the thing model version of a device mapping created before
+// thing models were versioned
+func (s *Store) SetDeviceDecoder(ctx context.Context, instanceID string, devEUI []byte, decoderName string, modelVersion uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[newDeviceKey(instanceID, devEUI)]; ok {
+		d.mapping.DecoderName = decoderName
+		d.mapping.ModelVersion = modelVersion
+	}
+	return nil
+}
+
+// OutdatedDeviceMappings returns the mappings of all things of the instance created by the given decoder
+// with a thing model older than modelVersion. Ignored devices are skipped.
+func (s *Store) OutdatedDeviceMappings(ctx context.Context, instanceID, decoderName string, modelVersion uint) ([]lorawan.DeviceMapping, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := []lorawan.DeviceMapping{}
+	for _, d := range s.devices {
+		if d.mapping.InstanceID == instanceID && d.mapping.DecoderName == decoderName && d.mapping.ModelVersion < modelVersion && !d.ignored {
+			result = append(result, copyMapping(d.mapping))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ThingID < result[j].ThingID
+	})
+	return result, nil
+}
+
+// ReplaceThingID maps the device to a new thing and carries over the decoder state of the stale thing
+func (s *Store) ReplaceThingID(ctx context.Context, staleThingID string, mapping lorawan.DeviceMapping) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := newDeviceKey(mapping.InstanceID, mapping.DevEUI)
+	if d, ok := s.devices[key]; ok && d.mapping.ThingID == staleThingID {
+		delete(s.devices, key)
+	}
+	if _, exists := s.devices[key]; exists {
+		return fmt.Errorf("device %x is already mapped", mapping.DevEUI)
+	}
+	s.devices[key] = &device{mapping: copyMapping(mapping)}
+	for k, value := range s.state {
+		if k.thingID == staleThingID {
+			delete(s.state, k)
+			s.state[stateKey{thingID: mapping.ThingID, key: k.key}] = value
+		}
+	}
+	return nil
+}
+
+// IgnoreDevice marks the device, so that GetDeviceMapping reports it as ignored
+func (s *Store) IgnoreDevice(ctx context.Context, instanceID string, devEUI []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[newDeviceKey(instanceID, devEUI)]; ok {
+		d.ignored = true
+	}
+	return nil
+}
+
+// SetLastEvent records when the instance received the last event from its network server
+func (s *Store) SetLastEvent(ctx context.Context, instanceID string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.instances[instanceID]; ok {
+		i.lastEventAt = t
+	}
+	return nil
+}
+
+// ListInstanceActivity returns the state and last event time of all instances
+func (s *Store) ListInstanceActivity(ctx context.Context) ([]monitor.InstanceActivity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	activities := make([]monitor.InstanceActivity, 0, len(s.instances))
+	for _, i := range s.instances {
+		activities = append(activities, monitor.InstanceActivity{
+			InstanceID:  i.id,
+			Token:       i.token,
+			State:       i.state,
+			CreatedAt:   i.createdAt,
+			LastEventAt: i.lastEventAt,
+		})
+	}
+	sort.Slice(activities, func(i, j int) bool {
+		return activities[i].InstanceID < activities[j].InstanceID
+	})
+	return activities, nil
+}
+
+func (s *Store) ListInstances(ctx context.Context) ([]storage.Instance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	instances := make([]storage.Instance, 0, len(s.instances))
+	for _, i := range s.instances {
+		instances = append(instances, i.details())
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+	return instances, nil
+}
+
+func (s *Store) GetInstanceDetails(ctx context.Context, instanceID string) (storage.Instance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.instance(instanceID)
+	if err != nil {
+		return storage.Instance{}, err
+	}
+	return i.details(), nil
+}
+
+func (s *Store) ListDevices(ctx context.Context, instanceID string) ([]storage.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.instance(instanceID); err != nil {
+		return nil, err
+	}
+	devices := []storage.Device{}
+	for _, d := range s.devices {
+		if d.mapping.InstanceID != instanceID {
+			continue
+		}
+		devices = append(devices, d.details())
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return string(devices[i].DevEUI) < string(devices[j].DevEUI)
+	})
+	return devices, nil
+}
+
+func (s *Store) GetDevice(ctx context.Context, instanceID string, devEUI []byte) (*storage.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.instance(instanceID); err != nil {
+		return nil, err
+	}
+	d, ok := s.devices[newDeviceKey(instanceID, devEUI)]
+	if !ok {
+		return nil, nil
+	}
+	device := d.details()
+	return &device, nil
+}
+
+// SetInstanceState stores the state which has been reported to connctd
+func (s *Store) SetInstanceState(ctx context.Context, instanceID string, state connector.InstantiationState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.instances[instanceID]; ok {
+		i.state = state
+	}
+	return nil
+}
+
+// RecordUplink stores the time of an uplink of the device and learns its reporting interval.
+// It reports whether the device is marked as offline, which is kept until SetDeviceOnline.
+func (s *Store) RecordUplink(ctx context.Context, instanceID string, devEUI []byte, t time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[newDeviceKey(instanceID, devEUI)]
+	if !ok {
+		return false, storage.ErrNotFound
+	}
+	if !d.lastUplinkAt.IsZero() {
+		d.learnedInterval = monitor.LearnInterval(d.learnedInterval, t.Sub(d.lastUplinkAt))
+	}
+	d.lastUplinkAt = t
+	return d.offline, nil
+}
+
+// ListDeviceActivity returns the last uplink of all devices which are neither offline nor ignored
+func (s *Store) ListDeviceActivity(ctx context.Context) ([]monitor.DeviceActivity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	activities := []monitor.DeviceActivity{}
+	for _, d := range s.devices {
+		i, ok := s.instances[d.mapping.InstanceID]
+		if d.offline || d.ignored || d.lastUplinkAt.IsZero() || !ok {
+			continue
+		}
+		activities = append(activities, monitor.DeviceActivity{
+			InstanceID:      d.mapping.InstanceID,
+			Token:           i.token,
+			DevEUI:          append([]byte(nil), d.mapping.DevEUI...),
+			ThingID:         d.mapping.ThingID,
+			DecoderName:     d.mapping.DecoderName,
+			LastUplinkAt:    d.lastUplinkAt,
+			LearnedInterval: d.learnedInterval,
+		})
+	}
+	sort.Slice(activities, func(i, j int) bool {
+		return activities[i].ThingID < activities[j].ThingID
+	})
+	return activities, nil
+}
+
+// SetDeviceOffline marks the device as offline until SetDeviceOnline
+func (s *Store) SetDeviceOffline(ctx context.Context, instanceID string, devEUI []byte) error {
+	return s.setOffline(instanceID, devEUI, true)
+}
+
+// SetDeviceOnline clears the offline mark, after the thing has been marked as available again
+func (s *Store) SetDeviceOnline(ctx context.Context, instanceID string, devEUI []byte) error {
+	return s.setOffline(instanceID, devEUI, false)
+}
+
+func (s *Store) setOffline(instanceID string, devEUI []byte, offline bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[newDeviceKey(instanceID, devEUI)]; ok {
+		d.offline = offline
+	}
+	return nil
+}
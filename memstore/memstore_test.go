@@ -0,0 +1,15 @@
+package memstore
+
+import (
+	"testing"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/connctd/lora-connector/storage/storagetest"
+)
+
+func TestStore(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, client connector.Client) storage.Store {
+		return New(client, storagetest.Host)
+	})
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/restapi-go"
+)
+
+// instrumentedClient observes the latency and errors of all requests to the connctd API
+type instrumentedClient struct {
+	client connector.Client
+}
+
+// InstrumentClient returns a client which records ConnctdRequestDuration for all requests
+func InstrumentClient(client connector.Client) connector.Client {
+	return &instrumentedClient{client: client}
+}
+
+func observe(method string, start time.Time, err error) {
+	ConnctdRequestDuration.WithLabelValues(method, Result(err)).Observe(time.Since(start).Seconds())
+}
+
+func (c *instrumentedClient) CreateThing(ctx context.Context, token connector.InstantiationToken, thing restapi.Thing) (result restapi.Thing, err error) {
+	defer func(start time.Time) { observe("CreateThing", start, err) }(time.Now())
+	return c.client.CreateThing(ctx, token, thing)
+}
+
+func (c *instrumentedClient) UpdateThingPropertyValue(ctx context.Context, token connector.InstantiationToken, thingID string, componentID string, propertyID string, value string, lastUpdate time.Time) (err error) {
+	defer func(start time.Time) { observe("UpdateThingPropertyValue", start, err) }(time.Now())
+	return c.client.UpdateThingPropertyValue(ctx, token, thingID, componentID, propertyID, value, lastUpdate)
+}
+
+func (c *instrumentedClient) UpdateThingStatus(ctx context.Context, token connector.InstantiationToken, thingID string, status restapi.StatusType) (err error) {
+	defer func(start time.Time) { observe("UpdateThingStatus", start, err) }(time.Now())
+	return c.client.UpdateThingStatus(ctx, token, thingID, status)
+}
+
+func (c *instrumentedClient) UpdateActionStatus(ctx context.Context, token connector.InstantiationToken, actionRequestID string, status restapi.ActionRequestStatus, e string) (err error) {
+	defer func(start time.Time) { observe("UpdateActionStatus", start, err) }(time.Now())
+	return c.client.UpdateActionStatus(ctx, token, actionRequestID, status, e)
+}
+
+func (c *instrumentedClient) UpdateInstallationState(ctx context.Context, token connector.InstallationToken, state connector.InstallationState, details json.RawMessage) (err error) {
+	defer func(start time.Time) { observe("UpdateInstallationState", start, err) }(time.Now())
+	return c.client.UpdateInstallationState(ctx, token, state, details)
+}
+
+func (c *instrumentedClient) UpdateInstanceState(ctx context.Context, token connector.InstantiationToken, state connector.InstantiationState, details json.RawMessage) (err error) {
+	defer func(start time.Time) { observe("UpdateInstanceState", start, err) }(time.Now())
+	return c.client.UpdateInstanceState(ctx, token, state, details)
+}
+
+func (c *instrumentedClient) DeleteThing(ctx context.Context, token connector.InstantiationToken, thingID string) (err error) {
+	defer func(start time.Time) { observe("DeleteThing", start, err) }(time.Now())
+	return c.client.DeleteThing(ctx, token, thingID)
+}
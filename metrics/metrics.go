@@ -0,0 +1,155 @@
+// Package metrics defines the Prometheus metrics of the connector. Labels are limited to values with
+// a small, bounded number of values like instance IDs, decoder names and event types. DevEUIs only
+// become labels if device metrics are enabled explicitly.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "loraconn"
+
+// Registry holds all metrics of the connector together with the Go runtime and process metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	// EventsReceived counts the events received from network servers by instance, event type and outcome
+	EventsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_received_total",
+		Help:      "Events received from network servers by instance, event type and outcome.",
+	}, []string{"instance", "event", "outcome"})
+
+	// DecodeFailures counts uplinks the payload decoder failed to decode
+	DecodeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decode_failures_total",
+		Help:      "Uplinks which could not be decoded by decoder.",
+	}, []string{"decoder"})
+
+	// ThingsCreated counts the things created for devices in connctd
+	ThingsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "things_created_total",
+		Help:      "Things created in connctd for devices by decoder.",
+	}, []string{"decoder"})
+
+	// ConnctdRequestDuration observes the latency of requests to the connctd API by method and result
+	ConnctdRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "connctd_request_duration_seconds",
+		Help:      "Latency of requests to the connctd API by client method and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "result"})
+
+	// DBQueryDuration observes the latency of database queries by operation and table
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of database queries by operation, table and result.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table", "result"})
+
+	// ActionRequests counts the action requests of connctd by action ID and resulting status
+	ActionRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "action_requests_total",
+		Help:      "Action requests received from connctd by action and resulting status.",
+	}, []string{"action", "status"})
+
+	// UplinksInFlight is the number of events which are currently processed
+	UplinksInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "events_in_flight",
+		Help:      "Events from network servers which are currently processed.",
+	})
+
+	// DeviceLockWaiters is the number of requests waiting for the provisioning lock of a device
+	DeviceLockWaiters = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "device_lock_waiters",
+		Help:      "Requests waiting for the provisioning lock of a device.",
+	})
+
+	// ArchiveQueueLength is the number of events waiting to be archived
+	ArchiveQueueLength = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "archive_queue_length",
+		Help:      "Events waiting to be written to the event archive.",
+	})
+	// ArchiveDropped counts events which were not archived because the archive queue was full
+	ArchiveDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "archive_dropped_total",
+		Help:      "Events not archived because the archive queue was full.",
+	})
+	deviceUplinks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "device_uplinks_total",
+		Help:      "Uplinks received by instance and device. Only collected if device metrics are enabled.",
+	}, []string{"instance", "dev_eui"})
+	deviceMetrics    bool
+	enableDeviceOnce sync.Once
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		EventsReceived,
+		DecodeFailures,
+		ThingsCreated,
+		ConnctdRequestDuration,
+		DBQueryDuration,
+		ActionRequests,
+		UplinksInFlight,
+		DeviceLockWaiters,
+		ArchiveQueueLength,
+		ArchiveDropped,
+	)
+}
+
+// Handler serves the metrics of the registry
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// EnableDeviceMetrics collects uplinks per device. Every device adds a time series, so this should
+// only be enabled for small deployments or while debugging. It needs to be called before events
+// are handled.
+func EnableDeviceMetrics() {
+	enableDeviceOnce.Do(func() {
+		Registry.MustRegister(deviceUplinks)
+		deviceMetrics = true
+	})
+}
+
+// DeviceUplink counts an uplink of the device, if device metrics are enabled
+func DeviceUplink(instanceID, devEUI string) {
+	if deviceMetrics {
+		deviceUplinks.WithLabelValues(instanceID, devEUI).Inc()
+	}
+}
+
+// EventType returns the event label for an event type. Event types are set by the network server in
+// the query, unknown types are reported as other so that they can't add arbitrary labels.
+func EventType(event string) string {
+	switch event {
+	case "up", "status", "join", "ack", "txack", "error", "location", "integration":
+		return event
+	}
+	return "other"
+}
+
+// Result returns the result label for an error
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
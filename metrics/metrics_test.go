@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/mocks"
+	"github.com/connctd/restapi-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestEventType(t *testing.T) {
+	assert.Equal(t, "up", EventType("up"))
+	assert.Equal(t, "txack", EventType("txack"))
+	assert.Equal(t, "other", EventType(""))
+	assert.Equal(t, "other", EventType("a840414d6182e088"))
+}
+
+func TestInstrumentClient(t *testing.T) {
+	ConnctdRequestDuration.Reset()
+	client := new(mocks.Client)
+	client.On("UpdateThingStatus", mock.Anything, connector.InstantiationToken("token"), "thing", restapi.StatusTypeAvailable).Return(nil)
+	client.On("DeleteThing", mock.Anything, connector.InstantiationToken("token"), "thing").Return(errors.New("unavailable"))
+
+	instrumented := InstrumentClient(client)
+	assert.NoError(t, instrumented.UpdateThingStatus(context.Background(), "token", "thing", restapi.StatusTypeAvailable))
+	assert.Error(t, instrumented.DeleteThing(context.Background(), "token", "thing"))
+	assert.Error(t, instrumented.DeleteThing(context.Background(), "token", "thing"))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(ConnctdRequestDuration))
+	client.AssertExpectations(t)
+}
+
+func TestDeviceUplink(t *testing.T) {
+	DeviceUplink("bar", "a840414d6182e088")
+	assert.Equal(t, 0, testutil.CollectAndCount(deviceUplinks))
+
+	EnableDeviceMetrics()
+	EnableDeviceMetrics()
+	DeviceUplink("bar", "a840414d6182e088")
+	DeviceUplink("bar", "a840414d6182e088")
+	assert.Equal(t, float64(2), testutil.ToFloat64(deviceUplinks.WithLabelValues("bar", "a840414d6182e088")))
+}
@@ -0,0 +1,93 @@
+// Package monitor contains background jobs watching instances and devices
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/sirupsen/logrus"
+)
+
+// InstanceActivity describes when an instance received the last event from its network server
+type InstanceActivity struct {
+	InstanceID string
+	Token      connector.InstantiationToken
+	State      connector.InstantiationState
+	CreatedAt  time.Time
+	// LastEventAt is zero if the instance never received an event
+	LastEventAt time.Time
+}
+
+type instanceStore interface {
+	ListInstanceActivity(ctx context.Context) ([]InstanceActivity, error)
+	SetInstanceState(ctx context.Context, instanceID string, state connector.InstantiationState) error
+}
+
+// IntegrationMonitor reports instances as failed to connctd when their network server integration
+// did not send any events for longer than the timeout. Once events arrive again, the instance is
+// reported as complete.
+type IntegrationMonitor struct {
+	store           instanceStore
+	connectorClient connector.Client
+	timeout         time.Duration
+	logger          logrus.FieldLogger
+}
+
+func NewIntegrationMonitor(store instanceStore, connectorClient connector.Client, timeout time.Duration) *IntegrationMonitor {
+	return &IntegrationMonitor{
+		store:           store,
+		connectorClient: connectorClient,
+		timeout:         timeout,
+		logger:          logrus.WithField("component", "integrationmonitor"),
+	}
+}
+
+// Check compares the last event of each instance against the timeout and updates the instance state
+func (m *IntegrationMonitor) Check(ctx context.Context) error {
+	activities, err := m.store.ListInstanceActivity(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, activity := range activities {
+		lastActivity := activity.LastEventAt
+		if lastActivity.IsZero() {
+			// Give new instances time to set up their integration
+			lastActivity = activity.CreatedAt
+		}
+		silent := now.Sub(lastActivity) > m.timeout
+
+		var state connector.InstantiationState
+		var details json.RawMessage
+		switch {
+		case silent && activity.State != connector.InstantiationStateFailed:
+			state = connector.InstantiationStateFailed
+			details, _ = json.Marshal(map[string]interface{}{
+				"reason":       "network server integration did not send any events",
+				"lastActivity": lastActivity,
+			})
+		case !silent && activity.State == connector.InstantiationStateFailed:
+			state = connector.InstantiationStateComplete
+		default:
+			continue
+		}
+
+		logger := m.logger.WithFields(logrus.Fields{
+			"instanceId":   activity.InstanceID,
+			"lastActivity": lastActivity,
+			"state":        state,
+		})
+		if err := m.connectorClient.UpdateInstanceState(ctx, activity.Token, state, details); err != nil {
+			logger.WithError(err).Error("Failed to update instance state")
+			continue
+		}
+		if err := m.store.SetInstanceState(ctx, activity.InstanceID, state); err != nil {
+			logger.WithError(err).Error("Failed to store instance state")
+			continue
+		}
+		logger.Info("Updated instance state based on integration activity")
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestIntegrationMonitor(t *testing.T) {
+	store := new(mockInstanceStore)
+	connectorClient := new(mocks.Client)
+	m := NewIntegrationMonitor(store, connectorClient, time.Hour)
+
+	now := time.Now()
+	store.On("ListInstanceActivity", mock.Anything).Return([]InstanceActivity{
+		// active instance, nothing to do
+		{InstanceID: "active", Token: "a", State: connector.InstantiationStateComplete, CreatedAt: now.Add(-48 * time.Hour), LastEventAt: now.Add(-time.Minute)},
+		// new instance without events, still in grace period
+		{InstanceID: "new", Token: "b", State: connector.InstantiationStateComplete, CreatedAt: now.Add(-time.Minute)},
+		// silent instance
+		{InstanceID: "silent", Token: "c", State: connector.InstantiationStateComplete, CreatedAt: now.Add(-48 * time.Hour), LastEventAt: now.Add(-2 * time.Hour)},
+		// failed instance which received events again
+		{InstanceID: "recovered", Token: "d", State: connector.InstantiationStateFailed, CreatedAt: now.Add(-48 * time.Hour), LastEventAt: now.Add(-time.Minute)},
+	}, nil)
+
+	connectorClient.On("UpdateInstanceState", mock.Anything, connector.InstantiationToken("c"), connector.InstantiationStateFailed, mock.Anything).Return(nil)
+	connectorClient.On("UpdateInstanceState", mock.Anything, connector.InstantiationToken("d"), connector.InstantiationStateComplete, mock.Anything).Return(nil)
+	store.On("SetInstanceState", mock.Anything, "silent", connector.InstantiationStateFailed).Return(nil)
+	store.On("SetInstanceState", mock.Anything, "recovered", connector.InstantiationStateComplete).Return(nil)
+
+	assert.NoError(t, m.Check(context.Background()))
+
+	connectorClient.AssertExpectations(t)
+	store.AssertExpectations(t)
+}
@@ -0,0 +1,66 @@
+package monitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Leadership is held by the replica which is allowed to run a job
+type Leadership interface {
+	// Held reports whether the leadership is still valid
+	Held(ctx context.Context) bool
+	Release()
+}
+
+// Elector elects a single leader for a named job among all replicas of the connector
+type Elector interface {
+	// TryLeadership returns nil if another replica is the leader
+	TryLeadership(ctx context.Context, name string) (Leadership, error)
+}
+
+// RunAsLeader calls job every interval until the context is canceled. If an elector is given, the
+// job only runs on the replica which is the leader for the named job. Replicas which are not the
+// leader try to take over the leadership every interval.
+func RunAsLeader(ctx context.Context, elector Elector, name string, interval time.Duration, job func(ctx context.Context) error) {
+	logger := logrus.WithFields(logrus.Fields{
+		"component": "monitor",
+		"job":       name,
+	})
+	var leadership Leadership
+	defer func() {
+		if leadership != nil {
+			leadership.Release()
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if elector != nil && leadership != nil && !leadership.Held(ctx) {
+			logger.Warn("Lost leadership")
+			leadership.Release()
+			leadership = nil
+		}
+		if elector != nil && leadership == nil {
+			var err error
+			leadership, err = elector.TryLeadership(ctx, name)
+			if err != nil {
+				logger.WithError(err).Error("Failed to elect leader")
+			} else if leadership != nil {
+				logger.Info("Became leader")
+			}
+		}
+		if elector == nil || leadership != nil {
+			if err := job(ctx); err != nil {
+				logger.WithError(err).Error("Job failed")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package monitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/connctd/restapi-go"
+	"github.com/sirupsen/logrus"
+)
+
+// MinReportingInterval is the shortest interval between two uplinks which is considered for learning
+// the reporting interval of a device. Shorter intervals are usually caused by retransmissions.
+const MinReportingInterval = 30 * time.Second
+
+// maxIntervalGrowth caps observed intervals at this multiple of the learned interval
+const maxIntervalGrowth = 2
+
+// LearnInterval updates the learned reporting interval of a device with a newly observed interval.
+// Recent observations are weighted with a quarter, so that single late or missed uplinks don't
+// change the expected interval too much. Observations are capped at maxIntervalGrowth times the learned
+// interval, so that the gap spanning an outage can't inflate the interval and blind the offline detection.
+// Devices reconfigured to report less often are still learned within a few uplinks.
+func LearnInterval(learned, observed time.Duration) time.Duration {
+	if observed < MinReportingInterval {
+		return learned
+	}
+	if learned == 0 {
+		return observed
+	}
+	if observed > maxIntervalGrowth*learned {
+		observed = maxIntervalGrowth * learned
+	}
+	return (3*learned + observed) / 4
+}
+
+// DeviceActivity describes when a device sent its last uplink
+type DeviceActivity struct {
+	InstanceID      string
+	Token           connector.InstantiationToken
+	DevEUI          []byte
+	ThingID         string
+	DecoderName     string
+	LastUplinkAt    time.Time
+	LearnedInterval time.Duration
+}
+
+// ExpectedInterval returns the learned reporting interval of the device or the interval declared
+// by its decoder if nothing has been learned yet. It is zero if neither is known.
+func (a DeviceActivity) ExpectedInterval() time.Duration {
+	if a.LearnedInterval > 0 {
+		return a.LearnedInterval
+	}
+	if d := decoder.GetDecoder(a.DecoderName); d != nil {
+		return decoder.ReportingInterval(d)
+	}
+	return 0
+}
+
+type deviceStore interface {
+	// ListDeviceActivity returns all devices which are online and not ignored
+	ListDeviceActivity(ctx context.Context) ([]DeviceActivity, error)
+	SetDeviceOffline(ctx context.Context, instanceID string, devEUI []byte) error
+}
+
+// OfflineDetector marks things as unavailable in connctd if their device missed several uplinks.
+// Things are marked as available again by the uplink handler on the next uplink.
+type OfflineDetector struct {
+	store           deviceStore
+	connectorClient connector.Client
+	missedIntervals int
+	logger          logrus.FieldLogger
+}
+
+func NewOfflineDetector(store deviceStore, connectorClient connector.Client, missedIntervals int) *OfflineDetector {
+	return &OfflineDetector{
+		store:           store,
+		connectorClient: connectorClient,
+		missedIntervals: missedIntervals,
+		logger:          logrus.WithField("component", "offlinedetector"),
+	}
+}
+
+// Check marks all devices as offline which missed more than the configured number of uplinks
+func (o *OfflineDetector) Check(ctx context.Context) error {
+	activities, err := o.store.ListDeviceActivity(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, activity := range activities {
+		interval := activity.ExpectedInterval()
+		if interval == 0 || now.Sub(activity.LastUplinkAt) <= time.Duration(o.missedIntervals)*interval {
+			continue
+		}
+		logger := o.logger.WithFields(logrus.Fields{
+			"instanceId":       activity.InstanceID,
+			"thingId":          activity.ThingID,
+			"lastUplinkAt":     activity.LastUplinkAt,
+			"expectedInterval": interval,
+		})
+		if err := o.connectorClient.UpdateThingStatus(ctx, activity.Token, activity.ThingID, restapi.StatusTypeUnavailable); err != nil {
+			logger.WithError(err).Error("Failed to mark thing as unavailable")
+			continue
+		}
+		if err := o.store.SetDeviceOffline(ctx, activity.InstanceID, activity.DevEUI); err != nil {
+			logger.WithError(err).Error("Failed to store offline state of device")
+			continue
+		}
+		logger.Info("Device stopped reporting, marked thing as unavailable")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/connctd/connector-go"
+	_ "github.com/connctd/lora-connector/lorawan/decoder/ldds75"
+	"github.com/connctd/lora-connector/mocks"
+	"github.com/connctd/restapi-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestLearnInterval(t *testing.T) {
+	assert.Equal(t, 10*time.Minute, LearnInterval(0, 10*time.Minute))
+	assert.Equal(t, 10*time.Minute, LearnInterval(10*time.Minute, time.Second))
+	assert.Equal(t, 12*time.Minute+30*time.Second, LearnInterval(10*time.Minute, 20*time.Minute))
+	// A day long outage grows the interval no more than a gap of two intervals
+	assert.Equal(t, 12*time.Minute+30*time.Second, LearnInterval(10*time.Minute, 24*time.Hour))
+}
+
+func TestOfflineDetector(t *testing.T) {
+	store := new(mockDeviceStore)
+	connectorClient := new(mocks.Client)
+	o := NewOfflineDetector(store, connectorClient, 3)
+
+	now := time.Now()
+	store.On("ListDeviceActivity", mock.Anything).Return([]DeviceActivity{
+		// reporting as expected
+		{InstanceID: "i", Token: "t", DevEUI: []byte{1}, ThingID: "active", LastUplinkAt: now.Add(-20 * time.Minute), LearnedInterval: 10 * time.Minute},
+		// missed three learned intervals
+		{InstanceID: "i", Token: "t", DevEUI: []byte{2}, ThingID: "silent", LastUplinkAt: now.Add(-31 * time.Minute), LearnedInterval: 10 * time.Minute},
+		// no learned interval, falls back to the interval of the decoder
+		{InstanceID: "i", Token: "t", DevEUI: []byte{3}, ThingID: "ldds75", DecoderName: "ldds75", LastUplinkAt: now.Add(-61 * time.Minute)},
+		// interval unknown
+		{InstanceID: "i", Token: "t", DevEUI: []byte{4}, ThingID: "unknown", DecoderName: "dcl571", LastUplinkAt: now.Add(-24 * time.Hour)},
+	}, nil)
+
+	for _, thingID := range []string{"silent", "ldds75"} {
+		connectorClient.On("UpdateThingStatus", mock.Anything, connector.InstantiationToken("t"), thingID, restapi.StatusTypeUnavailable).Return(nil).Once()
+	}
+	store.On("SetDeviceOffline", mock.Anything, "i", []byte{2}).Return(nil)
+	store.On("SetDeviceOffline", mock.Anything, "i", []byte{3}).Return(nil)
+
+	assert.NoError(t, o.Check(context.Background()))
+
+	connectorClient.AssertExpectations(t)
+	store.AssertExpectations(t)
+}
@@ -0,0 +1,168 @@
+// Package secrets encrypts secrets such as API tokens before they are stored.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// prefix marks encrypted values, values without prefix have been stored before encryption was enabled
+const prefix = "aead:"
+
+// KeySize is the size of keys in bytes, keys are used for AES-256-GCM
+const KeySize = 32
+
+var (
+	// ErrUnknownKey is returned if a value has been encrypted with a key which is not part of the keyring
+	ErrUnknownKey = errors.New("value is encrypted with an unknown key")
+	// ErrNoKeys is returned when decrypting an encrypted value without a keyring
+	ErrNoKeys = errors.New("value is encrypted, but no keys are configured")
+)
+
+// Keyring encrypts values with the primary key and decrypts values encrypted with any of its keys.
+// Encrypted values carry the ID of their key, so keys can be rotated by adding a new primary key
+// and re-encrypting all values.
+//
+// A nil Keyring stores values as plain text.
+type Keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring from the given keys, the first key is the primary key
+func NewKeyring(ids []string, keys [][]byte) (*Keyring, error) {
+	if len(ids) == 0 || len(ids) != len(keys) {
+		return nil, errors.New("keyring needs at least one key and an ID for every key")
+	}
+	k := &Keyring{
+		primary: ids[0],
+		aeads:   map[string]cipher.AEAD{},
+	}
+	for i, id := range ids {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key ID %q", id)
+		}
+		if _, exists := k.aeads[id]; exists {
+			return nil, fmt.Errorf("duplicate key ID %s", id)
+		}
+		if len(keys[i]) != KeySize {
+			return nil, fmt.Errorf("key %s has %d bytes, expected %d", id, len(keys[i]), KeySize)
+		}
+		block, err := aes.NewCipher(keys[i])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.aeads[id] = aead
+	}
+	return k, nil
+}
+
+// ParseKeyring parses a comma separated list of keys in the form id:base64key. The first key is the
+// primary key. An empty list returns a nil Keyring.
+func ParseKeyring(s string) (*Keyring, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var ids []string
+	var keys [][]byte
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		i := strings.Index(entry, ":")
+		if i < 0 {
+			return nil, errors.New("keys have to be given as id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(entry[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %s: %w", entry[:i], err)
+		}
+		ids = append(ids, entry[:i])
+		keys = append(keys, key)
+	}
+	return NewKeyring(ids, keys)
+}
+
+// PrimaryKeyID returns the ID of the key used for encryption
+func (k *Keyring) PrimaryKeyID() string {
+	if k == nil {
+		return ""
+	}
+	return k.primary
+}
+
+// Encrypt encrypts the value with the primary key. The additional data binds the encrypted value to
+// where it is stored, so that it can't be copied elsewhere, and is required for decryption.
+func (k *Keyring) Encrypt(value, additionalData string) (string, error) {
+	if k == nil || value == "" {
+		return value, nil
+	}
+	aead := k.aeads[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(additionalData))
+	return prefix + k.primary + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value returned by Encrypt. Values which are not encrypted are returned as they are.
+func (k *Keyring) Decrypt(value, additionalData string) (string, error) {
+	keyID, sealed, encrypted, err := split(value)
+	if err != nil || !encrypted {
+		return value, err
+	}
+	if k == nil {
+		return "", ErrNoKeys
+	}
+	aead, ok := k.aeads[keyID]
+	if !ok {
+		return "", fmt.Errorf("%w %s", ErrUnknownKey, keyID)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(additionalData))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value with key %s: %w", keyID, err)
+	}
+	return string(plain), nil
+}
+
+// NeedsReencryption reports whether the value is stored as plain text or encrypted with another key than the primary key
+func (k *Keyring) NeedsReencryption(value string) bool {
+	if value == "" {
+		return false
+	}
+	keyID, _, encrypted, err := split(value)
+	if err != nil {
+		return false
+	}
+	if k == nil {
+		return encrypted
+	}
+	return !encrypted || keyID != k.primary
+}
+
+func split(value string) (keyID string, sealed []byte, encrypted bool, err error) {
+	if !strings.HasPrefix(value, prefix) {
+		return "", nil, false, nil
+	}
+	rest := value[len(prefix):]
+	i := strings.Index(rest, ":")
+	if i < 0 {
+		return "", nil, true, errors.New("encrypted value has no key ID")
+	}
+	sealed, err = base64.RawStdEncoding.DecodeString(rest[i+1:])
+	if err != nil {
+		return "", nil, true, fmt.Errorf("failed to decode encrypted value: %w", err)
+	}
+	return rest[:i], sealed, true, nil
+}
@@ -0,0 +1,90 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func key(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	k, err := NewKeyring([]string{"k1"}, [][]byte{key(1)})
+	require.NoError(t, err)
+
+	sealed, err := k.Encrypt("token", "instances.token/i1")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sealed, "aead:k1:"))
+	assert.NotContains(t, sealed, "token")
+	assert.False(t, k.NeedsReencryption(sealed))
+
+	plain, err := k.Decrypt(sealed, "instances.token/i1")
+	require.NoError(t, err)
+	assert.Equal(t, "token", plain)
+
+	// The value can't be moved to another row
+	_, err = k.Decrypt(sealed, "instances.token/i2")
+	assert.Error(t, err)
+
+	// Values stored before encryption was enabled are returned as they are
+	plain, err = k.Decrypt("legacy", "instances.token/i1")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", plain)
+	assert.True(t, k.NeedsReencryption("legacy"))
+	assert.False(t, k.NeedsReencryption(""))
+}
+
+func TestRotation(t *testing.T) {
+	old, err := NewKeyring([]string{"k1"}, [][]byte{key(1)})
+	require.NoError(t, err)
+	sealed, err := old.Encrypt("token", "ad")
+	require.NoError(t, err)
+
+	rotated, err := ParseKeyring("k2:" + base64.StdEncoding.EncodeToString(key(2)) + ", k1:" + base64.StdEncoding.EncodeToString(key(1)))
+	require.NoError(t, err)
+	assert.Equal(t, "k2", rotated.PrimaryKeyID())
+	assert.True(t, rotated.NeedsReencryption(sealed))
+	plain, err := rotated.Decrypt(sealed, "ad")
+	require.NoError(t, err)
+	assert.Equal(t, "token", plain)
+
+	resealed, err := rotated.Encrypt(plain, "ad")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resealed, "aead:k2:"))
+
+	_, err = old.Decrypt(resealed, "ad")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	var none *Keyring
+	_, err = none.Decrypt(resealed, "ad")
+	assert.ErrorIs(t, err, ErrNoKeys)
+}
+
+func TestNilKeyring(t *testing.T) {
+	var k *Keyring
+	sealed, err := k.Encrypt("token", "ad")
+	require.NoError(t, err)
+	assert.Equal(t, "token", sealed)
+	assert.False(t, k.NeedsReencryption("token"))
+}
+
+func TestParseKeyring(t *testing.T) {
+	k, err := ParseKeyring("")
+	require.NoError(t, err)
+	assert.Nil(t, k)
+
+	for _, invalid := range []string{
+		"nokeyid",
+		"k1:notbase64!",
+		"k1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"k1:" + base64.StdEncoding.EncodeToString(key(1)) + ",k1:" + base64.StdEncoding.EncodeToString(key(2)),
+	} {
+		_, err := ParseKeyring(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/connclient"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/secrets"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const adminUsage = "admin instances | devices instance | state instance deveui | migrate-things instance..."
+
+// runAdmin runs the admin subcommand, which prints what the admin API serves directly from the
+// database. It doesn't migrate the database, so that inspecting it has no side effects.
+// migrate-things is the only action which changes instances.
+func runAdmin(logger logrus.FieldLogger, cfg *config, args []string) {
+	if len(args) == 0 {
+		logger.Fatal(adminUsage)
+	}
+	if args[0] == "migrate-things" && len(args) >= 2 {
+		runMigrateThings(logger, cfg, args[1:])
+		return
+	}
+	db := openAdminStore(logger, cfg.dsn, nil, cfg.keyring)
+	defer db.Close()
+	ctx := context.Background()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	switch {
+	case args[0] == "instances" && len(args) == 1:
+		instances, err := db.ListInstances(ctx)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to list instances")
+		}
+		fmt.Fprintln(w, "ID\tINSTALLATION\tSTATE\tCREATED\tLAST EVENT")
+		for _, i := range instances {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", i.ID, i.InstallationID, instanceState(i.State), formatTime(i.CreatedAt), formatTime(i.LastEventAt))
+		}
+	case args[0] == "devices" && len(args) == 2:
+		devices, err := db.ListDevices(ctx, args[1])
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to list devices")
+		}
+		fmt.Fprintln(w, "DEVEUI\tTHING\tDECODER\tMODEL VERSION\tLAST UPLINK\tOFFLINE\tIGNORED")
+		for _, d := range devices {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", hex.EncodeToString(d.DevEUI), d.ThingID, d.DecoderName, d.ModelVersion,
+				formatTime(d.LastUplinkAt), yesNo(d.Offline), yesNo(d.Ignored))
+		}
+	case args[0] == "state" && len(args) == 3:
+		devEUI, err := lorawan.ParseEUI(args[2])
+		if err != nil {
+			logger.WithError(err).Fatal("Invalid device")
+		}
+		mapping, err := db.GetDeviceMapping(ctx, args[1], devEUI)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to read device")
+		}
+		if mapping == nil {
+			logger.Fatal("Device has no thing")
+		}
+		state, err := db.ListState(ctx, mapping.ThingID)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to read decoder state")
+		}
+		keys := make([]string, 0, len(state))
+		for key := range state {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Fprintln(w, "KEY\tVALUE")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s\t%s\n", key, state[key])
+		}
+	default:
+		logger.Fatal(adminUsage)
+	}
+}
+
+// runMigrateThings replaces the things of the instances by things of the current thing model of
+// their decoder. This changes the IDs of the replaced things.
+func runMigrateThings(logger logrus.FieldLogger, cfg *config, instanceIDs []string) {
+	apiClient, err := connector.NewClient(connclient.Options(), connector.DefaultLogger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to setup connctd client")
+	}
+	db := openAdminStore(logger, cfg.dsn, apiClient, cfg.keyring)
+	defer db.Close()
+
+	handler := lorawan.NewLoRaWANHandler(apiClient, true, db)
+	for _, instanceID := range instanceIDs {
+		migrateInstanceThings(context.Background(), logger, handler, instanceID)
+	}
+}
+
+// openAdminStore opens the store and refuses databases whose schema differs from this binary
+func openAdminStore(logger logrus.FieldLogger, dsn string, apiClient connector.Client, keyring *secrets.Keyring) storage.Store {
+	db, err := openStore(dsn, apiClient, "", keyring)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to connect to database")
+	}
+	if err := db.CheckSchema(context.Background()); err != nil {
+		logger.WithError(err).Fatal("Database schema does not match this binary, run migrate first")
+	}
+	return db
+}
+
+// instanceState names the state of an instance
+func instanceState(state connector.InstantiationState) string {
+	switch state {
+	case connector.InstantiationStateInitialized:
+		return "initialized"
+	case connector.InstantiationStateComplete:
+		return "complete"
+	case connector.InstantiationStateOngoing:
+		return "ongoing"
+	case connector.InstantiationStateFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("unknown (%d)", state)
+}
+
+// formatTime formats t for tables, the zero time is printed as -
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
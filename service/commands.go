@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/sirupsen/logrus"
+)
+
+// command is a subcommand of the connector binary
+type command struct {
+	name    string
+	usage   string
+	summary string
+	// config lists the configuration values the command needs, invalid values abort the command
+	// before it runs. Commands with strictConfig need all values to be valid.
+	config       []string
+	strictConfig bool
+	run          func(logger logrus.FieldLogger, cfg *config, args []string)
+}
+
+// defaultCommand runs if the binary is started without subcommand
+const defaultCommand = "serve"
+
+var commands []command
+
+func init() {
+	// Assigned in init, since the help command refers to commands through printUsage
+	commands = []command{
+		{
+			name:         "serve",
+			usage:        "serve",
+			summary:      "serve the connector (default)",
+			strictConfig: true,
+			run:          runServe,
+		},
+		{
+			name:    "migrate",
+			usage:   migrateUsage,
+			summary: "apply, revert or list database migrations",
+			config:  []string{"db.dsn"},
+			run: func(logger logrus.FieldLogger, cfg *config, args []string) {
+				runMigrate(logger, cfg.dsn, args)
+			},
+		},
+		{
+			name:    "decode",
+			usage:   "decode -decoder name (-payload payload | -event file) [-fport port] [-state key=value]",
+			summary: "decode a payload offline",
+			run: func(logger logrus.FieldLogger, cfg *config, args []string) {
+				runDecode(logger, args)
+			},
+		},
+		{
+			name:    "replay",
+			usage:   "replay -instance id [-device eui] [-application id] [-from time] [-to time] [-dry-run]",
+			summary: "replay archived uplinks through the current decoders",
+			config:  []string{"db.dsn", "secrets.keys"},
+			run: func(logger logrus.FieldLogger, cfg *config, args []string) {
+				runReplay(logger, cfg.dsn, cfg.keyring, args)
+			},
+		},
+		{
+			name:    "secrets",
+			usage:   secretsUsage,
+			summary: "re-encrypt stored secrets with the primary key",
+			config:  []string{"db.dsn", "secrets.keys"},
+			run: func(logger logrus.FieldLogger, cfg *config, args []string) {
+				runSecrets(logger, cfg.dsn, cfg.keyring, args)
+			},
+		},
+		{
+			name:    "admin",
+			usage:   adminUsage,
+			summary: "inspect instances, devices and decoder state in the database",
+			config:  []string{"db.dsn", "secrets.keys"},
+			run:     runAdmin,
+		},
+		{
+			name:    "check-config",
+			usage:   "check-config",
+			summary: "validate the configuration without starting the connector",
+			run:     runCheckConfig,
+		},
+		{
+			name:    "list-decoders",
+			usage:   "list-decoders",
+			summary: "list the registered payload decoders",
+			run:     runListDecoders,
+		},
+		{
+			name:    "help",
+			usage:   "help",
+			summary: "show this help",
+			run: func(logger logrus.FieldLogger, cfg *config, args []string) {
+				printUsage()
+			},
+		},
+	}
+}
+
+// runCommand runs the subcommand named by the first argument or serve if there is none
+func runCommand(logger logrus.FieldLogger, args []string) {
+	name := defaultCommand
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		printUsage()
+		logger.Fatalf("Unknown command %q", name)
+	}
+
+	cfg, problems := loadConfig()
+	failed := false
+	for _, p := range problems {
+		if cmd.strictConfig || contains(cmd.config, p.key) {
+			logger.WithField("key", p.key).WithError(p.err).Error("Invalid configuration")
+			failed = true
+		}
+	}
+	if failed {
+		logger.Fatal("Invalid configuration, run check-config to list all problems")
+	}
+	if cfg.keyring == nil && (cmd.strictConfig || contains(cmd.config, "secrets.keys")) {
+		logger.Warn("No secrets.keys configured, tokens are stored unencrypted")
+	}
+	cmd.run(logger, cfg, args)
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [flags] [command] [args]\n\ncommands:\n", os.Args[0])
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	w.Flush()
+	fmt.Fprintln(out, "\nusage of commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %s\n", cmd.usage)
+	}
+	fmt.Fprintln(out, "\nflags:")
+	flag.PrintDefaults()
+}
+
+// runCheckConfig runs the check-config subcommand, which reports all configuration problems and exits
+// with a non-zero status if there are any
+func runCheckConfig(logger logrus.FieldLogger, cfg *config, args []string) {
+	_, problems := loadConfig()
+	for _, p := range problems {
+		fmt.Println(p.Error())
+	}
+	if len(problems) > 0 {
+		logger.WithField("problems", len(problems)).Fatal("Configuration is invalid")
+	}
+	logger.Info("Configuration is valid")
+}
+
+// runListDecoders runs the list-decoders subcommand, which prints the registered payload decoders and
+// what they support
+func runListDecoders(logger logrus.FieldLogger, cfg *config, args []string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMODEL VERSION\tREPORTING INTERVAL\tACTIONS\tANNOTATES")
+	for _, name := range decoder.Names() {
+		d := decoder.GetDecoder(name)
+		interval := "-"
+		if i := decoder.ReportingInterval(d); i > 0 {
+			interval = i.String()
+		}
+		_, actions := d.(decoder.ActionDecoder)
+		_, annotates := d.(decoder.AnnotatingDecoder)
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", name, decoder.ModelVersion(d), interval, yesNo(actions), yesNo(annotates))
+	}
+	w.Flush()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
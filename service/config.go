@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/connctd/lora-connector/connhttp"
+	"github.com/connctd/lora-connector/memstore"
+	"github.com/connctd/lora-connector/secrets"
+	"github.com/connctd/lora-connector/sqlstore"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+func setDefaults() {
+	viper.SetDefault("http.addr", ":8088")
+	viper.SetDefault("log.level", logrus.InfoLevel.String())
+	viper.SetDefault("things.migrateinstances", []string{})
+	viper.SetDefault("health.integrationtimeout", 24*time.Hour)
+	viper.SetDefault("health.checkinterval", 5*time.Minute)
+	viper.SetDefault("health.checktimeout", 2*time.Second)
+	viper.SetDefault("health.checkconnctd", false)
+	viper.SetDefault("offline.missedintervals", 3)
+	viper.SetDefault("offline.checkinterval", 5*time.Minute)
+	viper.SetDefault("cache.ttl", time.Minute)
+	viper.SetDefault("cache.negativettl", 10*time.Second)
+	viper.SetDefault("cache.sharedinvalidation", true)
+	viper.SetDefault("cache.pollinterval", time.Second)
+	viper.SetDefault("archive.pruneinterval", time.Hour)
+	viper.SetDefault("metrics.enabled", true)
+	viper.SetDefault("metrics.devices", false)
+	viper.SetDefault("tracing.endpoint", "")
+	viper.SetDefault("tracing.insecure", false)
+	viper.SetDefault("tracing.servicename", "lora-connector")
+	viper.SetDefault("tracing.sampleratio", 1.0)
+	viper.SetDefault("shutdown.draindelay", 5*time.Second)
+	viper.SetDefault("shutdown.timeout", 20*time.Second)
+	viper.SetDefault("trustedkeys.reloadinterval", 30*time.Second)
+}
+
+func readConfig() {
+	viper.SetConfigName("config")
+	viper.AddConfigPath(".")
+
+	setDefaults()
+	flag.Parse()
+
+	viper.SetEnvPrefix("LORACONN")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
+	if err := viper.ReadInConfig(); err != nil {
+		logrus.WithError(err).Warn("Failed to read configuration file")
+	}
+	logLevel, err := logrus.ParseLevel(viper.GetString("log.level"))
+	if err != nil {
+		logrus.SetLevel(logrus.DebugLevel)
+		logrus.WithError(err).Warn("Failed to parse log level from config, using debug level as fallback")
+	} else {
+		logrus.SetLevel(logLevel)
+	}
+}
+
+// config holds the values subcommands need parsed, everything else is read from viper directly
+type config struct {
+	dsn     string
+	host    string
+	keys    connhttp.KeySet
+	keyring *secrets.Keyring
+}
+
+// configProblem is an invalid or missing configuration value
+type configProblem struct {
+	key string
+	err error
+}
+
+func (p configProblem) Error() string {
+	return p.key + ": " + p.err.Error()
+}
+
+// durationKeys are the configuration values which need to be non-negative durations
+var durationKeys = []string{
+	"health.integrationtimeout",
+	"health.checkinterval",
+	"health.checktimeout",
+	"offline.checkinterval",
+	"cache.ttl",
+	"cache.negativettl",
+	"cache.pollinterval",
+	"archive.pruneinterval",
+	"shutdown.draindelay",
+	"shutdown.timeout",
+	"trustedkeys.reloadinterval",
+}
+
+// loadConfig parses and validates the configuration. All problems are returned, so that operators
+// can fix them at once. Values which failed to parse are left empty in the returned config.
+func loadConfig() (*config, []configProblem) {
+	cfg := &config{}
+	var problems []configProblem
+	problem := func(key string, err error) {
+		problems = append(problems, configProblem{key: key, err: err})
+	}
+
+	if _, err := logrus.ParseLevel(viper.GetString("log.level")); err != nil {
+		problem("log.level", err)
+	}
+
+	cfg.dsn = viper.GetString("db.dsn")
+	if cfg.dsn == "" {
+		problem("db.dsn", errors.New("missing database DSN (mysql://, postgres://, sqlite:// or memory://)"))
+	} else if cfg.dsn != memstore.DSN {
+		if _, _, err := sqlstore.ParseDSN(cfg.dsn); err != nil {
+			problem("db.dsn", err)
+		}
+	}
+
+	cfg.host = viper.GetString("http.host")
+	if cfg.host == "" {
+		problem("http.host", errors.New("missing public host the connector is reachable under"))
+	} else if strings.ContainsAny(cfg.host, "/?#") {
+		problem("http.host", fmt.Errorf("%q needs to be a host without scheme or path", cfg.host))
+	}
+	for _, key := range []string{"http.addr", "admin.addr"} {
+		if addr := viper.GetString(key); addr != "" {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				problem(key, err)
+			}
+		}
+	}
+
+	keys, err := readTrustedKeys()
+	if err != nil {
+		problem("trustedkeys", err)
+	} else if !anyKeyValid(keys, time.Now()) {
+		problem("trustedkeys", errors.New("none of the trusted keys is valid now"))
+	}
+	cfg.keys = keys
+
+	if cfg.keyring, err = secrets.ParseKeyring(viper.GetString("secrets.keys")); err != nil {
+		problem("secrets.keys", err)
+	}
+
+	for _, key := range durationKeys {
+		d, err := cast.ToDurationE(viper.Get(key))
+		if err == nil && d < 0 {
+			err = errors.New("must not be negative")
+		}
+		if err != nil {
+			problem(key, err)
+		}
+	}
+	for _, key := range []string{"health.checktimeout", "shutdown.timeout", "trustedkeys.reloadinterval"} {
+		if viper.GetDuration(key) == 0 {
+			problem(key, errors.New("needs to be greater than zero"))
+		}
+	}
+	if missed, err := cast.ToIntE(viper.Get("offline.missedintervals")); err != nil {
+		problem("offline.missedintervals", err)
+	} else if missed < 0 {
+		problem("offline.missedintervals", errors.New("must not be negative"))
+	}
+
+	if endpoint := viper.GetString("tracing.endpoint"); endpoint != "" {
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			problem("tracing.endpoint", fmt.Errorf("%q needs to be host:port without scheme", endpoint))
+		}
+	}
+	if ratio, err := cast.ToFloat64E(viper.Get("tracing.sampleratio")); err != nil {
+		problem("tracing.sampleratio", err)
+	} else if ratio < 0 || ratio > 1 {
+		problem("tracing.sampleratio", errors.New("needs to be between 0 and 1"))
+	}
+	return cfg, problems
+}
+
+// readTrustedKeys reads the keys connctd signs requests with. A JSON list of keys with validity
+// windows at trustedkeys.path takes precedence over a single base64 encoded key in pubkey and a single
+// raw key in the file at pubkeypath. Key files are reloaded when they change.
+func readTrustedKeys() (connhttp.KeySet, error) {
+	if path := viper.GetString("trustedkeys.path"); path != "" {
+		return connhttp.NewKeyFile(path, connhttp.ParseTrustedKeys)
+	}
+	if base64PubKey := viper.GetString("pubkey"); base64PubKey != "" {
+		key, err := base64.StdEncoding.DecodeString(base64PubKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode base64 encoded public key: %w", err)
+		}
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("public key has %d bytes, expected %d", len(key), ed25519.PublicKeySize)
+		}
+		return connhttp.StaticKeys{{ID: "pubkey", Key: ed25519.PublicKey(key)}}, nil
+	}
+	if path := viper.GetString("pubkeypath"); path != "" {
+		return connhttp.NewKeyFile(path, connhttp.RawKeyParser("pubkeypath"))
+	}
+	return nil, errors.New("no public key specified, set trustedkeys.path, pubkey or pubkeypath")
+}
+
+func anyKeyValid(keys connhttp.KeySet, t time.Time) bool {
+	for _, key := range keys.TrustedKeys() {
+		if key.ValidAt(t) {
+			return true
+		}
+	}
+	return false
+}
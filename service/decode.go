@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/sirupsen/logrus"
+)
+
+// decodeThingID is the thing the decode subcommand passes to decoders
+const decodeThingID = "decode"
+
+// stateFlags collects the key=value pairs of repeated -state flags
+type stateFlags map[string]string
+
+func (s stateFlags) String() string {
+	pairs := make([]string, 0, len(s))
+	for key, value := range s {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (s stateFlags) Set(pair string) error {
+	i := strings.Index(pair, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid state %q, expected key=value", pair)
+	}
+	s[pair[:i]] = pair[i+1:]
+	return nil
+}
+
+// memoryState keeps the decoder state of a single decode run
+type memoryState map[string][]byte
+
+func (m memoryState) GetState(thingID, key string) ([]byte, error) {
+	value, ok := m[key]
+	if !ok {
+		return nil, decoder.ErrStateNotFound
+	}
+	return value, nil
+}
+
+func (m memoryState) SetState(thingID, key string, value []byte) error {
+	m[key] = value
+	return nil
+}
+
+// runDecode runs the decode subcommand, which decodes a single payload with a registered decoder and
+// prints the property updates and the bytes the decoder reads. It needs neither database nor connctd.
+func runDecode(logger logrus.FieldLogger, args []string) {
+	flags := flag.NewFlagSet("decode", flag.ExitOnError)
+	decoderName := flags.String("decoder", "", "name of the payload decoder (required), one of "+strings.Join(decoder.Names(), ", "))
+	fport := flags.Uint("fport", 1, "FPort of the payload, defaults to the FPort of the event")
+	payload := flags.String("payload", "", "hex or base64 encoded payload")
+	eventFile := flags.String("event", "", "file containing a ChirpStack uplink event in JSON encoding, - reads from stdin")
+	state := stateFlags{}
+	flags.Var(state, "state", "decoder state value as key=value, e.g. mountingHeight=300, may be repeated")
+	flags.Parse(args)
+
+	payloadDecoder := decoder.GetDecoder(*decoderName)
+	if payloadDecoder == nil {
+		logger.WithField("decoders", decoder.Names()).Fatalf("Unknown decoder %q", *decoderName)
+	}
+	if (*payload == "") == (*eventFile == "") {
+		logger.Fatal("Either -payload or -event is required")
+	}
+
+	var msg []byte
+	var err error
+	if *payload != "" {
+		if msg, err = decoder.ParsePayload(*payload); err != nil {
+			logger.WithError(err).Fatal("Invalid payload")
+		}
+	} else {
+		up, err := readUplink(*eventFile)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to read uplink event")
+		}
+		msg = up.Data
+		fportSet := false
+		flags.Visit(func(f *flag.Flag) { fportSet = fportSet || f.Name == "fport" })
+		if !fportSet {
+			*fport = uint(up.FPort)
+		}
+	}
+
+	store := memoryState{}
+	for key, value := range state {
+		if err := decoder.NewState(store, *decoderName, decodeThingID).SetString(key, value); err != nil {
+			logger.WithError(err).Fatal("Failed to set decoder state")
+		}
+	}
+	updates, err := payloadDecoder.DecodeMessage(store, uint32(*fport), msg, decodeThingID)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to decode payload")
+	}
+
+	fmt.Printf("Decoder %s, FPort %d, %d bytes\n\n", *decoderName, *fport, len(msg))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COMPONENT\tPROPERTY\tVALUE")
+	for _, update := range updates {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", update.ComponentID, update.PropertyID, update.Value)
+	}
+	w.Flush()
+
+	fmt.Printf("\n%s\n", hex.Dump(msg))
+	fields := decoder.Annotate(payloadDecoder, uint32(*fport), msg)
+	if fields == nil {
+		fmt.Printf("Decoder %s does not annotate payloads\n", *decoderName)
+		return
+	}
+	fmt.Fprintln(w, "FIELD\tOFFSETS\tBYTES\tVALUE")
+	for _, field := range fields {
+		offsets := make([]string, len(field.Offsets))
+		for i, offset := range field.Offsets {
+			offsets[i] = fmt.Sprintf("%d", offset)
+		}
+		fmt.Fprintf(w, "%s\t%s\t% x\t%s\n", field.Name, strings.Join(offsets, ","), field.Bytes(msg), field.Value)
+	}
+	w.Flush()
+}
+
+// readUplink reads an uplink event from the file or stdin if the file is -
+func readUplink(file string) (*integration.UplinkEvent, error) {
+	var b []byte
+	var err error
+	if file == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return lorawan.ParseUplink(b)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/handlers"
+	"github.com/sirupsen/logrus"
+)
+
+// lifecycle tracks whether the connector accepts traffic and runs the background jobs, which are
+// stopped on shutdown after the HTTP servers finished the requests in flight
+type lifecycle struct {
+	logger logrus.FieldLogger
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   sync.WaitGroup
+	ready  int32
+
+	servers []*http.Server
+	failed  chan error
+}
+
+func newLifecycle(logger logrus.FieldLogger) *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+		failed: make(chan error, 1),
+	}
+}
+
+// Go runs the job until the lifecycle is stopped, the job needs to return once ctx is done
+func (l *lifecycle) Go(job func(ctx context.Context)) {
+	l.jobs.Add(1)
+	go func() {
+		defer l.jobs.Done()
+		job(l.ctx)
+	}()
+}
+
+// Serve listens on addr and serves the handler until the lifecycle is stopped. Listening errors
+// are returned immediately, errors of the running server are reported through Failed.
+func (l *lifecycle) Serve(name string, addr string, handler http.Handler) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handlers.RecoveryHandler(handlers.PrintRecoveryStack(true), handlers.RecoveryLogger(l.logger))(handler),
+	}
+	l.servers = append(l.servers, server)
+	l.logger.WithField("addr", listener.Addr().String()).Infof("%s listening", name)
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			l.logger.WithError(err).Errorf("%s failed", name)
+			select {
+			case l.failed <- err:
+			default:
+			}
+		}
+	}()
+	return nil
+}
+
+// Failed receives the error of the first server which stopped unexpectedly
+func (l *lifecycle) Failed() <-chan error {
+	return l.failed
+}
+
+// SetReady marks whether the connector should receive traffic
+func (l *lifecycle) SetReady(ready bool) {
+	var value int32
+	if ready {
+		value = 1
+	}
+	atomic.StoreInt32(&l.ready, value)
+}
+
+// Ready returns whether the connector should receive traffic
+func (l *lifecycle) Ready() bool {
+	return atomic.LoadInt32(&l.ready) == 1
+}
+
+// CheckReady fails while the connector is starting or draining
+func (l *lifecycle) CheckReady(ctx context.Context) error {
+	if !l.Ready() {
+		return errors.New("connector is starting or shutting down")
+	}
+	return nil
+}
+
+// Shutdown finishes the requests in flight and stops the background jobs afterwards, since
+// requests may depend on them. Jobs which don't return before ctx is done are abandoned.
+func (l *lifecycle) Shutdown(ctx context.Context) error {
+	l.SetReady(false)
+	var shutdownErr error
+	for _, server := range l.servers {
+		if err := server.Shutdown(ctx); err != nil && shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+	l.cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		l.jobs.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		if shutdownErr == nil {
+			shutdownErr = ctx.Err()
+		}
+	}
+	return shutdownErr
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/connctd/lora-connector/sqlstore"
+	"github.com/sirupsen/logrus"
+)
+
+const migrateUsage = "usage: migrate up | down [version] | status"
+
+// runMigrate runs the migrate subcommand. Down without version reverts the latest applied migration.
+func runMigrate(logger logrus.FieldLogger, dsn string, args []string) {
+	if len(args) == 0 {
+		logger.Fatal(migrateUsage)
+	}
+	db, err := sqlstore.NewDB(dsn, nil, "", nil)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to connect to database")
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		if err := db.MigrateUp(ctx); err != nil {
+			logger.WithError(err).Fatal("Failed to migrate database schema")
+		}
+		logger.WithField("version", sqlstore.LatestSchemaVersion()).Info("Database schema is up to date")
+	case "down":
+		version, err := downTarget(ctx, db, args[1:])
+		if err != nil {
+			logger.WithError(err).Fatal(migrateUsage)
+		}
+		if err := db.MigrateDown(ctx, version); err != nil {
+			logger.WithError(err).Fatal("Failed to revert database schema")
+		}
+		logger.WithField("version", version).Info("Reverted database schema")
+	case "status":
+		status, err := db.MigrationStatus(ctx)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to read migration status")
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tAPPLIED\tDESCRIPTION")
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			description := s.Description
+			if s.Unknown {
+				description += " (unknown to this binary)"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, applied, description)
+		}
+		w.Flush()
+	default:
+		logger.Fatal(migrateUsage)
+	}
+}
+
+// downTarget returns the version to revert to, which is either given or the version before the latest applied migration
+func downTarget(ctx context.Context, db *sqlstore.DB, args []string) (uint, error) {
+	if len(args) > 0 {
+		version, err := strconv.ParseUint(args[0], 10, 32)
+		return uint(version), err
+	}
+	status, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var applied []uint
+	for _, s := range status {
+		if s.AppliedAt != nil {
+			applied = append(applied, s.Version)
+		}
+	}
+	if len(applied) < 2 {
+		return 0, nil
+	}
+	return applied[len(applied)-2], nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/connclient"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/secrets"
+	"github.com/sirupsen/logrus"
+)
+
+// runReplay runs the replay subcommand, which replays archived uplinks through the current decoders
+// and prints the changed property values
+func runReplay(logger logrus.FieldLogger, dsn string, keyring *secrets.Keyring, args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	instanceID := flags.String("instance", "", "ID of the instance whose uplinks are replayed (required)")
+	device := flags.String("device", "", "replay only uplinks of the device with this hex encoded DevEUI")
+	application := flags.Uint64("application", 0, "replay only uplinks of this LoRaWAN application")
+	from := flags.String("from", "", "replay only uplinks received at or after this RFC 3339 time")
+	to := flags.String("to", "", "replay only uplinks received before this RFC 3339 time")
+	dryRun := flags.Bool("dry-run", false, "print the changed property values without pushing them to connctd")
+	flags.Parse(args)
+
+	options := lorawan.ReplayOptions{
+		InstanceID:    *instanceID,
+		ApplicationID: *application,
+		DryRun:        *dryRun,
+	}
+	var err error
+	if *device != "" {
+		if options.DevEUI, err = lorawan.ParseEUI(*device); err != nil {
+			logger.WithError(err).Fatal("Invalid device")
+		}
+	}
+	if options.From, err = parseTime(*from); err != nil {
+		logger.WithError(err).Fatal("Invalid start of time range")
+	}
+	if options.To, err = parseTime(*to); err != nil {
+		logger.WithError(err).Fatal("Invalid end of time range")
+	}
+
+	apiClient, err := connector.NewClient(connclient.Options(), connector.DefaultLogger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to setup connctd client")
+	}
+	db, err := openStore(dsn, apiClient, "", keyring)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to connect to database")
+	}
+	defer db.Close()
+	if err := db.CreateOrMigrate(); err != nil {
+		logger.WithError(err).Fatal("Failed to migrate database schema")
+	}
+
+	report, err := lorawan.NewLoRaWANHandler(apiClient, true, db).Replay(context.Background(), options)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to replay archived uplinks")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "EVENT\tDEVICE\tTHING\tPROPERTY\tTIME\tOLD\tNEW")
+	for _, c := range report.Changes {
+		old := "-"
+		if c.OldValue != nil {
+			old = *c.OldValue
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s/%s\t%s\t%s\t%s\n", c.EventID, c.DevEUI, c.ThingID, c.ComponentID, c.PropertyID, c.Time.Format(time.RFC3339), old, c.NewValue)
+	}
+	w.Flush()
+	for _, e := range report.Errors {
+		logger.Error(e)
+	}
+	logger.WithFields(logrus.Fields{
+		"dryRun":   report.DryRun,
+		"replayed": report.Replayed,
+		"skipped":  report.Skipped,
+		"changes":  len(report.Changes),
+		"errors":   len(report.Errors),
+	}).Info("Replay finished")
+}
+
+// parseTime parses an RFC 3339 time, an empty string is the zero time
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+
+	"github.com/connctd/lora-connector/secrets"
+	"github.com/connctd/lora-connector/sqlstore"
+	"github.com/sirupsen/logrus"
+)
+
+const secretsUsage = "usage: secrets reencrypt"
+
+// runSecrets runs the secrets subcommand. Reencrypt encrypts all secrets with the primary key, which
+// is required before removing an old key from secrets.keys.
+func runSecrets(logger logrus.FieldLogger, dsn string, keyring *secrets.Keyring, args []string) {
+	if len(args) != 1 || args[0] != "reencrypt" {
+		logger.Fatal(secretsUsage)
+	}
+	if keyring == nil {
+		logger.Fatal("No keys configured, secrets.keys is required to re-encrypt secrets")
+	}
+	db, err := sqlstore.NewDB(dsn, nil, "", keyring)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to connect to database")
+	}
+	defer db.Close()
+	updated, err := db.ReencryptSecrets(context.Background())
+	if err != nil {
+		logger.WithError(err).WithField("updated", updated).Fatal("Failed to re-encrypt secrets")
+	}
+	logger.WithFields(logrus.Fields{
+		"updated": updated,
+		"keyId":   keyring.PrimaryKeyID(),
+	}).Info("Re-encrypted secrets")
+}
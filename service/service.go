@@ -1,108 +1,106 @@
 package main
 
 import (
-	"crypto/ed25519"
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"flag"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
+	_ "time/tzdata" // the container image ships without timezone database
 
 	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/admin"
+	"github.com/connctd/lora-connector/cache"
+	"github.com/connctd/lora-connector/connclient"
 	"github.com/connctd/lora-connector/connhttp"
+	"github.com/connctd/lora-connector/health"
 	"github.com/connctd/lora-connector/lorawan"
 	_ "github.com/connctd/lora-connector/lorawan/decoder/dcl571"
 	_ "github.com/connctd/lora-connector/lorawan/decoder/ldds75"
-	"github.com/connctd/lora-connector/mysql"
+	"github.com/connctd/lora-connector/memstore"
+	"github.com/connctd/lora-connector/metrics"
+	"github.com/connctd/lora-connector/monitor"
+	"github.com/connctd/lora-connector/secrets"
+	"github.com/connctd/lora-connector/sqlstore"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/connctd/lora-connector/tracing"
 	"github.com/connctd/restapi-go"
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
-var (
-	Version = "undefined"
-)
-
-func setDefaults() {
-	viper.SetDefault("http.addr", ":8088")
-	viper.SetDefault("log.level", logrus.InfoLevel.String())
-}
-
-func readConfig() {
-	viper.SetConfigName("config")
-	viper.AddConfigPath(".")
-
-	setDefaults()
-	flag.Parse()
-
-	viper.SetEnvPrefix("LORACONN")
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	viper.AutomaticEnv()
-
-	if err := viper.ReadInConfig(); err != nil {
-		logrus.WithError(err).Warn("Failed to read configuration file")
-	}
-	logLevel, err := logrus.ParseLevel(viper.GetString("log.level"))
-	if err != nil {
-		logrus.SetLevel(logrus.DebugLevel)
-		logrus.WithError(err).Warn("Failed to parse log level from config, using debug level as fallback")
-	} else {
-		logrus.SetLevel(logLevel)
-	}
-}
+var Version = "undefined"
 
 func main() {
 	readConfig()
+	runCommand(logrus.WithField("version", Version), flag.Args())
+}
 
-	logger := logrus.WithField("version", Version)
-
-	dsn := viper.GetString("db.dsn")
-	if dsn == "" {
-		logger.Fatal("Failed to read database DSN from config, please set db.dsn value")
-	}
+// runServe runs the serve subcommand, which serves the connector until it receives SIGINT or SIGTERM
+func runServe(logger logrus.FieldLogger, cfg *config, args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Parse(args)
 
-	host := viper.GetString("http.host")
-	if host == "" {
-		logger.Fatal("no http.host configured. Please set http.host to the public host the connector is reachable under")
-	}
+	host, dsn, keyring := cfg.host, cfg.dsn, cfg.keyring
 	logger = logger.WithField("host", host)
 
-	apiClient, err := connector.NewClient(connector.DefaultOptions(), connector.DefaultLogger)
+	clientOptions := connclient.Options()
+	apiClient, err := connector.NewClient(clientOptions, connector.DefaultLogger)
 	if err != nil {
 		logger.WithError(err).Fatalln("Failed to setup connctd client")
 	}
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    viper.GetString("tracing.endpoint"),
+		Insecure:    viper.GetBool("tracing.insecure"),
+		Headers:     viper.GetStringMapString("tracing.headers"),
+		ServiceName: viper.GetString("tracing.servicename"),
+		SampleRatio: viper.GetFloat64("tracing.sampleratio"),
+	})
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to setup tracing")
+	}
+	apiClient = tracing.InstrumentClient(apiClient)
+	if viper.GetBool("metrics.enabled") {
+		apiClient = metrics.InstrumentClient(apiClient)
+		if viper.GetBool("metrics.devices") {
+			metrics.EnableDeviceMetrics()
+		}
+	}
 
-	db, err := mysql.NewDB(dsn, apiClient, host)
+	db, err := openStore(dsn, apiClient, host, keyring)
 	if err != nil {
 		logger.WithError(err).Fatal("Failed to connect to database")
 	}
 
 	if err := db.CreateOrMigrate(); err != nil {
-		logger.WithError(err).Fatal("Failed to run DB migrations generated by GORM")
+		logger.WithError(err).Fatal("Failed to migrate database schema")
 	}
 
-	var pubKey ed25519.PublicKey
-	base64PubKey := viper.GetString("pubkey")
-	if base64PubKey != "" {
-		pubKeyBytes, err := base64.StdEncoding.DecodeString(base64PubKey)
-		if err != nil {
-			logger.WithError(err).Fatal("Failed to decode base64 encoded public key")
-		}
-		pubKey = ed25519.PublicKey(pubKeyBytes)
-	} else if pubKeyPath := viper.GetString("pubkeypath"); pubKeyPath != "" {
-		buf, err := ioutil.ReadFile(pubKeyPath)
-		if err != nil {
-			logger.WithField("path", pubKeyPath).WithError(err).Fatal("Failed to read public key from file")
-		}
-		pubKey = ed25519.PublicKey(buf)
-	} else {
-		logger.Fatal("no public key specified")
+	lc := newLifecycle(logger)
+	if ttl := viper.GetDuration("cache.ttl"); ttl > 0 {
+		db = withCache(lc, db, ttl)
+	}
+	if timeout := viper.GetDuration("health.integrationtimeout"); timeout > 0 {
+		integrationMonitor := monitor.NewIntegrationMonitor(db, apiClient, timeout)
+		runAsLeader(lc, db, "integrationmonitor", viper.GetDuration("health.checkinterval"), integrationMonitor.Check)
+	}
+	if missedIntervals := viper.GetInt("offline.missedintervals"); missedIntervals > 0 {
+		offlineDetector := monitor.NewOfflineDetector(db, apiClient, missedIntervals)
+		runAsLeader(lc, db, "offlinedetector", viper.GetDuration("offline.checkinterval"), offlineDetector.Check)
+	}
+	if interval := viper.GetDuration("archive.pruneinterval"); interval > 0 {
+		runAsLeader(lc, db, "archivepruner", interval, func(ctx context.Context) error {
+			pruned, err := db.PruneArchive(ctx, time.Now())
+			if pruned > 0 {
+				logger.WithField("events", pruned).Info("Pruned archived events")
+			}
+			return err
+		})
 	}
 
 	r := mux.NewRouter()
@@ -118,35 +116,132 @@ func main() {
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(resp)
 	})
-	r.Path("/health").Methods(http.MethodGet).HandlerFunc(simpleHealthHandler)
+	readiness := health.NewChecker(viper.GetDuration("health.checktimeout"))
+	readiness.Add("lifecycle", lc.CheckReady)
+	readiness.Add("database", db.Ping)
+	readiness.Add("migrations", db.CheckSchema)
+	if viper.GetBool("health.checkconnctd") {
+		readiness.Add("connctd", func(ctx context.Context) error {
+			return connclient.Ping(ctx, clientOptions)
+		})
+	}
+	// /health is kept for probes configured before liveness and readiness were separated
+	r.Path("/health").Methods(http.MethodGet).HandlerFunc(health.LivenessHandler)
+	r.Path("/live").Methods(http.MethodGet).HandlerFunc(health.LivenessHandler)
+	r.Path("/ready").Methods(http.MethodGet).Handler(readiness)
+	if viper.GetBool("metrics.enabled") {
+		r.Path("/metrics").Methods(http.MethodGet).Handler(metrics.Handler())
+	}
 
 	loraWANHandler := lorawan.NewLoRaWANHandler(apiClient, true, db)
+	if instanceIDs := viper.GetStringSlice("things.migrateinstances"); len(instanceIDs) > 0 {
+		lc.Go(func(ctx context.Context) {
+			for _, instanceID := range instanceIDs {
+				migrateInstanceThings(ctx, logger, loraWANHandler, instanceID)
+			}
+		})
+	}
+	// The archiver is stopped after the requests in flight have been handled and drains its queue
+	lc.Go(loraWANHandler.RunArchiver)
 	r.Path("/lorawan/{installationId}/{instanceId}").Methods(http.MethodPost, http.MethodPut).Handler(loraWANHandler)
 	cr := r.PathPrefix("/connector").Subrouter()
 
-	connhttp.NewConnectorHandler(cr, db, host, pubKey)
+	if keyFile, ok := cfg.keys.(*connhttp.KeyFile); ok {
+		lc.Go(func(ctx context.Context) {
+			keyFile.Run(ctx, viper.GetDuration("trustedkeys.reloadinterval"))
+		})
+	}
+	connhttp.NewConnectorHandler(cr, db, host, cfg.keys)
+
+	if token := viper.GetString("admin.token"); token != "" {
+		if addr := viper.GetString("admin.addr"); addr != "" {
+			ar := mux.NewRouter()
+			admin.NewHandler(ar.PathPrefix("/admin").Subrouter(), db, loraWANHandler, loraWANHandler, token)
+			// The admin API is kept on its own listener, so that it can be kept out of public ingresses
+			if err := lc.Serve("Admin API", addr, ar); err != nil {
+				logger.WithError(err).Fatal("Failed to listen for admin API")
+			}
+		} else {
+			admin.NewHandler(r.PathPrefix("/admin").Subrouter(), db, loraWANHandler, loraWANHandler, token)
+		}
+	} else {
+		logger.Info("No admin.token configured, admin API is disabled")
+	}
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		server := &http.Server{
-			Addr:    viper.GetString("http.addr"),
-			Handler: handlers.RecoveryHandler(handlers.PrintRecoveryStack(true), handlers.RecoveryLogger(logger))(r),
-		}
+	if err := lc.Serve("HTTP server", viper.GetString("http.addr"), r); err != nil {
+		logger.WithError(err).Fatal("Failed to listen")
+	}
+	lc.SetReady(true)
+
+	exitCode := 0
+	select {
+	case sig := <-sigs:
+		logger.WithField("signal", sig.String()).Info("Shutting down")
+		// Stop receiving new traffic before the servers refuse it, Kubernetes takes a moment to
+		// remove the pod from the endpoints after the readiness probe failed
+		lc.SetReady(false)
+		time.Sleep(viper.GetDuration("shutdown.draindelay"))
+	case <-lc.Failed():
+		logger.Error("Shutting down after server failure")
+		exitCode = 1
+	}
 
-		logger.WithField("addr", server.Addr).Info("Listening")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), viper.GetDuration("shutdown.timeout"))
+	if err := lc.Shutdown(shutdownCtx); err != nil {
+		logger.WithError(err).Error("Failed to finish requests and background jobs in time")
+		exitCode = 1
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.WithError(err).Warn("Failed to flush spans")
+	}
+	cancelShutdown()
+	if err := db.Close(); err != nil {
+		logger.WithError(err).Warn("Failed to close database")
+	}
+	logger.Info("Shut down")
+	os.Exit(exitCode)
+}
 
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.WithError(err).Error("HTTP server failed")
-		}
-	}()
+// migrateInstanceThings replaces the outdated things of an instance, which opted in to the migration
+func migrateInstanceThings(ctx context.Context, logger logrus.FieldLogger, handler *lorawan.LoRaWANHandler, instanceID string) {
+	logger = logger.WithField("instanceId", instanceID)
+	migrated, err := handler.MigrateThings(ctx, strings.TrimSpace(instanceID))
+	if err != nil {
+		logger.WithError(err).Error("Failed to migrate things")
+	}
+	logger.WithField("migrated", migrated).Info("Migrated things to current thing models")
+}
 
-	<-sigs
-	logrus.Info("Shutting down")
-	os.Exit(0)
+// runAsLeader runs the job periodically in the lifecycle while this replica holds the leadership
+func runAsLeader(lc *lifecycle, elector monitor.Elector, name string, interval time.Duration, job func(ctx context.Context) error) {
+	lc.Go(func(ctx context.Context) {
+		monitor.RunAsLeader(ctx, elector, name, interval, job)
+	})
+}
 
+// openStore selects the storage backend by the scheme of the DSN
+func openStore(dsn string, apiClient connector.Client, host string, keyring *secrets.Keyring) (storage.Store, error) {
+	if dsn == memstore.DSN {
+		logrus.Warn("Using in-memory storage, everything is lost on shutdown")
+		return memstore.New(apiClient, host), nil
+	}
+	return sqlstore.NewDB(dsn, apiClient, host, keyring)
 }
 
-func simpleHealthHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
+// withCache wraps the store with a cache. Replicas share invalidations through the database, if
+// the store supports it.
+func withCache(lc *lifecycle, store storage.Store, ttl time.Duration) storage.Store {
+	options := cache.Options{
+		TTL:          ttl,
+		NegativeTTL:  viper.GetDuration("cache.negativettl"),
+		PollInterval: viper.GetDuration("cache.pollinterval"),
+	}
+	if invalidations, ok := store.(cache.Invalidations); ok && viper.GetBool("cache.sharedinvalidation") {
+		options.Invalidations = invalidations
+	}
+	cached := cache.New(store, options)
+	lc.Go(cached.Run)
+	return cached
 }
@@ -0,0 +1,174 @@
+package sqlstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/connctd/lora-connector/storage"
+)
+
+// maxEventTypeLength is the size of the event column, longer event types are cut
+const maxEventTypeLength = 64
+
+// ArchivedEvent is a received event as it was sent by the network server
+type ArchivedEvent struct {
+	ID         uint64    `gorm:"primaryKey"`
+	InstanceID string    `gorm:"size:36;index:idx_archived_events_instance_received"`
+	ReceivedAt time.Time `gorm:"index:idx_archived_events_instance_received"`
+	Event      string    `gorm:"size:64"`
+	Encoding   string    `gorm:"size:16"`
+	Body       []byte    `gorm:"size:1048576"`
+	DevEUI     []byte    `gorm:"size:8;index"`
+	FCnt       uint32
+	Outcome    string `gorm:"size:16"`
+	Error      string `gorm:"type:text"`
+	// Updates holds the JSON encoded property updates pushed for the event
+	Updates string `gorm:"type:text"`
+}
+
+func encodeUpdates(updates []decoder.PropertyUpdate) (string, error) {
+	if len(updates) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(updates)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode property updates: %w", err)
+	}
+	return string(b), nil
+}
+
+func newArchivedEvent(event lorawan.ArchivedEvent) (*ArchivedEvent, error) {
+	if len(event.Event) > maxEventTypeLength {
+		event.Event = event.Event[:maxEventTypeLength]
+	}
+	updates, err := encodeUpdates(event.Updates)
+	if err != nil {
+		return nil, err
+	}
+	return &ArchivedEvent{
+		InstanceID: event.InstanceID,
+		ReceivedAt: event.ReceivedAt.UTC(),
+		Event:      event.Event,
+		Encoding:   event.Encoding,
+		Body:       event.Body,
+		DevEUI:     event.DevEUI,
+		FCnt:       event.FCnt,
+		Outcome:    string(event.Outcome),
+		Error:      event.Error,
+		Updates:    updates,
+	}, nil
+}
+
+func (e ArchivedEvent) archivedEvent() (lorawan.ArchivedEvent, error) {
+	event := lorawan.ArchivedEvent{
+		ID:         e.ID,
+		InstanceID: e.InstanceID,
+		Event:      e.Event,
+		Encoding:   e.Encoding,
+		Body:       e.Body,
+		DevEUI:     e.DevEUI,
+		FCnt:       e.FCnt,
+		ReceivedAt: e.ReceivedAt,
+		Outcome:    lorawan.EventOutcome(e.Outcome),
+		Error:      e.Error,
+	}
+	if e.Updates != "" {
+		if err := json.Unmarshal([]byte(e.Updates), &event.Updates); err != nil {
+			return event, fmt.Errorf("failed to decode property updates of archived event %d: %w", e.ID, err)
+		}
+	}
+	return event, nil
+}
+
+func (d *DB) ArchiveEvent(ctx context.Context, event lorawan.ArchivedEvent) error {
+	archived, err := newArchivedEvent(event)
+	if err != nil {
+		return err
+	}
+	return d.db.WithContext(ctx).Create(archived).Error
+}
+
+func (d *DB) SetArchivedUpdates(ctx context.Context, eventID uint64, updates []decoder.PropertyUpdate) error {
+	encoded, err := encodeUpdates(updates)
+	if err != nil {
+		return err
+	}
+	result := d.db.WithContext(ctx).Model(&ArchivedEvent{}).Where("id = ?", eventID).Update("updates", encoded)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return storage.ErrNotFound
+	}
+	return result.Error
+}
+
+func (d *DB) ListArchivedEvents(ctx context.Context, filter lorawan.ArchiveFilter) ([]lorawan.ArchivedEvent, error) {
+	query := d.db.WithContext(ctx).Model(&ArchivedEvent{})
+	if filter.InstanceID != "" {
+		query = query.Where("instance_id = ?", filter.InstanceID)
+	}
+	if len(filter.DevEUI) > 0 {
+		query = query.Where("dev_e_ui = ?", filter.DevEUI)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("received_at >= ?", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("received_at < ?", filter.To.UTC())
+	}
+	if len(filter.Outcomes) > 0 {
+		query = query.Where("outcome IN ?", filter.Outcomes)
+	}
+	if filter.AfterID > 0 {
+		query = query.Where("id > ?", filter.AfterID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	order := "received_at, id"
+	switch {
+	case filter.ByID && filter.Latest:
+		order = "id DESC"
+	case filter.ByID:
+		order = "id"
+	case filter.Latest:
+		order = "received_at DESC, id DESC"
+	}
+	var archived []ArchivedEvent
+	if err := query.Order(order).Find(&archived).Error; err != nil {
+		return nil, err
+	}
+	events := make([]lorawan.ArchivedEvent, 0, len(archived))
+	for _, e := range archived {
+		event, err := e.archivedEvent()
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// PruneArchive deletes the events which exceeded the archive retention of their instance. Events of
+// instances which don't exist anymore are deleted as well.
+func (d *DB) PruneArchive(ctx context.Context, now time.Time) (int, error) {
+	db := d.db.WithContext(ctx)
+	var instances []Instance
+	if err := db.Select("id", "archive_retention_days").Find(&instances).Error; err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for _, instance := range instances {
+		cutoff := now.AddDate(0, 0, -instance.ArchiveRetentionDays).UTC()
+		result := db.Where("instance_id = ? AND received_at < ?", instance.ID, cutoff).Delete(&ArchivedEvent{})
+		pruned += int(result.RowsAffected)
+		if result.Error != nil {
+			return pruned, result.Error
+		}
+	}
+	result := db.Where("instance_id NOT IN (?)", db.Model(&Instance{}).Select("id")).Delete(&ArchivedEvent{})
+	pruned += int(result.RowsAffected)
+	return pruned, result.Error
+}
@@ -0,0 +1,6 @@
+package sqlstore
+
+// DropAll drops all tables, so that tests against database servers start with an empty database
+func (d *DB) DropAll() error {
+	return d.db.Migrator().DropTable(&DecoderState{}, &DecoderConfig{}, &IDMapping{}, &Instance{}, &Installation{}, &SchemaMigration{}, &CacheInvalidation{})
+}
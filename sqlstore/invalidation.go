@@ -0,0 +1,55 @@
+package sqlstore
+
+import (
+	"context"
+	"time"
+)
+
+// invalidationRetention is how long invalidations are kept for replicas polling them
+const invalidationRetention = time.Hour
+
+// CacheInvalidation records a cache key invalidated by a replica, so that other replicas can drop it as well
+type CacheInvalidation struct {
+	ID        uint64    `gorm:"primaryKey"`
+	CacheKey  string    `gorm:"size:255"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// PublishInvalidations records the invalidated cache keys and drops outdated invalidations
+func (d *DB) PublishInvalidations(ctx context.Context, keys []string) error {
+	invalidations := make([]CacheInvalidation, 0, len(keys))
+	for _, key := range keys {
+		invalidations = append(invalidations, CacheInvalidation{CacheKey: key})
+	}
+	if err := d.db.WithContext(ctx).Create(&invalidations).Error; err != nil {
+		return err
+	}
+	return d.db.WithContext(ctx).Where("created_at < ?", time.Now().Add(-invalidationRetention)).Delete(&CacheInvalidation{}).Error
+}
+
+// LatestInvalidation returns the ID of the latest invalidation or 0 if there is none
+func (d *DB) LatestInvalidation(ctx context.Context) (uint64, error) {
+	var latest *uint64
+	err := d.db.WithContext(ctx).Model(&CacheInvalidation{}).Select("MAX(id)").Scan(&latest).Error
+	if err != nil || latest == nil {
+		return 0, err
+	}
+	return *latest, nil
+}
+
+// Invalidations returns the keys invalidated after the invalidation with the given ID and the ID of
+// the latest returned invalidation. Invalidations committed out of order by concurrent transactions
+// may be missed, the cache TTL limits how long they are outdated.
+func (d *DB) Invalidations(ctx context.Context, after uint64) ([]string, uint64, error) {
+	var invalidations []CacheInvalidation
+	err := d.db.WithContext(ctx).Where("id > ?", after).Order("id").Find(&invalidations).Error
+	if err != nil {
+		return nil, after, err
+	}
+	keys := make([]string, 0, len(invalidations))
+	for _, invalidation := range invalidations {
+		keys = append(keys, invalidation.CacheKey)
+		after = invalidation.ID
+	}
+	return keys, after, nil
+}
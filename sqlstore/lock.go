@@ -0,0 +1,212 @@
+package sqlstore
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/connctd/lora-connector/monitor"
+	"github.com/connctd/lora-connector/storage"
+)
+
+// lockTimeout is the maximum time to wait for a named lock held by another connection
+const lockTimeout = 10 * time.Second
+
+// locker provides named locks which are shared by all replicas using the same database
+type locker interface {
+	// acquire waits up to timeout for the named lock. It returns nil if the lock is held by someone else.
+	acquire(ctx context.Context, name string, timeout time.Duration) (namedLock, error)
+}
+
+type namedLock interface {
+	// held reports whether the lock is still held, locks held by a database session are lost with the session
+	held(ctx context.Context) bool
+	release()
+}
+
+// LockDevice acquires a named lock for provisioning the given device. Since the lock lives in
+// the database it also serializes provisioning across replicas.
+func (d *DB) LockDevice(ctx context.Context, instanceID string, devEUI []byte) (func(), error) {
+	return d.lock(ctx, fmt.Sprintf("device/%s/%x", instanceID, devEUI))
+}
+
+// TryLeadership tries to acquire the named lock without waiting. The lock is held until it is released
+// or the database connection is lost.
+func (d *DB) TryLeadership(ctx context.Context, name string) (monitor.Leadership, error) {
+	l, err := d.locker.acquire(ctx, "leader/"+name, 0)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	return &leadership{lock: l}, nil
+}
+
+type leadership struct {
+	lock namedLock
+}
+
+func (l *leadership) Held(ctx context.Context) bool {
+	return l.lock.held(ctx)
+}
+
+func (l *leadership) Release() {
+	l.lock.release()
+}
+
+func (d *DB) lock(ctx context.Context, name string) (func(), error) {
+	l, err := d.locker.acquire(ctx, name, lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil {
+		return nil, fmt.Errorf("failed to acquire lock %s: %w", name, storage.ErrLockTimeout)
+	}
+	return l.release, nil
+}
+
+// sessionLock is a lock bound to the database session of a dedicated connection
+type sessionLock struct {
+	db         *DB
+	conn       *sql.Conn
+	name       string
+	isHeld     func(ctx context.Context, conn *sql.Conn) bool
+	releaseSQL string
+	lockID     interface{}
+}
+
+func (s *sessionLock) held(ctx context.Context) bool {
+	return s.isHeld(ctx, s.conn)
+}
+
+func (s *sessionLock) release() {
+	if _, err := s.conn.ExecContext(context.Background(), s.releaseSQL, s.lockID); err != nil {
+		s.db.logger.WithError(err).WithField("lock", s.name).Error("Failed to release lock")
+	}
+	s.conn.Close()
+}
+
+// conn returns a dedicated connection, since databases bind named locks to the session that acquired them
+func (d *DB) conn(ctx context.Context) (*sql.Conn, error) {
+	sqlDB, err := d.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	return sqlDB.Conn(ctx)
+}
+
+// mysqlLocker uses MySQL named locks
+type mysqlLocker struct {
+	db *DB
+}
+
+func (m *mysqlLocker) acquire(ctx context.Context, name string, timeout time.Duration) (namedLock, error) {
+	conn, err := m.db.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// MySQL limits lock names to 64 characters
+	lockName := fmt.Sprintf("loraconn:%x", sha1.Sum([]byte(name)))
+
+	var acquired sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, int(timeout.Seconds())).Scan(&acquired)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		conn.Close()
+		return nil, nil
+	}
+	return &sessionLock{
+		db:   m.db,
+		conn: conn,
+		name: name,
+		isHeld: func(ctx context.Context, conn *sql.Conn) bool {
+			var held sql.NullInt64
+			err := conn.QueryRowContext(ctx, "SELECT IS_USED_LOCK(?) = CONNECTION_ID()", lockName).Scan(&held)
+			return err == nil && held.Valid && held.Int64 == 1
+		},
+		releaseSQL: "SELECT RELEASE_LOCK(?)",
+		lockID:     lockName,
+	}, nil
+}
+
+// postgresLockPollInterval is the interval in which postgresLocker retries to acquire a lock
+const postgresLockPollInterval = 100 * time.Millisecond
+
+// postgresLocker uses session level advisory locks of PostgreSQL
+type postgresLocker struct {
+	db *DB
+}
+
+func (p *postgresLocker) acquire(ctx context.Context, name string, timeout time.Duration) (namedLock, error) {
+	conn, err := p.db.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Advisory locks are identified by a 64 bit integer
+	hash := sha1.Sum([]byte("loraconn:" + name))
+	lockID := int64(binary.BigEndian.Uint64(hash[:8]))
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var acquired bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", lockID).Scan(&acquired); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+		if time.Now().After(deadline) {
+			conn.Close()
+			return nil, nil
+		}
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			return nil, ctx.Err()
+		case <-time.After(postgresLockPollInterval):
+		}
+	}
+	return &sessionLock{
+		db:   p.db,
+		conn: conn,
+		name: name,
+		isHeld: func(ctx context.Context, conn *sql.Conn) bool {
+			// Advisory locks are held until the session ends
+			return conn.PingContext(ctx) == nil
+		},
+		releaseSQL: "SELECT pg_advisory_unlock($1)",
+		lockID:     lockID,
+	}, nil
+}
+
+// localLocker provides locks within a single process for databases which can't be shared by replicas
+type localLocker struct {
+	locks *storage.LocalLocks
+}
+
+func (l localLocker) acquire(ctx context.Context, name string, timeout time.Duration) (namedLock, error) {
+	unlock, err := l.locks.Lock(ctx, name, timeout)
+	if errors.Is(err, storage.ErrLockTimeout) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return localLock(unlock), nil
+}
+
+type localLock func()
+
+func (l localLock) held(ctx context.Context) bool {
+	return true
+}
+
+func (l localLock) release() {
+	l()
+}
@@ -0,0 +1,713 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ErrSchemaTooNew is returned if the database has been migrated by a newer version of the connector
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// ErrMigrationsPending is returned if migrations known to this binary have not been applied yet
+var ErrMigrationsPending = errors.New("database migrations are pending")
+
+// migrationLockTimeout is the maximum time to wait for migrations run by another replica
+const migrationLockTimeout = 5 * time.Minute
+
+// SchemaMigration records an applied migration
+type SchemaMigration struct {
+	Version     uint `gorm:"primaryKey;autoIncrement:false"`
+	Description string
+	AppliedAt   time.Time
+}
+
+// migration is a single step of the schema. Migrations are applied in order of their version,
+// each within its own transaction. MySQL commits DDL statements implicitly, so migrations
+// should not mix DDL statements which may fail with data changes.
+// Migrations need to be idempotent. MySQL commits DDL statements implicitly, so a failed migration may
+// have been applied partially and is applied again by the next run.
+type migration struct {
+	version     uint
+	description string
+	up          func(d *DB, tx *gorm.DB) error
+	// down reverts up, it is nil if the migration can't be reverted
+	down func(d *DB, tx *gorm.DB) error
+}
+
+var migrations = []migration{
+	{
+		version:     1,
+		description: "initial schema",
+		up:          (*DB).createInitialSchema,
+		down:        (*DB).dropInitialSchema,
+	},
+	{
+		version:     2,
+		description: "namespace legacy decoder state",
+		up:          (*DB).migrateLegacyState,
+		// Namespaced state is kept, it is dropped together with the tables when reverting to version 0
+		down: func(d *DB, tx *gorm.DB) error { return nil },
+	},
+	{
+		version:     3,
+		description: "decoder configs keyed by instance and application",
+		up:          (*DB).keyDecoderConfigsByInstance,
+		down:        (*DB).keyDecoderConfigsByApplication,
+	},
+	{
+		version:     4,
+		description: "cache invalidations",
+		up:          (*DB).createCacheInvalidations,
+		down:        (*DB).dropCacheInvalidations,
+	},
+	{
+		version:     5,
+		description: "event archive",
+		up:          (*DB).createEventArchive,
+		down:        (*DB).dropEventArchive,
+	},
+	{
+		version:     6,
+		description: "property updates of archived events",
+		up:          (*DB).addArchivedUpdates,
+		down:        (*DB).dropArchivedUpdates,
+	},
+	{
+		version:     7,
+		description: "model version of things created before thing models were versioned",
+		up:          (*DB).backfillModelVersions,
+		// The backfilled versions are the versions of the things, so they are kept
+		down: func(d *DB, tx *gorm.DB) error { return nil },
+	},
+	{
+		version:     8,
+		description: "instance state without default",
+		up:          (*DB).dropInstanceStateDefault,
+		down:        (*DB).restoreInstanceStateDefault,
+	},
+	{
+		version:     9,
+		description: "decoder of mappings created before decoder names were stored",
+		up:          (*DB).backfillDecoderNames,
+		// The backfilled decoders are the decoders of the things, so they are kept
+		down: func(d *DB, tx *gorm.DB) error { return nil },
+	},
+	{
+		version:     10,
+		description: "unique device mappings per instance",
+		up:          (*DB).addUniqueDeviceIndex,
+		down:        (*DB).dropUniqueDeviceIndex,
+	},
+}
+
+// LatestSchemaVersion returns the version of the last migration known to this binary
+func LatestSchemaVersion() uint {
+	return migrations[len(migrations)-1].version
+}
+
+// MigrationStatus describes a migration and whether it has been applied
+type MigrationStatus struct {
+	Version     uint
+	Description string
+	// AppliedAt is nil if the migration is pending
+	AppliedAt *time.Time
+	// Unknown is set if the migration has been applied by a newer version of the connector
+	Unknown bool
+}
+
+// CreateOrMigrate applies all pending migrations. It fails with ErrSchemaTooNew if the database has
+// been migrated by a newer version of the connector.
+func (d *DB) CreateOrMigrate() error {
+	return d.MigrateUp(context.Background())
+}
+
+// MigrateUp applies all pending migrations. Replicas starting at the same time wait for each other.
+func (d *DB) MigrateUp(ctx context.Context) error {
+	return d.withMigrationLock(ctx, func(applied map[uint]SchemaMigration) error {
+		for _, m := range migrations {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+			logger := d.logger.WithFields(logrus.Fields{
+				"version":     m.version,
+				"description": m.description,
+			})
+			logger.Info("Applying migration")
+			err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				if err := m.up(d, tx); err != nil {
+					return err
+				}
+				return tx.Create(&SchemaMigration{
+					Version:     m.version,
+					Description: m.description,
+					AppliedAt:   time.Now(),
+				}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.description, err)
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts all applied migrations newer than version. Reverting to version 0 drops all tables.
+// Reverting to version 1 keeps decoder state namespaced, which connectors older than version 2 don't read.
+func (d *DB) MigrateDown(ctx context.Context, version uint) error {
+	return d.withMigrationLock(ctx, func(applied map[uint]SchemaMigration) error {
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if m.version <= version {
+				break
+			}
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+			if m.down == nil {
+				return fmt.Errorf("migration %d (%s) can't be reverted", m.version, m.description)
+			}
+			logger := d.logger.WithFields(logrus.Fields{
+				"version":     m.version,
+				"description": m.description,
+			})
+			logger.Info("Reverting migration")
+			err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				if err := m.down(d, tx); err != nil {
+					return err
+				}
+				return tx.Delete(&SchemaMigration{Version: m.version}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("failed to revert migration %d (%s): %w", m.version, m.description, err)
+			}
+		}
+		return nil
+	})
+}
+
+// MigrationStatus lists all migrations known to this binary and all migrations applied to the database
+func (d *DB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := d.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var status []MigrationStatus
+	for _, m := range migrations {
+		s := MigrationStatus{
+			Version:     m.version,
+			Description: m.description,
+		}
+		if a, ok := applied[m.version]; ok {
+			s.AppliedAt = &a.AppliedAt
+			delete(applied, m.version)
+		}
+		status = append(status, s)
+	}
+	for _, version := range unknownVersions(applied) {
+		a := applied[version]
+		status = append(status, MigrationStatus{
+			Version:     a.Version,
+			Description: a.Description,
+			AppliedAt:   &a.AppliedAt,
+			Unknown:     true,
+		})
+	}
+	return status, nil
+}
+
+// CheckSchema fails with ErrMigrationsPending if migrations known to this binary have not been
+// applied and with ErrSchemaTooNew if the database has been migrated by a newer version
+func (d *DB) CheckSchema(ctx context.Context) error {
+	applied, err := d.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if unknown := unknownVersions(applied); len(unknown) > 0 {
+		return fmt.Errorf("%w: database is at version %d, this binary knows up to version %d", ErrSchemaTooNew, unknown[len(unknown)-1], LatestSchemaVersion())
+	}
+	var pending []uint
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; !ok {
+			pending = append(pending, m.version)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: versions %v", ErrMigrationsPending, pending)
+	}
+	return nil
+}
+
+// withMigrationLock runs f while holding the migration lock. It refuses to run f if the database
+// contains migrations unknown to this binary.
+func (d *DB) withMigrationLock(ctx context.Context, f func(applied map[uint]SchemaMigration) error) error {
+	l, err := d.locker.acquire(ctx, "schema-migrations", migrationLockTimeout)
+	if err != nil {
+		return err
+	}
+	if l == nil {
+		return errors.New("timed out waiting for migrations of another replica")
+	}
+	defer l.release()
+
+	if err := d.db.WithContext(ctx).AutoMigrate(&SchemaMigration{}); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+	applied, err := d.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if unknown := unknownVersions(applied); len(unknown) > 0 {
+		return fmt.Errorf("%w: database is at version %d, this binary knows up to version %d", ErrSchemaTooNew, unknown[len(unknown)-1], LatestSchemaVersion())
+	}
+	return f(applied)
+}
+
+// appliedMigrations returns the applied migrations by version
+func (d *DB) appliedMigrations(ctx context.Context) (map[uint]SchemaMigration, error) {
+	applied := map[uint]SchemaMigration{}
+	if !d.db.WithContext(ctx).Migrator().HasTable(&SchemaMigration{}) {
+		return applied, nil
+	}
+	var records []SchemaMigration
+	if err := d.db.WithContext(ctx).Order("version").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// unknownVersions returns the sorted versions of applied migrations which are unknown to this binary
+func unknownVersions(applied map[uint]SchemaMigration) []uint {
+	var unknown []uint
+	for version := range applied {
+		if version > LatestSchemaVersion() {
+			unknown = append(unknown, version)
+		}
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i] < unknown[j] })
+	return unknown
+}
+
+// The initial schema is the schema created by AutoMigrate before migrations were versioned. The models
+// are frozen copies, so that changes to the current models don't change the initial schema. Databases
+// created by previous versions are brought to the same state.
+
+type v1Installation struct {
+	gorm.Model
+	ID            string `gorm:"primaryKey;size:36"`
+	Token         string
+	Configuration string `gorm:"type:text"`
+}
+
+func (v1Installation) TableName() string { return "installations" }
+
+type v1Instance struct {
+	gorm.Model
+	ID                 string `gorm:"primaryKey;size:36"`
+	Token              string
+	InstallationID     string          `gorm:"REFERENCES installations(id);size:36"`
+	Installation       *v1Installation `gorm:"foreignKey:InstallationID;AssociationForeignKey:ID"`
+	ConfigThingID      string          `gorm:"uniqueIndex;size:36"`
+	ProvisioningPolicy string          `gorm:"size:32;default:recreate"`
+	NetworkServer      string          `gorm:"size:32;default:chirpstack"`
+	NetworkServerURL   string
+	NetworkServerKey   string
+	DefaultDecoder     string                       `gorm:"size:64"`
+	Timezone           string                       `gorm:"size:64;default:UTC"`
+	State              connector.InstantiationState `gorm:"default:2"`
+	LastEventAt        *time.Time
+}
+
+func (v1Instance) TableName() string { return "instances" }
+
+type v1IDMapping struct {
+	gorm.Model
+	DevEUI          []byte      `gorm:"primaryKey;size:8"`
+	ThingID         string      `gorm:"uniqueIndex;size:36"`
+	InstanceID      string      `gorm:"REFERENCES instances(id);size:36"`
+	Instance        *v1Instance `gorm:"foreignKey:InstanceID;AssociationForeignKey:ID"`
+	Ignored         bool
+	DecoderName     string `gorm:"index:idx_id_mappings_model;size:64"`
+	ModelVersion    uint   `gorm:"index:idx_id_mappings_model;default:1"`
+	LastUplinkAt    *time.Time
+	LearnedInterval time.Duration
+	Offline         bool
+}
+
+func (v1IDMapping) TableName() string { return "id_mappings" }
+
+type v1DecoderConfig struct {
+	ApplicationID uint64 `gorm:"primaryKey"`
+	DecoderName   string
+	InstanceID    string      `gorm:"REFERENCES instances(id);size:36"`
+	Instance      *v1Instance `gorm:"foreignKey:InstanceID;AssociationForeignKey:ID"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeletedAt     gorm.DeletedAt `gorm:"index"`
+}
+
+func (v1DecoderConfig) TableName() string { return "decoder_configs" }
+
+type v1DecoderState struct {
+	gorm.Model
+	ThingID string `gorm:"primaryKey;size:36;uniqueIndex:idx_decoder_states_thing_key"`
+	Key     string `gorm:"primaryKey;size:36;uniqueIndex:idx_decoder_states_thing_key"`
+	Value   []byte
+}
+
+func (v1DecoderState) TableName() string { return "decoder_states" }
+
+func (d *DB) createInitialSchema(tx *gorm.DB) error {
+	if err := d.dedupeState(tx); err != nil {
+		return fmt.Errorf("failed to deduplicate decoder state: %w", err)
+	}
+	for _, model := range []interface{}{
+		&v1Installation{},
+		&v1Instance{},
+		&v1IDMapping{},
+		&v1DecoderConfig{},
+		&v1DecoderState{},
+	} {
+		if err := tx.AutoMigrate(model); err != nil {
+			return fmt.Errorf("failed to create %T table: %w", model, err)
+		}
+	}
+	return nil
+}
+
+func (d *DB) dropInitialSchema(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&v1DecoderState{}, &v1DecoderConfig{}, &v1IDMapping{}, &v1Instance{}, &v1Installation{})
+}
+
+// keyDecoderConfigsByInstance changes the primary key of decoder configs from the application ID alone to
+// instance and application ID, since instances using different network servers may share application IDs
+func (d *DB) keyDecoderConfigsByInstance(tx *gorm.DB) error {
+	if keyed, err := d.primaryKeyHasColumn(tx, "decoder_configs", "instance_id"); err != nil || keyed {
+		return err
+	}
+	// Configs without instance have never been used, the instance is required for the lookup
+	if err := tx.Exec("DELETE FROM decoder_configs WHERE instance_id IS NULL").Error; err != nil {
+		return err
+	}
+	switch d.dialect {
+	case DialectMySQL:
+		// The auto increment has to be removed before the primary key can be dropped
+		return tx.Exec("ALTER TABLE decoder_configs " +
+			"MODIFY application_id bigint unsigned NOT NULL, " +
+			"MODIFY instance_id varchar(36) NOT NULL, " +
+			"DROP PRIMARY KEY, " +
+			"ADD PRIMARY KEY (instance_id, application_id)").Error
+	case DialectPostgres:
+		return tx.Exec("ALTER TABLE decoder_configs " +
+			"ALTER COLUMN application_id DROP DEFAULT, " +
+			"ALTER COLUMN instance_id SET NOT NULL, " +
+			"DROP CONSTRAINT decoder_configs_pkey, " +
+			"ADD PRIMARY KEY (instance_id, application_id)").Error
+	default:
+		return rebuildSQLiteTable(tx, "decoder_configs", "instance_id,application_id,decoder_name,created_at,updated_at,deleted_at",
+			"CREATE TABLE `decoder_configs` (`instance_id` text NOT NULL,`application_id` integer NOT NULL,`decoder_name` text,"+
+				"`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,"+
+				"PRIMARY KEY (`instance_id`,`application_id`),"+
+				"CONSTRAINT `fk_decoder_configs_instance` FOREIGN KEY (`instance_id`) REFERENCES `instances`(`id`))",
+			"CREATE INDEX `idx_decoder_configs_deleted_at` ON `decoder_configs`(`deleted_at`)")
+	}
+}
+
+// keyDecoderConfigsByApplication reverts keyDecoderConfigsByInstance. It fails if several instances map
+// the same application. Application IDs are always set explicitly, so the auto increment is not restored.
+func (d *DB) keyDecoderConfigsByApplication(tx *gorm.DB) error {
+	if keyed, err := d.primaryKeyHasColumn(tx, "decoder_configs", "instance_id"); err != nil || !keyed {
+		return err
+	}
+	var shared []uint64
+	err := tx.Table("decoder_configs").Select("application_id").Group("application_id").Having("COUNT(*) > 1").Scan(&shared).Error
+	if err != nil {
+		return err
+	}
+	if len(shared) > 0 {
+		return fmt.Errorf("applications %v are mapped by several instances", shared)
+	}
+	switch d.dialect {
+	case DialectMySQL:
+		return tx.Exec("ALTER TABLE decoder_configs " +
+			"MODIFY instance_id varchar(36) NULL, " +
+			"DROP PRIMARY KEY, " +
+			"ADD PRIMARY KEY (application_id)").Error
+	case DialectPostgres:
+		return tx.Exec("ALTER TABLE decoder_configs " +
+			"ALTER COLUMN instance_id DROP NOT NULL, " +
+			"DROP CONSTRAINT decoder_configs_pkey, " +
+			"ADD PRIMARY KEY (application_id)").Error
+	default:
+		return rebuildSQLiteTable(tx, "decoder_configs", "application_id,decoder_name,instance_id,created_at,updated_at,deleted_at",
+			"CREATE TABLE `decoder_configs` (`application_id` integer,`decoder_name` text,`instance_id` text,"+
+				"`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,"+
+				"PRIMARY KEY (`application_id`),"+
+				"CONSTRAINT `fk_decoder_configs_instance` FOREIGN KEY (`instance_id`) REFERENCES `instances`(`id`))",
+			"CREATE INDEX `idx_decoder_configs_deleted_at` ON `decoder_configs`(`deleted_at`)")
+	}
+}
+
+// primaryKeyHasColumn reports whether the column is part of the primary key of the table
+func (d *DB) primaryKeyHasColumn(tx *gorm.DB, table, column string) (bool, error) {
+	var count int64
+	var err error
+	switch d.dialect {
+	case DialectMySQL:
+		err = tx.Raw("SELECT COUNT(*) FROM information_schema.key_column_usage "+
+			"WHERE table_schema = DATABASE() AND table_name = ? AND constraint_name = 'PRIMARY' AND column_name = ?", table, column).Scan(&count).Error
+	case DialectPostgres:
+		err = tx.Raw("SELECT COUNT(*) FROM information_schema.key_column_usage k "+
+			"JOIN information_schema.table_constraints c ON c.constraint_name = k.constraint_name AND c.table_schema = k.table_schema "+
+			"WHERE k.table_schema = CURRENT_SCHEMA() AND k.table_name = ? AND c.constraint_type = 'PRIMARY KEY' AND k.column_name = ?", table, column).Scan(&count).Error
+	default:
+		err = tx.Raw("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ? AND pk > 0", table, column).Scan(&count).Error
+	}
+	return count > 0, err
+}
+
+// rebuildSQLiteTable replaces a table by a table created by createSQL and copies the given columns.
+// SQLite can't alter primary keys or constraints of existing tables.
+func rebuildSQLiteTable(tx *gorm.DB, table, columns, createSQL string, indexSQL ...string) error {
+	statements := []string{
+		fmt.Sprintf("ALTER TABLE `%s` RENAME TO `%s_old`", table, table),
+		createSQL,
+		fmt.Sprintf("INSERT INTO `%s` (%s) SELECT %s FROM `%s_old`", table, columns, columns, table),
+		fmt.Sprintf("DROP TABLE `%s_old`", table),
+	}
+	for _, statement := range append(statements, indexSQL...) {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type v4CacheInvalidation struct {
+	ID        uint64    `gorm:"primaryKey"`
+	CacheKey  string    `gorm:"size:255"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (v4CacheInvalidation) TableName() string { return "cache_invalidations" }
+
+func (d *DB) createCacheInvalidations(tx *gorm.DB) error {
+	if tx.Migrator().HasTable(&v4CacheInvalidation{}) {
+		return nil
+	}
+	return tx.Migrator().CreateTable(&v4CacheInvalidation{})
+}
+
+func (d *DB) dropCacheInvalidations(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&v4CacheInvalidation{})
+}
+
+type v5ArchivedEvent struct {
+	ID         uint64    `gorm:"primaryKey"`
+	InstanceID string    `gorm:"size:36;index:idx_archived_events_instance_received"`
+	ReceivedAt time.Time `gorm:"index:idx_archived_events_instance_received"`
+	Event      string    `gorm:"size:64"`
+	Encoding   string    `gorm:"size:16"`
+	Body       []byte    `gorm:"size:1048576"`
+	DevEUI     []byte    `gorm:"size:8;index"`
+	FCnt       uint32
+	Outcome    string `gorm:"size:16"`
+	Error      string `gorm:"type:text"`
+}
+
+func (v5ArchivedEvent) TableName() string { return "archived_events" }
+
+// v5Instance adds the archive retention to instances, existing instances get the default retention
+type v5Instance struct {
+	ArchiveRetentionDays int `gorm:"not null;default:30"`
+}
+
+func (v5Instance) TableName() string { return "instances" }
+
+func (d *DB) createEventArchive(tx *gorm.DB) error {
+	if !tx.Migrator().HasTable(&v5ArchivedEvent{}) {
+		if err := tx.Migrator().CreateTable(&v5ArchivedEvent{}); err != nil {
+			return err
+		}
+	}
+	if tx.Migrator().HasColumn(&v5Instance{}, "ArchiveRetentionDays") {
+		return nil
+	}
+	return tx.Migrator().AddColumn(&v5Instance{}, "ArchiveRetentionDays")
+}
+
+func (d *DB) dropEventArchive(tx *gorm.DB) error {
+	if tx.Migrator().HasColumn(&v5Instance{}, "ArchiveRetentionDays") {
+		if err := tx.Migrator().DropColumn(&v5Instance{}, "ArchiveRetentionDays"); err != nil {
+			return err
+		}
+	}
+	return tx.Migrator().DropTable(&v5ArchivedEvent{})
+}
+
+// v6ArchivedEvent adds the property updates pushed for an event, which replays compare against
+type v6ArchivedEvent struct {
+	Updates string `gorm:"type:text"`
+}
+
+func (v6ArchivedEvent) TableName() string { return "archived_events" }
+
+func (d *DB) addArchivedUpdates(tx *gorm.DB) error {
+	if tx.Migrator().HasColumn(&v6ArchivedEvent{}, "Updates") {
+		return nil
+	}
+	return tx.Migrator().AddColumn(&v6ArchivedEvent{}, "Updates")
+}
+
+func (d *DB) dropArchivedUpdates(tx *gorm.DB) error {
+	if !tx.Migrator().HasColumn(&v6ArchivedEvent{}, "Updates") {
+		return nil
+	}
+	return tx.Migrator().DropColumn(&v6ArchivedEvent{}, "Updates")
+}
+
+// backfillModelVersions records the model version of things created before thing models were versioned.
+// Their mappings got the default version 1, which would make MigrateThings replace things which already
+// have the current model. Mappings created since are at least at this version, so they are unaffected.
+func (d *DB) backfillModelVersions(tx *gorm.DB) error {
+	for _, name := range decoder.Names() {
+		version := lorawan.UnversionedModelVersion(name)
+		err := tx.Table("id_mappings").Where("decoder_name = ? AND model_version < ?", name, version).Update("model_version", version).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropInstanceStateDefault drops the default of the instance state, which hid a connector.InstantiationState
+// value in the schema. Instances are created with an explicit state. SQLite can't drop defaults without
+// rebuilding the table, which other tables reference, so its default is kept but never used.
+func (d *DB) dropInstanceStateDefault(tx *gorm.DB) error {
+	if d.dialect == DialectSQLite {
+		return nil
+	}
+	return tx.Exec("ALTER TABLE instances ALTER COLUMN state DROP DEFAULT").Error
+}
+
+func (d *DB) restoreInstanceStateDefault(tx *gorm.DB) error {
+	if d.dialect == DialectSQLite {
+		return nil
+	}
+	// DDL statements can't take bind parameters in PostgreSQL
+	return tx.Exec(fmt.Sprintf("ALTER TABLE instances ALTER COLUMN state SET DEFAULT %d", connector.InstantiationStateComplete)).Error
+}
+
+// backfillDecoderNames records the decoder of mappings created before decoder names were stored, so that
+// actions and reprovisioning work before the device sends its next uplink. Mappings don't know the
+// application of their device, so the decoder is only backfilled if the instance maps all applications
+// and its default to the same decoder. The remaining mappings learn their decoder with the next uplink.
+func (d *DB) backfillDecoderNames(tx *gorm.DB) error {
+	legacy := "decoder_name IS NULL OR decoder_name = ''"
+	var instanceIDs []string
+	if err := tx.Table("id_mappings").Where(legacy).Distinct().Pluck("instance_id", &instanceIDs).Error; err != nil {
+		return err
+	}
+	for _, instanceID := range instanceIDs {
+		var names []string
+		err := tx.Table("decoder_configs").Where("instance_id = ? AND deleted_at IS NULL", instanceID).Distinct().Pluck("decoder_name", &names).Error
+		if err != nil {
+			return err
+		}
+		var defaultDecoder []string
+		if err := tx.Table("instances").Where("id = ?", instanceID).Pluck("default_decoder", &defaultDecoder).Error; err != nil {
+			return err
+		}
+		decoders := map[string]bool{}
+		for _, name := range append(names, defaultDecoder...) {
+			if name != "" {
+				decoders[name] = true
+			}
+		}
+		if len(decoders) != 1 {
+			d.logger.WithFields(logrus.Fields{
+				"instanceId": instanceID,
+				"decoders":   len(decoders),
+			}).Warn("Decoder of legacy device mappings is ambiguous, it is recorded with their next uplink")
+			continue
+		}
+		for name := range decoders {
+			err := tx.Table("id_mappings").Where("instance_id = ?", instanceID).Where(legacy).Updates(map[string]interface{}{
+				"decoder_name":  name,
+				"model_version": lorawan.UnversionedModelVersion(name),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// v10IDMapping adds a unique index on the device of a mapping, so that storing a mapping fails if the
+// device has been provisioned concurrently
+type v10IDMapping struct {
+	DevEUI     []byte `gorm:"size:8;uniqueIndex:idx_id_mappings_instance_device,priority:2"`
+	InstanceID string `gorm:"size:36;uniqueIndex:idx_id_mappings_instance_device,priority:1"`
+}
+
+func (v10IDMapping) TableName() string { return "id_mappings" }
+
+func (d *DB) addUniqueDeviceIndex(tx *gorm.DB) error {
+	if tx.Migrator().HasIndex(&v10IDMapping{}, "idx_id_mappings_instance_device") {
+		return nil
+	}
+	if err := d.dedupeDeviceMappings(tx); err != nil {
+		return fmt.Errorf("failed to deduplicate device mappings: %w", err)
+	}
+	return tx.Migrator().CreateIndex(&v10IDMapping{}, "idx_id_mappings_instance_device")
+}
+
+func (d *DB) dropUniqueDeviceIndex(tx *gorm.DB) error {
+	if !tx.Migrator().HasIndex(&v10IDMapping{}, "idx_id_mappings_instance_device") {
+		return nil
+	}
+	return tx.Migrator().DropIndex(&v10IDMapping{}, "idx_id_mappings_instance_device")
+}
+
+// dedupeDeviceMappings keeps the first mapping of every device. Later mappings have been stored by
+// concurrent provisioning, their things are orphaned in connctd and are logged, so that they can be
+// deleted by hand.
+func (d *DB) dedupeDeviceMappings(tx *gorm.DB) error {
+	if err := tx.Unscoped().Where("deleted_at IS NOT NULL").Delete(&IDMapping{}).Error; err != nil {
+		return err
+	}
+	var mappings []IDMapping
+	if err := tx.Select("id", "instance_id", "dev_e_ui", "thing_id").Where("instance_id IS NOT NULL").Order("id").Find(&mappings).Error; err != nil {
+		return err
+	}
+	first := map[[2]string]bool{}
+	var duplicates []uint
+	for _, m := range mappings {
+		k := [2]string{m.InstanceID, string(m.DevEUI)}
+		if first[k] {
+			duplicates = append(duplicates, m.ID)
+			d.logger.WithFields(logrus.Fields{
+				"instanceId": m.InstanceID,
+				"deviceEui":  fmt.Sprintf("%x", m.DevEUI),
+				"thingId":    m.ThingID,
+			}).Warn("Deleting duplicate device mapping, its thing is orphaned in connctd")
+		}
+		first[k] = true
+	}
+	if len(duplicates) == 0 {
+		return nil
+	}
+	return tx.Unscoped().Where("id IN ?", duplicates).Delete(&IDMapping{}).Error
+}
@@ -0,0 +1,187 @@
+package sqlstore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	_ "github.com/connctd/lora-connector/lorawan/decoder/ldds75"
+	"github.com/connctd/lora-connector/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func openSQLite(t *testing.T) *DB {
+	return openSQLiteFile(t, filepath.Join(t.TempDir(), "lora.db"), nil, nil)
+}
+
+func openSQLiteFile(t *testing.T, path string, client connector.Client, keyring *secrets.Keyring) *DB {
+	d, err := NewDB("sqlite://"+path, client, "connector.example.com", keyring)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		d.Close()
+	})
+	return d
+}
+
+func createInstances(t *testing.T, d *DB, ids ...string) {
+	require.NoError(t, d.db.Create(&v1Installation{ID: "installation"}).Error)
+	for _, id := range ids {
+		require.NoError(t, d.db.Create(&v1Instance{ID: id, InstallationID: "installation", ConfigThingID: "config-" + id}).Error)
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	ctx := context.Background()
+	d := openSQLite(t)
+	assert.ErrorIs(t, d.CheckSchema(ctx), ErrMigrationsPending)
+	require.NoError(t, d.MigrateUp(ctx))
+	// Applying migrations again is a no-op
+	require.NoError(t, d.CreateOrMigrate())
+	assert.NoError(t, d.CheckSchema(ctx))
+
+	status, err := d.MigrationStatus(ctx)
+	require.NoError(t, err)
+	require.Len(t, status, len(migrations))
+	for _, s := range status {
+		assert.NotNil(t, s.AppliedAt, "migration %d", s.Version)
+		assert.False(t, s.Unknown)
+	}
+
+	createInstances(t, d, "instance1", "instance2")
+	require.NoError(t, d.db.Create(&DecoderConfig{InstanceID: "instance1", ApplicationID: 1, DecoderName: "ldds75"}).Error)
+	require.NoError(t, d.db.Create(&DecoderConfig{InstanceID: "instance2", ApplicationID: 1, DecoderName: "dcl571"}).Error)
+
+	// Both instances map application 1, so decoder configs can't be keyed by application alone
+	require.Error(t, d.MigrateDown(ctx, 2))
+	require.NoError(t, d.db.Unscoped().Where("instance_id = ?", "instance2").Delete(&DecoderConfig{}).Error)
+	require.NoError(t, d.MigrateDown(ctx, 2))
+
+	status, err = d.MigrationStatus(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, status[2].AppliedAt)
+	assert.ErrorIs(t, d.CheckSchema(ctx), ErrMigrationsPending)
+
+	require.NoError(t, d.MigrateUp(ctx))
+	name, err := d.DecoderNameForApp(context.Background(), "instance1", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "ldds75", name)
+
+	require.NoError(t, d.MigrateDown(ctx, 0))
+	assert.False(t, d.db.Migrator().HasTable("instances"))
+	require.NoError(t, d.MigrateUp(ctx))
+	assert.NoError(t, d.CheckSchema(ctx))
+}
+
+// TestMigrationsIdempotent applies every migration again, as happens on MySQL after a migration failed
+// behind an implicitly committed DDL statement
+func TestMigrationsIdempotent(t *testing.T) {
+	d := openSQLite(t)
+	require.NoError(t, d.MigrateUp(context.Background()))
+	for _, m := range migrations {
+		require.NoError(t, d.db.Transaction(func(tx *gorm.DB) error { return m.up(d, tx) }), "migration %d", m.version)
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		require.NoError(t, d.db.Transaction(func(tx *gorm.DB) error {
+			if err := m.down(d, tx); err != nil {
+				return err
+			}
+			return m.down(d, tx)
+		}), "migration %d", m.version)
+	}
+}
+
+func TestSchemaTooNew(t *testing.T) {
+	ctx := context.Background()
+	d := openSQLite(t)
+	require.NoError(t, d.MigrateUp(ctx))
+	require.NoError(t, d.db.Create(&SchemaMigration{Version: LatestSchemaVersion() + 1, Description: "from the future"}).Error)
+
+	assert.ErrorIs(t, d.CreateOrMigrate(), ErrSchemaTooNew)
+	assert.ErrorIs(t, d.MigrateDown(ctx, 0), ErrSchemaTooNew)
+	assert.ErrorIs(t, d.CheckSchema(ctx), ErrSchemaTooNew)
+
+	status, err := d.MigrationStatus(ctx)
+	require.NoError(t, err)
+	last := status[len(status)-1]
+	assert.Equal(t, LatestSchemaVersion()+1, last.Version)
+	assert.True(t, last.Unknown)
+}
+
+// TestMigrateUnversionedDatabase migrates a database created by AutoMigrate before migrations were versioned
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	d := openSQLite(t)
+	require.NoError(t, d.createInitialSchema(d.db))
+	createInstances(t, d, "instance1", "instance2")
+	require.NoError(t, d.db.Create(&v1DecoderConfig{InstanceID: "instance1", ApplicationID: 1, DecoderName: "ldds75"}).Error)
+	require.NoError(t, d.db.Create(&DecoderState{ThingID: "thing", Key: "mountingHeight", Value: []byte{0xc0, 0x3e}}).Error)
+	// The decoder of the mapping has been recorded by an uplink before model versions were backfilled
+	require.NoError(t, d.db.Create(&v1IDMapping{DevEUI: []byte{1}, ThingID: "thing", InstanceID: "instance1", DecoderName: "ldds75"}).Error)
+	// Mappings created before decoder names were stored
+	require.NoError(t, d.db.Create(&v1IDMapping{DevEUI: []byte{2}, ThingID: "thing2", InstanceID: "instance1"}).Error)
+	require.NoError(t, d.db.Create(&v1IDMapping{DevEUI: []byte{3}, ThingID: "thing3", InstanceID: "instance2"}).Error)
+
+	require.NoError(t, d.CreateOrMigrate())
+
+	mapping, err := d.GetDeviceMapping(context.Background(), "instance1", []byte{1})
+	require.NoError(t, err)
+	require.NotNil(t, mapping)
+	assert.Equal(t, lorawan.UnversionedModelVersion("ldds75"), mapping.ModelVersion)
+
+	// The only decoder of the instance is backfilled, instances without decoder keep the mapping as is
+	mapping, err = d.GetDeviceMapping(context.Background(), "instance1", []byte{2})
+	require.NoError(t, err)
+	require.NotNil(t, mapping)
+	assert.Equal(t, "ldds75", mapping.DecoderName)
+	assert.Equal(t, lorawan.UnversionedModelVersion("ldds75"), mapping.ModelVersion)
+	mapping, err = d.GetDeviceMapping(context.Background(), "instance2", []byte{3})
+	require.NoError(t, err)
+	require.NotNil(t, mapping)
+	assert.Empty(t, mapping.DecoderName)
+
+	value, err := d.GetState(context.Background(), "thing", decoder.StateKey("ldds75", "mountingHeight"))
+	require.NoError(t, err)
+	assert.Equal(t, "400", string(value))
+	_, err = d.GetState(context.Background(), "thing", "mountingHeight")
+	assert.ErrorIs(t, err, decoder.ErrStateNotFound)
+
+	// Instances may share application IDs now
+	require.NoError(t, d.db.Create(&DecoderConfig{InstanceID: "instance2", ApplicationID: 1, DecoderName: "dcl571"}).Error)
+	name, err := d.DecoderNameForApp(context.Background(), "instance1", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "ldds75", name)
+	name, err = d.DecoderNameForApp(context.Background(), "instance2", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "dcl571", name)
+}
+
+// TestDedupeDeviceMappings keeps the first mapping of devices which have been provisioned concurrently
+func TestDedupeDeviceMappings(t *testing.T) {
+	ctx := context.Background()
+	d := openSQLite(t)
+	require.NoError(t, d.createInitialSchema(d.db))
+	createInstances(t, d, "instance1", "instance2")
+	require.NoError(t, d.db.Create(&v1IDMapping{Model: gorm.Model{ID: 1}, DevEUI: []byte{1}, ThingID: "thing1", InstanceID: "instance1", DecoderName: "ldds75"}).Error)
+	require.NoError(t, d.db.Create(&v1IDMapping{Model: gorm.Model{ID: 2}, DevEUI: []byte{1}, ThingID: "thing2", InstanceID: "instance1", DecoderName: "ldds75"}).Error)
+	// The same device may be used by several instances
+	require.NoError(t, d.db.Create(&v1IDMapping{Model: gorm.Model{ID: 3}, DevEUI: []byte{1}, ThingID: "thing3", InstanceID: "instance2", DecoderName: "ldds75"}).Error)
+
+	require.NoError(t, d.MigrateUp(ctx))
+
+	mapping, err := d.GetDeviceMapping(ctx, "instance1", []byte{1})
+	require.NoError(t, err)
+	require.NotNil(t, mapping)
+	assert.Equal(t, "thing1", mapping.ThingID)
+	mapping, err = d.GetDeviceMapping(ctx, "instance2", []byte{1})
+	require.NoError(t, err)
+	require.NotNil(t, mapping)
+	assert.Equal(t, "thing3", mapping.ThingID)
+
+	err = d.StoreDeviceMapping(ctx, lorawan.DeviceMapping{InstanceID: "instance1", DevEUI: []byte{1}, ThingID: "thing4", DecoderName: "ldds75", ModelVersion: 2})
+	assert.Error(t, err)
+}
@@ -0,0 +1,137 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/connclient"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// RemoveInstallation removes all instances of the installation and the installation itself
+func (d *DB) RemoveInstallation(ctx context.Context, installationID string, dryRun bool) (*storage.RemovalReport, error) {
+	var installation Installation
+	err := d.db.WithContext(ctx).Model(&Installation{}).Where("id = ?", installationID).Take(&installation).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var instanceIDs []string
+	err = d.db.WithContext(ctx).Model(&Instance{}).Where("installation_id = ?", installationID).Pluck("id", &instanceIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	report := &storage.RemovalReport{
+		DryRun:        dryRun,
+		Installations: []string{installationID},
+	}
+	for _, instanceID := range instanceIDs {
+		instanceReport, err := d.RemoveInstance(ctx, instanceID, dryRun)
+		if err != nil {
+			return report, fmt.Errorf("failed to remove instance %s: %w", instanceID, err)
+		}
+		report.Add(instanceReport)
+	}
+	if dryRun {
+		return report, nil
+	}
+	return report, d.db.WithContext(ctx).Unscoped().Where("id = ?", installationID).Delete(&Installation{}).Error
+}
+
+// RemoveInstance deletes all things of the instance in connctd and removes the instance together with
+// its mappings, decoder configs and decoder state. Things which can't be deleted in connctd are reported,
+// but don't prevent the removal, since the instance token might already be revoked.
+func (d *DB) RemoveInstance(ctx context.Context, instanceID string, dryRun bool) (*storage.RemovalReport, error) {
+	logger := d.logger.WithFields(logrus.Fields{
+		"instanceId": instanceID,
+		"dryRun":     dryRun,
+	})
+	unlock, err := d.lock(ctx, "instance/"+instanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	db := d.db.WithContext(ctx)
+	var instance Instance
+	err = db.Model(&Instance{}).Where("id = ?", instanceID).Take(&instance).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := d.openInstance(&instance); err != nil {
+		return nil, err
+	}
+
+	var thingIDs []string
+	if err := db.Unscoped().Model(&IDMapping{}).Where("instance_id = ?", instanceID).Pluck("thing_id", &thingIDs).Error; err != nil {
+		return nil, err
+	}
+	var decoderConfigs, decoderStates, archivedEvents int64
+	if err := db.Unscoped().Model(&DecoderConfig{}).Where("instance_id = ?", instanceID).Count(&decoderConfigs).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&ArchivedEvent{}).Where("instance_id = ?", instanceID).Count(&archivedEvents).Error; err != nil {
+		return nil, err
+	}
+	if len(thingIDs) > 0 {
+		if err := db.Unscoped().Model(&DecoderState{}).Where("thing_id IN ?", thingIDs).Count(&decoderStates).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	report := &storage.RemovalReport{
+		DryRun:         dryRun,
+		Instances:      []string{instanceID},
+		Mappings:       len(thingIDs),
+		DecoderConfigs: int(decoderConfigs),
+		DecoderStates:  int(decoderStates),
+		ArchivedEvents: int(archivedEvents),
+	}
+	report.Things = append(report.Things, thingIDs...)
+	if instance.ConfigThingID != "" {
+		report.Things = append(report.Things, instance.ConfigThingID)
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	token := connector.InstantiationToken(instance.Token)
+	for _, thingID := range report.Things {
+		if err := d.connectorClient.DeleteThing(ctx, token, thingID); err != nil && !connclient.IsThingNotFound(err) {
+			logger.WithError(err).WithField("thingId", thingID).Warn("Failed to delete thing in connctd")
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to delete thing %s: %s", thingID, err))
+		}
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if len(thingIDs) > 0 {
+			if err := tx.Unscoped().Where("thing_id IN ?", thingIDs).Delete(&DecoderState{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Where("instance_id = ?", instanceID).Delete(&IDMapping{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("instance_id = ?", instanceID).Delete(&DecoderConfig{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("instance_id = ?", instanceID).Delete(&ArchivedEvent{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id = ?", instanceID).Delete(&Instance{}).Error
+	})
+	if err != nil {
+		return report, err
+	}
+	logger.Info("Removed instance")
+	return report, nil
+}
@@ -0,0 +1,116 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/connctd/lora-connector/secrets"
+)
+
+// Columns holding secrets, which are encrypted with the keyring of the DB. The encrypted values are
+// bound to their column and row, so they can't be copied to another instance.
+const (
+	columnInstallationToken = "installations.token"
+	columnInstanceToken     = "instances.token"
+	columnNetworkServerKey  = "instances.network_server_key"
+)
+
+func (d *DB) seal(column, id string, value *string) error {
+	sealed, err := d.keyring.Encrypt(*value, column+"/"+id)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s of %s: %w", column, id, err)
+	}
+	*value = sealed
+	return nil
+}
+
+func (d *DB) open(column, id string, value *string) error {
+	plain, err := d.keyring.Decrypt(*value, column+"/"+id)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s of %s: %w", column, id, err)
+	}
+	*value = plain
+	return nil
+}
+
+func (d *DB) sealInstallation(i *Installation) error {
+	return d.seal(columnInstallationToken, i.ID, &i.Token)
+}
+
+func (d *DB) openInstallation(i *Installation) error {
+	return d.open(columnInstallationToken, i.ID, &i.Token)
+}
+
+func (d *DB) sealInstance(i *Instance) error {
+	if err := d.seal(columnInstanceToken, i.ID, &i.Token); err != nil {
+		return err
+	}
+	return d.seal(columnNetworkServerKey, i.ID, &i.NetworkServerKey)
+}
+
+func (d *DB) openInstance(i *Instance) error {
+	if err := d.open(columnInstanceToken, i.ID, &i.Token); err != nil {
+		return err
+	}
+	return d.open(columnNetworkServerKey, i.ID, &i.NetworkServerKey)
+}
+
+// ReencryptSecrets encrypts all secrets which are stored as plain text or encrypted with an outdated key
+// with the primary key of the keyring. It fails with secrets.ErrNoKeys without keyring. Removed rows are
+// included. It returns the number of updated values.
+func (d *DB) ReencryptSecrets(ctx context.Context) (int, error) {
+	if d.keyring == nil {
+		return 0, secrets.ErrNoKeys
+	}
+	updated := 0
+	var installations []Installation
+	if err := d.db.WithContext(ctx).Unscoped().Select("id", "token").Find(&installations).Error; err != nil {
+		return updated, err
+	}
+	for _, installation := range installations {
+		n, err := d.reencrypt(ctx, &Installation{}, columnInstallationToken, "token", installation.ID, installation.Token)
+		updated += n
+		if err != nil {
+			return updated, err
+		}
+	}
+
+	var instances []Instance
+	if err := d.db.WithContext(ctx).Unscoped().Select("id", "token", "network_server_key").Find(&instances).Error; err != nil {
+		return updated, err
+	}
+	for _, instance := range instances {
+		n, err := d.reencrypt(ctx, &Instance{}, columnInstanceToken, "token", instance.ID, instance.Token)
+		updated += n
+		if err != nil {
+			return updated, err
+		}
+		n, err = d.reencrypt(ctx, &Instance{}, columnNetworkServerKey, "network_server_key", instance.ID, instance.NetworkServerKey)
+		updated += n
+		if err != nil {
+			return updated, err
+		}
+	}
+	return updated, nil
+}
+
+// reencrypt updates a single value, unless it has been changed since it was read
+func (d *DB) reencrypt(ctx context.Context, model interface{}, column, dbColumn, id, value string) (int, error) {
+	if !d.keyring.NeedsReencryption(value) {
+		return 0, nil
+	}
+	newValue := value
+	if err := d.open(column, id, &newValue); err != nil {
+		return 0, err
+	}
+	if err := d.seal(column, id, &newValue); err != nil {
+		return 0, err
+	}
+	result := d.db.WithContext(ctx).Unscoped().Model(model).
+		Where("id = ? AND "+dbColumn+" = ?", id, value).
+		UpdateColumn(dbColumn, newValue)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to update %s of %s: %w", column, id, result.Error)
+	}
+	return int(result.RowsAffected), nil
+}
@@ -0,0 +1,124 @@
+package sqlstore
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/mocks"
+	"github.com/connctd/lora-connector/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func keyring(t *testing.T, ids ...string) *secrets.Keyring {
+	var keys [][]byte
+	for _, id := range ids {
+		keys = append(keys, bytes.Repeat([]byte(id[:1]), secrets.KeySize))
+	}
+	k, err := secrets.NewKeyring(ids, keys)
+	require.NoError(t, err)
+	return k
+}
+
+func rawTokens(t *testing.T, d *DB) (string, string, string) {
+	var installation Installation
+	require.NoError(t, d.db.Take(&installation).Error)
+	var instance Instance
+	require.NoError(t, d.db.Take(&instance).Error)
+	return installation.Token, instance.Token, instance.NetworkServerKey
+}
+
+func TestSecretsAtRest(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "lora.db")
+	client := &mocks.Client{}
+	client.On("UpdateInstallationState", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	d := openSQLiteFile(t, path, client, keyring(t, "a"))
+	require.NoError(t, d.CreateOrMigrate())
+	require.NoError(t, d.AddInstallation(ctx, connector.InstallationRequest{ID: "installation", Token: "installationtoken"}))
+	// Instances stored before encryption was enabled
+	instance := &Instance{ID: "instance", InstallationID: "installation", Token: "instancetoken", ConfigThingID: "config", State: connector.InstantiationStateComplete}
+	instance.setConfig(lorawan.InstanceConfig{ChirpStackAPIKey: "apikey"})
+	require.NoError(t, d.db.Create(instance).Error)
+
+	installationToken, _, _ := rawTokens(t, d)
+	assert.True(t, strings.HasPrefix(installationToken, "aead:a:"))
+	token, err := d.GetInstanceToken(context.Background(), "instance")
+	require.NoError(t, err)
+	assert.EqualValues(t, "instancetoken", token)
+
+	updated, err := d.ReencryptSecrets(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, updated)
+	installationToken, instanceToken, apiKey := rawTokens(t, d)
+	for _, raw := range []string{installationToken, instanceToken, apiKey} {
+		assert.True(t, strings.HasPrefix(raw, "aead:a:"), raw)
+	}
+
+	// Rotate to key b, values encrypted with key a remain readable until they are re-encrypted
+	d = openSQLiteFile(t, path, client, keyring(t, "b", "a"))
+	config, err := d.GetInstanceConfig(context.Background(), "instance")
+	require.NoError(t, err)
+	assert.Equal(t, "apikey", config.ChirpStackAPIKey)
+	updated, err = d.ReencryptSecrets(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, updated)
+	installationToken, instanceToken, apiKey = rawTokens(t, d)
+	for _, raw := range []string{installationToken, instanceToken, apiKey} {
+		assert.True(t, strings.HasPrefix(raw, "aead:b:"), raw)
+	}
+
+	d = openSQLiteFile(t, path, client, keyring(t, "a"))
+	_, err = d.GetInstallationToken(context.Background(), "installation")
+	assert.ErrorIs(t, err, secrets.ErrUnknownKey)
+
+	d = openSQLiteFile(t, path, client, nil)
+	_, err = d.GetInstance(context.Background(), "instance")
+	assert.ErrorIs(t, err, secrets.ErrNoKeys)
+	_, err = d.ReencryptSecrets(ctx)
+	assert.ErrorIs(t, err, secrets.ErrNoKeys)
+}
+
+func TestActivitySkipsUnreadableInstances(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "lora.db")
+	client := &mocks.Client{}
+	client.On("UpdateInstallationState", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	devEUI := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	addInstance := func(d *DB, id string) {
+		instance := &Instance{ID: id, InstallationID: "installation", Token: id + "token", ConfigThingID: id + "config", State: connector.InstantiationStateComplete}
+		require.NoError(t, d.sealInstance(instance))
+		require.NoError(t, d.db.Create(instance).Error)
+		require.NoError(t, d.StoreDeviceMapping(ctx, lorawan.DeviceMapping{InstanceID: id, DevEUI: devEUI, ThingID: id + "thing", DecoderName: "ldds75", ModelVersion: 1}))
+		_, err := d.RecordUplink(ctx, id, devEUI, time.Now())
+		require.NoError(t, err)
+	}
+
+	d := openSQLiteFile(t, path, client, keyring(t, "a"))
+	require.NoError(t, d.CreateOrMigrate())
+	require.NoError(t, d.AddInstallation(ctx, connector.InstallationRequest{ID: "installation", Token: "installationtoken"}))
+	addInstance(d, "dropped")
+
+	// Key a has been dropped from the keyring without re-encrypting the secrets
+	d = openSQLiteFile(t, path, client, keyring(t, "b"))
+	addInstance(d, "readable")
+
+	instances, err := d.ListInstanceActivity(ctx)
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Equal(t, "readable", instances[0].InstanceID)
+	assert.EqualValues(t, "readabletoken", instances[0].Token)
+
+	devices, err := d.ListDeviceActivity(ctx)
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, "readablething", devices[0].ThingID)
+}
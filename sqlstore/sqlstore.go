@@ -0,0 +1,778 @@
+package sqlstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/monitor"
+	"github.com/connctd/lora-connector/secrets"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/connctd/restapi-go"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Installation struct {
+	gorm.Model
+	ID    string `gorm:"primaryKey;size:36"`
+	Token string
+	// Configuration holds the JSON encoded configuration parameters of the installation, which
+	// act as defaults for its instances
+	Configuration string `gorm:"type:text"`
+}
+
+type Instance struct {
+	gorm.Model
+	ID             string `gorm:"primaryKey;size:36"`
+	Token          string
+	InstallationID string        `gorm:"REFERENCES installations(id);size:36"`
+	Installation   *Installation `gorm:"foreignKey:InstallationID;AssociationForeignKey:ID"`
+	ConfigThingID  string        `gorm:"uniqueIndex;size:36"`
+	// ProvisioningPolicy defines what happens to devices whose things have been deleted in connctd
+	ProvisioningPolicy string `gorm:"size:32;default:recreate"`
+	NetworkServer      string `gorm:"size:32;default:chirpstack"`
+	NetworkServerURL   string
+	NetworkServerKey   string
+	DefaultDecoder     string `gorm:"size:64"`
+	Timezone           string `gorm:"size:64;default:UTC"`
+	// ArchiveRetentionDays has no default tag, since 0 disables the archive
+	ArchiveRetentionDays int
+	// State is the state last reported to connctd, it has no default and is always set on creation
+	State       connector.InstantiationState
+	LastEventAt *time.Time
+}
+
+func (i Instance) config() lorawan.InstanceConfig {
+	config := lorawan.InstanceConfig{
+		NetworkServer:        lorawan.NetworkServer(i.NetworkServer),
+		ChirpStackAPIURL:     i.NetworkServerURL,
+		ChirpStackAPIKey:     i.NetworkServerKey,
+		DefaultDecoder:       i.DefaultDecoder,
+		Timezone:             i.Timezone,
+		ProvisioningPolicy:   lorawan.ProvisioningPolicy(i.ProvisioningPolicy),
+		ArchiveRetentionDays: i.ArchiveRetentionDays,
+	}
+	// Instances created before the configuration was stored have no values
+	defaults := lorawan.DefaultInstanceConfig()
+	if config.NetworkServer == "" {
+		config.NetworkServer = defaults.NetworkServer
+	}
+	if config.Timezone == "" {
+		config.Timezone = defaults.Timezone
+	}
+	if config.ProvisioningPolicy == "" {
+		config.ProvisioningPolicy = defaults.ProvisioningPolicy
+	}
+	return config
+}
+
+// details describes the instance for operators, the secrets of the instance need to be opened
+func (i Instance) details() storage.Instance {
+	details := storage.Instance{
+		ID:             i.ID,
+		InstallationID: i.InstallationID,
+		State:          i.State,
+		CreatedAt:      i.CreatedAt,
+		Config:         i.config(),
+	}
+	if i.LastEventAt != nil {
+		details.LastEventAt = *i.LastEventAt
+	}
+	return details
+}
+
+func (i *Instance) setConfig(config lorawan.InstanceConfig) {
+	i.NetworkServer = string(config.NetworkServer)
+	i.NetworkServerURL = config.ChirpStackAPIURL
+	i.NetworkServerKey = config.ChirpStackAPIKey
+	i.DefaultDecoder = config.DefaultDecoder
+	i.Timezone = config.Timezone
+	i.ProvisioningPolicy = string(config.ProvisioningPolicy)
+	i.ArchiveRetentionDays = config.ArchiveRetentionDays
+}
+
+type IDMapping struct {
+	gorm.Model
+	DevEUI     []byte    `gorm:"primaryKey;size:8;uniqueIndex:idx_id_mappings_instance_device,priority:2"`
+	ThingID    string    `gorm:"uniqueIndex;size:36"`
+	InstanceID string    `gorm:"REFERENCES instances(id);size:36;uniqueIndex:idx_id_mappings_instance_device,priority:1"`
+	Instance   *Instance `gorm:"foreignKey:InstanceID;AssociationForeignKey:ID"`
+	// Ignored is set if the thing has been deleted in connctd and uplinks of this device should be dropped
+	Ignored bool
+	// DecoderName and ModelVersion describe the thing model the thing has been created with
+	DecoderName  string `gorm:"index:idx_id_mappings_model;size:64"`
+	ModelVersion uint   `gorm:"index:idx_id_mappings_model;default:1"`
+	// LastUplinkAt and LearnedInterval are used to detect devices which stopped reporting
+	LastUplinkAt    *time.Time
+	LearnedInterval time.Duration
+	Offline         bool
+}
+
+func (m IDMapping) deviceMapping() lorawan.DeviceMapping {
+	return lorawan.DeviceMapping{
+		InstanceID:   m.InstanceID,
+		DevEUI:       m.DevEUI,
+		ThingID:      m.ThingID,
+		DecoderName:  m.DecoderName,
+		ModelVersion: m.ModelVersion,
+	}
+}
+
+func (m IDMapping) device() storage.Device {
+	device := storage.Device{
+		DeviceMapping:   m.deviceMapping(),
+		Ignored:         m.Ignored,
+		Offline:         m.Offline,
+		LearnedInterval: m.LearnedInterval,
+	}
+	if m.LastUplinkAt != nil {
+		device.LastUplinkAt = *m.LastUplinkAt
+	}
+	return device
+}
+
+func newIDMapping(mapping lorawan.DeviceMapping) *IDMapping {
+	return &IDMapping{
+		DevEUI:       mapping.DevEUI,
+		ThingID:      mapping.ThingID,
+		InstanceID:   mapping.InstanceID,
+		DecoderName:  mapping.DecoderName,
+		ModelVersion: mapping.ModelVersion,
+	}
+}
+
+// DecoderConfig selects the decoder for the devices of a network server application
+type DecoderConfig struct {
+	InstanceID    string `gorm:"primaryKey;REFERENCES instances(id);size:36"`
+	ApplicationID uint64 `gorm:"primaryKey;autoIncrement:false"`
+	DecoderName   string
+	Instance      *Instance `gorm:"foreignKey:InstanceID;AssociationForeignKey:ID"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+var _ storage.Store = (*DB)(nil)
+
+// DB stores installations, instances and devices in a SQL database
+type DB struct {
+	db              *gorm.DB
+	dialect         Dialect
+	keyring         *secrets.Keyring
+	locker          locker
+	connectorClient connector.Client
+	states          storage.StateReporter
+	host            string
+	logger          logrus.FieldLogger
+}
+
+// NewDB connects to the database described by the DSN, see ParseDSN for the supported formats.
+// Tokens and other secrets are encrypted with the keyring, they are stored as plain text if it is nil.
+func NewDB(dsn string, connectorClient connector.Client, host string, keyring *secrets.Keyring) (*DB, error) {
+	dialect, driverDSN, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	gdb, err := gorm.Open(dialect.open(driverDSN), &gorm.Config{
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := instrument(gdb); err != nil {
+		return nil, err
+	}
+	if err := traceQueries(gdb, dialect); err != nil {
+		return nil, err
+	}
+	d := &DB{
+		db:              gdb,
+		dialect:         dialect,
+		keyring:         keyring,
+		connectorClient: connectorClient,
+		host:            host,
+		logger:          logrus.WithField("component", string(dialect)),
+	}
+	d.states = storage.StateReporter{Client: connectorClient, Logger: d.logger}
+	d.locker = dialect.newLocker(d)
+	return d, nil
+}
+
+// Close closes the connections to the database
+func (d *DB) Close() error {
+	sqlDB, err := d.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// Ping checks that a connection to the database can be established
+func (d *DB) Ping(ctx context.Context) error {
+	sqlDB, err := d.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func (d *DB) AddInstallation(ctx context.Context, req connector.InstallationRequest) (err error) {
+	defer func() {
+		if err != nil {
+			d.states.InstallationFailed(ctx, req.Token, err)
+		} else {
+			d.states.Installation(ctx, req.Token, connector.InstallationStateComplete, nil)
+		}
+	}()
+	if _, err := lorawan.ParseInstanceConfig(req.Configuration, nil); err != nil {
+		return storage.ConfigurationError(err)
+	}
+	configuration, err := json.Marshal(req.Configuration)
+	if err != nil {
+		return err
+	}
+	installation := &Installation{
+		ID:            req.ID,
+		Token:         string(req.Token),
+		Configuration: string(configuration),
+	}
+	if err = d.sealInstallation(installation); err != nil {
+		return
+	}
+	err = d.db.WithContext(ctx).Create(installation).Error
+	return
+}
+
+// instanceConfig parses the configuration of a new instance on top of the configuration of its installation
+func (d *DB) instanceConfig(ctx context.Context, req connector.InstantiationRequest) (lorawan.InstanceConfig, error) {
+	var installation Installation
+	err := d.db.WithContext(ctx).Model(&Installation{}).Where("id = ?", req.InstallationID).Take(&installation).Error
+	if err != nil {
+		return lorawan.InstanceConfig{}, err
+	}
+	var installationConfig []connector.Configuration
+	if installation.Configuration != "" {
+		if err := json.Unmarshal([]byte(installation.Configuration), &installationConfig); err != nil {
+			return lorawan.InstanceConfig{}, err
+		}
+	}
+	return lorawan.ParseInstanceConfig(installationConfig, req.Configuration)
+}
+
+// AddInstance creates the config thing of a new instance and stores the instance. Repeated requests
+// for an existing instance succeed without creating further things. If a step fails, things already
+// created in connctd are deleted again and the instance state is reported as failed.
+func (d *DB) AddInstance(ctx context.Context, req connector.InstantiationRequest) error {
+	logger := d.logger.WithFields(logrus.Fields{
+		"instanceId":     req.ID,
+		"installationId": req.InstallationID,
+	})
+	unlock, err := d.lock(ctx, "instance/"+req.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var existing Instance
+	err = d.db.WithContext(ctx).Model(&Instance{}).Where("id = ?", req.ID).Take(&existing).Error
+	if err == nil {
+		if existing.InstallationID != req.InstallationID {
+			return storage.ErrInstanceExists
+		}
+		logger.Info("Instance already exists, skipping provisioning")
+		if err := d.openInstance(&existing); err != nil {
+			return err
+		}
+		if existing.Token != string(req.Token) {
+			token := string(req.Token)
+			if err := d.seal(columnInstanceToken, existing.ID, &token); err != nil {
+				return err
+			}
+			if err := d.db.WithContext(ctx).Model(&existing).Update("token", token).Error; err != nil {
+				return err
+			}
+		}
+		d.states.Instance(ctx, req.Token, connector.InstantiationStateComplete, nil)
+		return nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	config, err := d.instanceConfig(ctx, req)
+	if err != nil {
+		d.states.InstanceFailed(ctx, req.Token, "parseConfiguration", err)
+		return storage.ConfigurationError(err)
+	}
+
+	d.states.Instance(ctx, req.Token, connector.InstantiationStateOngoing, nil)
+	configThing, err := d.connectorClient.CreateThing(ctx, req.Token, storage.NewConfigThing(config))
+	if err != nil {
+		d.states.InstanceFailed(ctx, req.Token, "createConfigThing", err)
+		return err
+	}
+	logger = logger.WithField("configThingId", configThing.ID)
+
+	instance := &Instance{
+		ID:             req.ID,
+		Token:          string(req.Token),
+		InstallationID: req.InstallationID,
+		ConfigThingID:  configThing.ID,
+		State:          connector.InstantiationStateComplete,
+	}
+	instance.setConfig(config)
+	step := "storeInstance"
+	err = d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := d.sealInstance(instance); err != nil {
+			return err
+		}
+		if err := tx.Create(instance).Error; err != nil {
+			return err
+		}
+		step = "setCallbackUrl"
+		callbackUrl := storage.CallbackURL(d.host, req.InstallationID, req.ID)
+		return d.connectorClient.UpdateThingPropertyValue(ctx, req.Token, instance.ConfigThingID, storage.ConfigComponentID, "url", callbackUrl, time.Now())
+	})
+	if err != nil {
+		logger.WithError(err).WithField("step", step).Error("Failed to add instance, deleting config thing")
+		if err := d.connectorClient.DeleteThing(ctx, req.Token, configThing.ID); err != nil {
+			logger.WithError(err).Error("Failed to delete config thing of failed instance")
+		}
+		d.states.InstanceFailed(ctx, req.Token, step, err)
+		return err
+	}
+
+	d.states.Instance(ctx, req.Token, connector.InstantiationStateComplete, nil)
+	return nil
+}
+
+func (d *DB) PerformAction(ctx context.Context, req connector.ActionRequest) (*connector.ActionResponse, error) {
+	logger := d.logger.WithFields(logrus.Fields{
+		"actionRequestId": req.ID,
+		"thingId":         req.ThingID,
+	})
+	var instance Instance
+	err := d.db.WithContext(ctx).Model(&Instance{}).Where("config_thing_id = ?", req.ThingID).Take(&instance).Error
+	if err == nil {
+		if err := d.openInstance(&instance); err != nil {
+			return nil, err
+		}
+		logger.Info("Thing is a config thing, performing config action")
+		return d.performConfigThingAction(ctx, instance, req)
+	}
+	if err == gorm.ErrRecordNotFound {
+		logger.Info("Thing is not a config thing, trying to figure out to which instance this thing belongs")
+		var mapping IDMapping
+		err = d.db.WithContext(ctx).Model(&IDMapping{}).Where("thing_id = ?", req.ThingID).Take(&mapping).Error
+		if err != nil && err == gorm.ErrRecordNotFound {
+			logger.Error("Thing does not exist, replying with failed action request status")
+			return &connector.ActionResponse{
+				Status: restapi.ActionRequestStatusFailed,
+				Error:  "thing does not exist",
+			}, nil
+		} else if err != nil {
+			logger.WithError(err).Error("Unknown error, failing action request")
+			return &connector.ActionResponse{
+				Status: restapi.ActionRequestStatusFailed,
+				Error:  "Internal Error",
+			}, err
+		}
+		return d.performLoraThingAction(ctx, mapping, req)
+	} else {
+		logger.WithError(err).Error("Querying database for action thing failed")
+		return &connector.ActionResponse{
+			Status: restapi.ActionRequestStatusFailed,
+			Error:  err.Error(),
+		}, err
+	}
+}
+
+// performLoraThingAction routes an action request to the decoder of the thing
+func (d *DB) performLoraThingAction(ctx context.Context, mapping IDMapping, req connector.ActionRequest) (*connector.ActionResponse, error) {
+	logger := d.logger.WithFields(logrus.Fields{
+		"actionRequestId": req.ID,
+		"thingId":         req.ThingID,
+	})
+	return storage.PerformDecoderAction(ctx, d, mapping.DecoderName, req, logger)
+}
+
+func (d *DB) performConfigThingAction(ctx context.Context, instance Instance, req connector.ActionRequest) (*connector.ActionResponse, error) {
+	logger := d.logger.WithFields(logrus.Fields{
+		"actionRequestId": req.ID,
+		"thingId":         req.ThingID,
+		"actionId":        req.ActionID,
+		"componentId":     req.ComponentID,
+	})
+	if req.ComponentID == storage.ConfigComponentID {
+		switch req.ActionID {
+		case storage.ActionAddMapping:
+			return d.addMapping(ctx, instance, req, logger)
+		case storage.ActionSetProvisioningPolicy:
+			return d.setProvisioningPolicy(ctx, instance, req, logger)
+		}
+	}
+	logger.Error("Invalid action id or component id. Expected action 'addmapping' or 'setprovisioningpolicy' and component 'lora'")
+	return &connector.ActionResponse{
+		Status: restapi.ActionRequestStatusFailed,
+		Error:  "Invalid action or component ID",
+	}, nil
+}
+
+func (d *DB) addMapping(ctx context.Context, instance Instance, req connector.ActionRequest, logger logrus.FieldLogger) (*connector.ActionResponse, error) {
+	appId, decoderName, err := storage.ParseAddMapping(req)
+	if err != nil {
+		logger.WithError(err).WithField("parameters", req.Parameters).Error("Invalid mapping")
+		return storage.ActionFailed(err.Error()), nil
+	}
+	config := DecoderConfig{
+		ApplicationID: appId,
+		DecoderName:   decoderName,
+		InstanceID:    instance.ID,
+	}
+	err = d.db.WithContext(ctx).Clauses(clause.OnConflict{
+		UpdateAll: true,
+	}).Create(&config).Error
+	if err != nil {
+		logger.WithError(err).Error("Failed to create decoder config")
+		return &connector.ActionResponse{
+			Status: restapi.ActionRequestStatusFailed,
+			Error:  "Internal Error",
+		}, err
+	}
+	logger.Info("Config thing action completed successfully")
+	return &connector.ActionResponse{
+		Status: restapi.ActionRequestStatusCompleted,
+	}, nil
+}
+
+func (d *DB) setProvisioningPolicy(ctx context.Context, instance Instance, req connector.ActionRequest, logger logrus.FieldLogger) (*connector.ActionResponse, error) {
+	policy, err := storage.ParseSetProvisioningPolicy(req)
+	if err != nil {
+		logger.WithField("policyParam", req.Parameters["Policy"]).Error("Invalid provisioning policy")
+		return storage.ActionFailed(err.Error()), nil
+	}
+	err = d.db.WithContext(ctx).Model(&Instance{}).Where("id = ?", instance.ID).Update("provisioning_policy", string(policy)).Error
+	if err != nil {
+		logger.WithError(err).Error("Failed to update provisioning policy")
+		return &connector.ActionResponse{
+			Status: restapi.ActionRequestStatusFailed,
+			Error:  "Internal Error",
+		}, err
+	}
+	err = d.connectorClient.UpdateThingPropertyValue(ctx, connector.InstantiationToken(instance.Token), instance.ConfigThingID, storage.ConfigComponentID, "provisioningPolicy", string(policy), time.Now())
+	if err != nil {
+		logger.WithError(err).Warn("Failed to update provisioning policy property of config thing")
+	}
+	logger.Info("Config thing action completed successfully")
+	return &connector.ActionResponse{
+		Status: restapi.ActionRequestStatusCompleted,
+	}, nil
+}
+
+func (d *DB) GetInstallationToken(ctx context.Context, installationId string) (connector.InstallationToken, error) {
+	var installation Installation
+	err := d.db.WithContext(ctx).Model(&Installation{}).Where("id = ?", installationId).Take(&installation).Error
+	if err != nil {
+		return "", notFound(err)
+	}
+	err = d.openInstallation(&installation)
+	return connector.InstallationToken(installation.Token), err
+}
+
+func (d *DB) GetInstanceToken(ctx context.Context, instanceId string) (connector.InstantiationToken, error) {
+	instance, err := d.getInstance(ctx, instanceId)
+	return connector.InstantiationToken(instance.Token), err
+}
+
+func (d *DB) GetInstance(ctx context.Context, instanceId string) (connector.InstantiationRequest, error) {
+	instance, err := d.getInstance(ctx, instanceId)
+	return connector.InstantiationRequest{
+		ID:             instance.ID,
+		Token:          connector.InstantiationToken(instance.Token),
+		InstallationID: instance.InstallationID,
+	}, err
+}
+
+// getInstance returns the instance with decrypted secrets
+func (d *DB) getInstance(ctx context.Context, instanceId string) (Instance, error) {
+	var instance Instance
+	err := d.db.WithContext(ctx).Model(&Instance{}).Where("id = ?", instanceId).Take(&instance).Error
+	if err != nil {
+		return Instance{}, notFound(err)
+	}
+	return instance, d.openInstance(&instance)
+}
+
+// StoreDeviceMapping stores the mapping of a new device. It fails if the device is already mapped.
+func (d *DB) StoreDeviceMapping(ctx context.Context, mapping lorawan.DeviceMapping) error {
+	return d.db.WithContext(ctx).Create(newIDMapping(mapping)).Error
+}
+
+// GetDeviceMapping returns the mapping of the device or nil if the device is unknown
+func (d *DB) GetDeviceMapping(ctx context.Context, instanceId string, devEUI []byte) (*lorawan.DeviceMapping, error) {
+	var mapping IDMapping
+	err := d.db.WithContext(ctx).Model(&IDMapping{}).Where("dev_e_ui = ? AND instance_id = ?", devEUI, instanceId).Take(&mapping).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Ignored {
+		return nil, lorawan.ErrDeviceIgnored
+	}
+	m := mapping.deviceMapping()
+	return &m, nil
+}
+
+// ReplaceThingID drops the mapping of a device to a stale thing, which has been deleted in connctd
+// or was created with an outdated thing model, and stores the new mapping. The decoder state of the
+// stale thing is carried over to the new thing.
+func (d *DB) ReplaceThingID(ctx context.Context, staleThingID string, mapping lorawan.DeviceMapping) error {
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("dev_e_ui = ? AND instance_id = ? AND thing_id = ?", mapping.DevEUI, mapping.InstanceID, staleThingID).Delete(&IDMapping{}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(newIDMapping(mapping)).Error; err != nil {
+			return err
+		}
+		return tx.Model(&DecoderState{}).Where("thing_id = ?", staleThingID).Update("thing_id", mapping.ThingID).Error
+	})
+}
+
+// SetDeviceDecoder records the decoder and the thing model version of a device mapping created before
+// thing models were versioned
+func (d *DB) SetDeviceDecoder(ctx context.Context, instanceID string, devEUI []byte, decoderName string, modelVersion uint) error {
+	return d.db.WithContext(ctx).Model(&IDMapping{}).Where("dev_e_ui = ? AND instance_id = ?", devEUI, instanceID).Updates(map[string]interface{}{
+		"decoder_name":  decoderName,
+		"model_version": modelVersion,
+	}).Error
+}
+
+// RecordUplink stores the time of an uplink of the device and learns its reporting interval.
+// It reports whether the device is marked as offline, which is kept until SetDeviceOnline.
+func (d *DB) RecordUplink(ctx context.Context, instanceID string, devEUI []byte, t time.Time) (bool, error) {
+	var mapping IDMapping
+	err := d.db.WithContext(ctx).Model(&IDMapping{}).Where("dev_e_ui = ? AND instance_id = ?", devEUI, instanceID).Take(&mapping).Error
+	if err != nil {
+		return false, notFound(err)
+	}
+	updates := map[string]interface{}{
+		"last_uplink_at": t,
+	}
+	if mapping.LastUplinkAt != nil {
+		updates["learned_interval"] = monitor.LearnInterval(mapping.LearnedInterval, t.Sub(*mapping.LastUplinkAt))
+	}
+	err = d.db.WithContext(ctx).Model(&IDMapping{}).Where("dev_e_ui = ? AND instance_id = ?", devEUI, instanceID).Updates(updates).Error
+	return mapping.Offline, err
+}
+
+// ListDeviceActivity returns the last uplink of all devices which are neither offline nor ignored.
+// Devices of instances whose secrets can't be decrypted are logged and skipped.
+func (d *DB) ListDeviceActivity(ctx context.Context) ([]monitor.DeviceActivity, error) {
+	var mappings []IDMapping
+	err := d.db.WithContext(ctx).Model(&IDMapping{}).Preload("Instance").
+		Where("offline = ? AND ignored = ? AND last_uplink_at IS NOT NULL", false, false).Find(&mappings).Error
+	if err != nil {
+		return nil, err
+	}
+	activities := make([]monitor.DeviceActivity, 0, len(mappings))
+	unreadable := make(map[string]bool)
+	for _, m := range mappings {
+		if m.Instance == nil || unreadable[m.InstanceID] {
+			continue
+		}
+		if err := d.openInstance(m.Instance); err != nil {
+			d.logger.WithError(err).WithField("instanceId", m.InstanceID).Error("Failed to decrypt instance secrets, skipping its devices")
+			unreadable[m.InstanceID] = true
+			continue
+		}
+		activities = append(activities, monitor.DeviceActivity{
+			InstanceID:      m.InstanceID,
+			Token:           connector.InstantiationToken(m.Instance.Token),
+			DevEUI:          m.DevEUI,
+			ThingID:         m.ThingID,
+			DecoderName:     m.DecoderName,
+			LastUplinkAt:    *m.LastUplinkAt,
+			LearnedInterval: m.LearnedInterval,
+		})
+	}
+	return activities, nil
+}
+
+// SetDeviceOffline marks the device as offline until SetDeviceOnline
+func (d *DB) SetDeviceOffline(ctx context.Context, instanceID string, devEUI []byte) error {
+	return d.db.WithContext(ctx).Model(&IDMapping{}).Where("dev_e_ui = ? AND instance_id = ?", devEUI, instanceID).Update("offline", true).Error
+}
+
+// SetDeviceOnline clears the offline mark, after the thing has been marked as available again
+func (d *DB) SetDeviceOnline(ctx context.Context, instanceID string, devEUI []byte) error {
+	return d.db.WithContext(ctx).Model(&IDMapping{}).Where("dev_e_ui = ? AND instance_id = ?", devEUI, instanceID).Update("offline", false).Error
+}
+
+// OutdatedDeviceMappings returns the mappings of all things of the instance created by the given decoder
+// with a thing model older than modelVersion. Ignored devices are skipped.
+func (d *DB) OutdatedDeviceMappings(ctx context.Context, instanceID, decoderName string, modelVersion uint) ([]lorawan.DeviceMapping, error) {
+	var mappings []IDMapping
+	err := d.db.WithContext(ctx).Model(&IDMapping{}).
+		Where("instance_id = ? AND decoder_name = ? AND model_version < ? AND ignored = ?", instanceID, decoderName, modelVersion, false).Find(&mappings).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make([]lorawan.DeviceMapping, 0, len(mappings))
+	for _, m := range mappings {
+		result = append(result, m.deviceMapping())
+	}
+	return result, nil
+}
+
+// IgnoreDevice marks the device, so that GetDeviceMapping reports it as ignored
+func (d *DB) IgnoreDevice(ctx context.Context, instanceID string, devEUI []byte) error {
+	return d.db.WithContext(ctx).Model(&IDMapping{}).Where("dev_e_ui = ? AND instance_id = ?", devEUI, instanceID).Update("ignored", true).Error
+}
+
+func (d *DB) ProvisioningPolicy(ctx context.Context, instanceID string) (lorawan.ProvisioningPolicy, error) {
+	config, err := d.GetInstanceConfig(ctx, instanceID)
+	return config.ProvisioningPolicy, err
+}
+
+// SetLastEvent records when the instance received the last event from its network server
+func (d *DB) SetLastEvent(ctx context.Context, instanceID string, t time.Time) error {
+	return d.db.WithContext(ctx).Model(&Instance{}).Where("id = ?", instanceID).Update("last_event_at", t).Error
+}
+
+// ListInstanceActivity returns the state and last event time of all instances. Instances whose
+// secrets can't be decrypted are logged and skipped.
+func (d *DB) ListInstanceActivity(ctx context.Context) ([]monitor.InstanceActivity, error) {
+	var instances []Instance
+	if err := d.db.WithContext(ctx).Model(&Instance{}).Select("id", "token", "state", "created_at", "last_event_at").Find(&instances).Error; err != nil {
+		return nil, err
+	}
+	activities := make([]monitor.InstanceActivity, 0, len(instances))
+	for _, instance := range instances {
+		if err := d.openInstance(&instance); err != nil {
+			d.logger.WithError(err).WithField("instanceId", instance.ID).Error("Failed to decrypt instance secrets, skipping the instance")
+			continue
+		}
+		activity := monitor.InstanceActivity{
+			InstanceID: instance.ID,
+			Token:      connector.InstantiationToken(instance.Token),
+			State:      instance.State,
+			CreatedAt:  instance.CreatedAt,
+		}
+		if instance.LastEventAt != nil {
+			activity.LastEventAt = *instance.LastEventAt
+		}
+		activities = append(activities, activity)
+	}
+	return activities, nil
+}
+
+func (d *DB) ListInstances(ctx context.Context) ([]storage.Instance, error) {
+	var instances []Instance
+	if err := d.db.WithContext(ctx).Order("id").Find(&instances).Error; err != nil {
+		return nil, err
+	}
+	result := make([]storage.Instance, 0, len(instances))
+	for _, instance := range instances {
+		if err := d.openInstance(&instance); err != nil {
+			return nil, err
+		}
+		result = append(result, instance.details())
+	}
+	return result, nil
+}
+
+func (d *DB) GetInstanceDetails(ctx context.Context, instanceID string) (storage.Instance, error) {
+	instance, err := d.getInstance(ctx, instanceID)
+	if err != nil {
+		return storage.Instance{}, err
+	}
+	return instance.details(), nil
+}
+
+func (d *DB) ListDevices(ctx context.Context, instanceID string) ([]storage.Device, error) {
+	if _, err := d.getInstance(ctx, instanceID); err != nil {
+		return nil, err
+	}
+	var mappings []IDMapping
+	if err := d.db.WithContext(ctx).Where("instance_id = ?", instanceID).Order("dev_e_ui").Find(&mappings).Error; err != nil {
+		return nil, err
+	}
+	devices := make([]storage.Device, 0, len(mappings))
+	for _, m := range mappings {
+		devices = append(devices, m.device())
+	}
+	return devices, nil
+}
+
+func (d *DB) GetDevice(ctx context.Context, instanceID string, devEUI []byte) (*storage.Device, error) {
+	if _, err := d.getInstance(ctx, instanceID); err != nil {
+		return nil, err
+	}
+	var mapping IDMapping
+	err := d.db.WithContext(ctx).Where("dev_e_ui = ? AND instance_id = ?", devEUI, instanceID).Take(&mapping).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	device := mapping.device()
+	return &device, nil
+}
+
+// SetInstanceState stores the state which has been reported to connctd
+func (d *DB) SetInstanceState(ctx context.Context, instanceID string, state connector.InstantiationState) error {
+	return d.db.WithContext(ctx).Model(&Instance{}).Where("id = ?", instanceID).Update("state", state).Error
+}
+
+// GetInstanceConfig returns the configuration of the instance
+func (d *DB) GetInstanceConfig(ctx context.Context, instanceID string) (lorawan.InstanceConfig, error) {
+	instance, err := d.getInstance(ctx, instanceID)
+	if err != nil {
+		return lorawan.InstanceConfig{}, err
+	}
+	return instance.config(), nil
+}
+
+// DecoderNameForApp returns the name of the decoder mapped to the LoRaWAN application or the
+// default decoder of the instance, if the application is not mapped
+func (d *DB) DecoderNameForApp(ctx context.Context, instanceID string, appId uint64) (string, error) {
+	var config DecoderConfig
+	err := d.db.WithContext(ctx).Model(&DecoderConfig{}).Where("application_id = ? AND instance_id = ?", appId, instanceID).Take(&config).Error
+	if err == gorm.ErrRecordNotFound {
+		instanceConfig, configErr := d.GetInstanceConfig(ctx, instanceID)
+		if configErr == nil && instanceConfig.DefaultDecoder != "" {
+			return instanceConfig.DefaultDecoder, nil
+		}
+	}
+	return config.DecoderName, notFound(err)
+}
+
+func (d *DB) ListApplicationDecoders(ctx context.Context, instanceID string) (map[uint64]string, error) {
+	var configs []DecoderConfig
+	if err := d.db.WithContext(ctx).Where("instance_id = ?", instanceID).Find(&configs).Error; err != nil {
+		return nil, err
+	}
+	decoders := make(map[uint64]string, len(configs))
+	for _, config := range configs {
+		decoders[config.ApplicationID] = config.DecoderName
+	}
+	return decoders, nil
+}
+
+// notFound translates the not found error of gorm into the storage independent error
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return storage.ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package sqlstore_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/secrets"
+	"github.com/connctd/lora-connector/sqlstore"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/connctd/lora-connector/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLite(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, client connector.Client) storage.Store {
+		return open(t, "sqlite://"+filepath.Join(t.TempDir(), "lora.db"), client)
+	})
+}
+
+// TestMySQL and TestPostgres need an empty database, which is dropped after every test
+func TestMySQL(t *testing.T) {
+	testServer(t, "LORACONN_TEST_MYSQL_DSN")
+}
+
+func TestPostgres(t *testing.T) {
+	testServer(t, "LORACONN_TEST_POSTGRES_DSN")
+}
+
+func testServer(t *testing.T, env string) {
+	dsn := os.Getenv(env)
+	if dsn == "" {
+		t.Skipf("%s not set", env)
+	}
+	storagetest.Run(t, func(t *testing.T, client connector.Client) storage.Store {
+		store := open(t, dsn, client)
+		t.Cleanup(func() {
+			require.NoError(t, store.DropAll())
+		})
+		return store
+	})
+}
+
+func open(t *testing.T, dsn string, client connector.Client) *sqlstore.DB {
+	keyring, err := secrets.NewKeyring([]string{"test"}, [][]byte{bytes.Repeat([]byte{1}, secrets.KeySize)})
+	require.NoError(t, err)
+	store, err := sqlstore.NewDB(dsn, client, storagetest.Host, keyring)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		store.Close()
+	})
+	require.NoError(t, store.CreateOrMigrate())
+	return store
+}
+
+func TestParseDSN(t *testing.T) {
+	for dsn, expected := range map[string]struct {
+		dialect   sqlstore.Dialect
+		driverDSN string
+	}{
+		"user:pw@tcp(localhost:3306)/lora?parseTime=true":         {sqlstore.DialectMySQL, "user:pw@tcp(localhost:3306)/lora?parseTime=true"},
+		"mysql://user:pw@tcp(localhost:3306)/lora?parseTime=true": {sqlstore.DialectMySQL, "user:pw@tcp(localhost:3306)/lora?parseTime=true"},
+		"postgres://user:pw@localhost:5432/lora":                  {sqlstore.DialectPostgres, "postgres://user:pw@localhost:5432/lora"},
+		"postgresql://user:pw@localhost/lora":                     {sqlstore.DialectPostgres, "postgresql://user:pw@localhost/lora"},
+		"sqlite:///var/lib/lora.db":                               {sqlstore.DialectSQLite, "/var/lib/lora.db?_pragma=busy_timeout(10000)"},
+		"sqlite://lora.db?_pragma=journal_mode(WAL)":              {sqlstore.DialectSQLite, "lora.db?_pragma=journal_mode(WAL)&_pragma=busy_timeout(10000)"},
+	} {
+		dialect, driverDSN, err := sqlstore.ParseDSN(dsn)
+		require.NoError(t, err, dsn)
+		require.Equal(t, expected.dialect, dialect, dsn)
+		require.Equal(t, expected.driverDSN, driverDSN, dsn)
+	}
+
+	_, _, err := sqlstore.ParseDSN("mongodb://localhost")
+	require.Error(t, err)
+}
@@ -0,0 +1,128 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type DecoderState struct {
+	gorm.Model
+	ThingID string `gorm:"primaryKey;size:36;uniqueIndex:idx_decoder_states_thing_key"`
+	Key     string `gorm:"primaryKey;size:36;uniqueIndex:idx_decoder_states_thing_key"`
+	Value   []byte
+}
+
+func (d *DB) GetState(ctx context.Context, thingID, key string) ([]byte, error) {
+	var state DecoderState
+	err := d.db.WithContext(ctx).Where(stateKey(thingID, key)).Take(&state).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, decoder.ErrStateNotFound
+	}
+	return state.Value, err
+}
+
+func (d *DB) SetState(ctx context.Context, thingId, key string, value []byte) error {
+	state := DecoderState{
+		ThingID: thingId,
+		Key:     key,
+		Value:   value,
+	}
+	return d.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "thing_id"}, {Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).Create(&state).Error
+}
+
+func (d *DB) ListState(ctx context.Context, thingID string) (map[string][]byte, error) {
+	var states []DecoderState
+	if err := d.db.WithContext(ctx).Where("thing_id = ?", thingID).Find(&states).Error; err != nil {
+		return nil, err
+	}
+	values := make(map[string][]byte, len(states))
+	for _, state := range states {
+		values[state.Key] = state.Value
+	}
+	return values, nil
+}
+
+// stateKey returns the condition selecting a single state value. The column name key is a reserved
+// word in some dialects and gets quoted by gorm when used in a map condition.
+func stateKey(thingID, key string) map[string]interface{} {
+	return map[string]interface{}{
+		"thing_id": thingID,
+		"key":      key,
+	}
+}
+
+// dedupeState drops all but the latest value of every decoder state key. Previous versions did not
+// enforce unique keys, so updated values have been stored as additional rows.
+func (d *DB) dedupeState(tx *gorm.DB) error {
+	if !tx.Migrator().HasTable(&DecoderState{}) || tx.Migrator().HasIndex(&DecoderState{}, "idx_decoder_states_thing_key") {
+		return nil
+	}
+	if err := tx.Unscoped().Where("deleted_at IS NOT NULL").Delete(&DecoderState{}).Error; err != nil {
+		return err
+	}
+	var states []DecoderState
+	if err := tx.Select("id", "thing_id", "key").Order("id DESC").Find(&states).Error; err != nil {
+		return err
+	}
+	latest := map[[2]string]bool{}
+	var outdated []uint
+	for _, state := range states {
+		k := [2]string{state.ThingID, state.Key}
+		if latest[k] {
+			outdated = append(outdated, state.ID)
+		}
+		latest[k] = true
+	}
+	if len(outdated) == 0 {
+		return nil
+	}
+	d.logger.WithField("count", len(outdated)).Info("Deleting outdated decoder state values")
+	return tx.Unscoped().Where("id IN ?", outdated).Delete(&DecoderState{}).Error
+}
+
+// migrateLegacyState converts decoder state stored before keys were namespaced by decoder
+// and values were typed. Legacy keys unknown to all decoders are left untouched.
+func (d *DB) migrateLegacyState(tx *gorm.DB) error {
+	var states []DecoderState
+	legacy := clause.Not(clause.Like{Column: clause.Column{Name: "key"}, Value: "%.%"})
+	if err := tx.Where(legacy).Find(&states).Error; err != nil {
+		return err
+	}
+	for _, state := range states {
+		logger := d.logger.WithFields(logrus.Fields{
+			"thingId": state.ThingID,
+			"key":     state.Key,
+		})
+		key, value, ok, err := decoder.MigrateLegacyState(state.Key, state.Value)
+		if err != nil {
+			return fmt.Errorf("failed to migrate decoder state %s of thing %s: %w", state.Key, state.ThingID, err)
+		}
+		if !ok {
+			logger.Warn("No decoder knows legacy decoder state, leaving it untouched")
+			continue
+		}
+		migrated := DecoderState{
+			ThingID: state.ThingID,
+			Key:     key,
+			Value:   value,
+		}
+		// Values set since the upgrade take precedence over the legacy value
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&migrated).Error; err != nil {
+			return fmt.Errorf("failed to migrate decoder state %s of thing %s: %w", state.Key, state.ThingID, err)
+		}
+		if err := tx.Unscoped().Where(stateKey(state.ThingID, state.Key)).Delete(&DecoderState{}).Error; err != nil {
+			return fmt.Errorf("failed to migrate decoder state %s of thing %s: %w", state.Key, state.ThingID, err)
+		}
+		logger.WithField("newKey", key).Info("Migrated legacy decoder state")
+	}
+	return nil
+}
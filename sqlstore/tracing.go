@@ -0,0 +1,71 @@
+package sqlstore
+
+import (
+	"errors"
+
+	"github.com/connctd/lora-connector/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// querySpanKey stores the span of a query in the gorm statement
+const querySpanKey = "tracing:span"
+
+// traceQueries starts a span for every query as child of the span in the context of the statement.
+// Statements are recorded with placeholders, values are not recorded.
+func traceQueries(db *gorm.DB, dialect Dialect) error {
+	callbacks := db.Callback()
+	for _, err := range []error{
+		callbacks.Create().Before("*").Register("tracing:before_create", startSpan("create", dialect)),
+		callbacks.Create().After("*").Register("tracing:after_create", endSpan),
+		callbacks.Query().Before("*").Register("tracing:before_query", startSpan("query", dialect)),
+		callbacks.Query().After("*").Register("tracing:after_query", endSpan),
+		callbacks.Update().Before("*").Register("tracing:before_update", startSpan("update", dialect)),
+		callbacks.Update().After("*").Register("tracing:after_update", endSpan),
+		callbacks.Delete().Before("*").Register("tracing:before_delete", startSpan("delete", dialect)),
+		callbacks.Delete().After("*").Register("tracing:after_delete", endSpan),
+		callbacks.Row().Before("*").Register("tracing:before_row", startSpan("row", dialect)),
+		callbacks.Row().After("*").Register("tracing:after_row", endSpan),
+		callbacks.Raw().Before("*").Register("tracing:before_raw", startSpan("raw", dialect)),
+		callbacks.Raw().After("*").Register("tracing:after_raw", endSpan),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startSpan(operation string, dialect Dialect) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		// Queries without context of a request, e.g. migrations, are not traced
+		if !trace.SpanFromContext(db.Statement.Context).SpanContext().IsValid() {
+			return
+		}
+		_, span := tracing.Start(db.Statement.Context, "db."+operation,
+			semconv.DBSystemKey.String(string(dialect)),
+			semconv.DBOperationKey.String(operation),
+			semconv.DBSQLTableKey.String(db.Statement.Table),
+		)
+		db.InstanceSet(querySpanKey, span)
+	}
+}
+
+func endSpan(db *gorm.DB) {
+	s, ok := db.InstanceGet(querySpanKey)
+	if !ok {
+		return
+	}
+	span := s.(trace.Span)
+	span.SetAttributes(
+		semconv.DBStatementKey.String(db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	err := db.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	tracing.End(span, err)
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/connctd/lora-connector/tracing"
+	"github.com/connctd/restapi-go"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrInstanceExists is returned if an instance is added again for a different installation
+var ErrInstanceExists = errors.New("instance already exists for a different installation")
+
+// IDs of the component and the actions of the config thing of an instance
+const (
+	ConfigComponentID           = "lora"
+	ActionAddMapping            = "addmapping"
+	ActionSetProvisioningPolicy = "setprovisioningpolicy"
+)
+
+// NewConfigThing returns the config thing which is created for every instance
+func NewConfigThing(config lorawan.InstanceConfig) restapi.Thing {
+	return restapi.Thing{
+		Name:            "configuration Thing",
+		Manufacturer:    "IoT connctd GmbH",
+		DisplayType:     "loranetwork",
+		Status:          restapi.StatusTypeAvailable,
+		MainComponentID: "lora",
+		Components: []restapi.Component{
+			{
+				ID:            "lora",
+				Name:          "LoRaWAN config",
+				ComponentType: "config",
+				Capabilities:  []string{"loraconfig"},
+				Properties: []restapi.Property{
+					{
+						ID:           "url",
+						Name:         "HTTP Callback URL",
+						Value:        "",
+						Unit:         "",
+						Type:         restapi.ValueTypeString,
+						PropertyType: "URL",
+					},
+					{
+						ID:    "decoders",
+						Name:  "Decoders",
+						Value: "ldds75,dcl571",
+						Unit:  "",
+						Type:  restapi.ValueTypeString,
+					},
+					{
+						ID:    "provisioningPolicy",
+						Name:  "Provisioning policy for deleted things",
+						Value: string(config.ProvisioningPolicy),
+						Unit:  "",
+						Type:  restapi.ValueTypeString,
+					},
+				},
+				Actions: []restapi.Action{
+					{
+						ID:   "addmapping",
+						Name: "AddMapping",
+						Parameters: []restapi.ActionParameter{
+							{
+								Name: "ApplicationId",
+								Type: restapi.ValueTypeNumber,
+							},
+							{
+								Name: "PayloadDecoder",
+								Type: restapi.ValueTypeString,
+							},
+						},
+					},
+					{
+						ID:   "setprovisioningpolicy",
+						Name: "SetProvisioningPolicy",
+						Parameters: []restapi.ActionParameter{
+							{
+								Name: "Policy",
+								Type: restapi.ValueTypeString,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// CallbackURL returns the URL the network server integration of an instance needs to send events to
+func CallbackURL(host, installationID, instanceID string) string {
+	return fmt.Sprintf("https://%s/lorawan/%s/%s", host, installationID, instanceID)
+}
+
+// ConfigurationError wraps an invalid configuration into ErrInvalidConfiguration, so that it is
+// reported to connctd as a bad request
+func ConfigurationError(err error) error {
+	var configErr lorawan.ConfigurationError
+	if errors.As(err, &configErr) {
+		return fmt.Errorf("%w: %s", ErrInvalidConfiguration, configErr.Error())
+	}
+	return err
+}
+
+// StateReporter reports the state of installations and instances to connctd. Failures are only
+// logged since the state is informational.
+type StateReporter struct {
+	Client connector.Client
+	Logger logrus.FieldLogger
+}
+
+func (s StateReporter) Installation(ctx context.Context, token connector.InstallationToken, state connector.InstallationState, details json.RawMessage) {
+	if err := s.Client.UpdateInstallationState(ctx, token, state, details); err != nil {
+		s.Logger.WithError(err).WithField("state", state).Warn("Failed to update installation state")
+	}
+}
+
+func (s StateReporter) InstallationFailed(ctx context.Context, token connector.InstallationToken, cause error) {
+	details, _ := json.Marshal(map[string]string{
+		"error": cause.Error(),
+	})
+	s.Installation(ctx, token, connector.InstallationStateFailed, details)
+}
+
+func (s StateReporter) Instance(ctx context.Context, token connector.InstantiationToken, state connector.InstantiationState, details json.RawMessage) {
+	if err := s.Client.UpdateInstanceState(ctx, token, state, details); err != nil {
+		s.Logger.WithError(err).WithField("state", state).Warn("Failed to update instance state")
+	}
+}
+
+// InstanceFailed reports the instance as failed, detailing the step which failed
+func (s StateReporter) InstanceFailed(ctx context.Context, token connector.InstantiationToken, step string, cause error) {
+	details, _ := json.Marshal(map[string]string{
+		"step":  step,
+		"error": cause.Error(),
+	})
+	s.Instance(ctx, token, connector.InstantiationStateFailed, details)
+}
+
+// ActionFailed returns the response to a failed action request
+func ActionFailed(message string) *connector.ActionResponse {
+	return &connector.ActionResponse{
+		Status: restapi.ActionRequestStatusFailed,
+		Error:  message,
+	}
+}
+
+// ActionCompleted returns the response to a successful action request
+func ActionCompleted() *connector.ActionResponse {
+	return &connector.ActionResponse{
+		Status: restapi.ActionRequestStatusCompleted,
+	}
+}
+
+// ParseAddMapping returns the LoRaWAN application and the decoder of an addmapping action. The error
+// is meant to be reported to the user.
+func ParseAddMapping(req connector.ActionRequest) (uint64, string, error) {
+	appID, err := strconv.ParseUint(req.Parameters["ApplicationId"], 10, 64)
+	if err != nil {
+		return 0, "", errors.New("Invalid LoRaWAN application id")
+	}
+	decoderName := req.Parameters["PayloadDecoder"]
+	if decoder.GetDecoder(decoderName) == nil {
+		return 0, "", errors.New("Invalid decoder name")
+	}
+	return appID, decoderName, nil
+}
+
+// ParseSetProvisioningPolicy returns the policy of a setprovisioningpolicy action
+func ParseSetProvisioningPolicy(req connector.ActionRequest) (lorawan.ProvisioningPolicy, error) {
+	policy, err := lorawan.ParseProvisioningPolicy(req.Parameters["Policy"])
+	if err != nil {
+		return "", errors.New("Invalid provisioning policy")
+	}
+	return policy, nil
+}
+
+// PerformDecoderAction routes an action request for a device thing to the decoder the thing has been created by
+func PerformDecoderAction(ctx context.Context, store decoder.ContextStateStore, decoderName string, req connector.ActionRequest, logger logrus.FieldLogger) (*connector.ActionResponse, error) {
+	logger = logger.WithFields(logrus.Fields{
+		"actionId": req.ActionID,
+		"decoder":  decoderName,
+	})
+	payloadDecoder := decoder.GetDecoder(decoderName)
+	if payloadDecoder == nil {
+		// Mappings whose decoder could not be backfilled learn their decoder with the next uplink
+		logger.Warn("Decoder of thing is unknown, failing action request")
+		return ActionFailed("decoder of thing is not known yet, please retry after the device sent data"), nil
+	}
+	logger.Info("Performing action on actual lora thing")
+	ctx, span := tracing.Start(ctx, "decoder.action",
+		attribute.String("decoder.name", decoderName),
+		attribute.String("thing.id", req.ThingID),
+		attribute.String("action.id", req.ActionID),
+	)
+	err := decoder.PerformAction(payloadDecoder, decoder.BindContext(ctx, store), req.ThingID, req.ActionID, req.Parameters)
+	tracing.End(span, err)
+	var paramErr decoder.ParameterError
+	switch {
+	case err == nil:
+		return ActionCompleted(), nil
+	case errors.Is(err, decoder.ErrUnknownAction):
+		logger.Warn("Decoder does not handle action, failing action request")
+		return ActionFailed(fmt.Sprintf("unknown action %s", req.ActionID)), nil
+	case errors.As(err, &paramErr):
+		return ActionFailed(paramErr.Error()), nil
+	default:
+		logger.WithError(err).Error("Decoder failed to perform action")
+		return ActionFailed("Internal Error"), err
+	}
+}
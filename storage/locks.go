@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/connctd/lora-connector/monitor"
+)
+
+// ErrLockTimeout is returned if a named lock could not be acquired in time
+var ErrLockTimeout = errors.New("timed out waiting for lock")
+
+// LocalLocks provides named locks within a single process for stores which can't be shared
+// by several replicas of the connector
+type LocalLocks struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+func NewLocalLocks() *LocalLocks {
+	return &LocalLocks{
+		locks: make(map[string]chan struct{}),
+	}
+}
+
+// Lock waits up to timeout for the named lock and returns ErrLockTimeout if it is still held by someone else
+func (l *LocalLocks) Lock(ctx context.Context, name string, timeout time.Duration) (unlock func(), err error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		l.mu.Lock()
+		released, locked := l.locks[name]
+		if !locked {
+			l.locks[name] = make(chan struct{})
+			l.mu.Unlock()
+			var once sync.Once
+			return func() {
+				once.Do(func() { l.unlock(name) })
+			}, nil
+		}
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-timer.C:
+			return nil, ErrLockTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (l *LocalLocks) unlock(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	close(l.locks[name])
+	delete(l.locks, name)
+}
+
+// TryLeadership implements monitor.Elector for a single process
+func (l *LocalLocks) TryLeadership(ctx context.Context, name string) (monitor.Leadership, error) {
+	unlock, err := l.Lock(ctx, "leader/"+name, 0)
+	if errors.Is(err, ErrLockTimeout) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return localLeadership(unlock), nil
+}
+
+type localLeadership func()
+
+func (l localLeadership) Held(ctx context.Context) bool {
+	return true
+}
+
+func (l localLeadership) Release() {
+	l()
+}
@@ -0,0 +1,166 @@
+// Package storage defines what the connector needs from the database it keeps installations,
+// instances, device mappings and decoder state in, independent of the database backing it.
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	"github.com/connctd/lora-connector/monitor"
+)
+
+// ErrNotFound is returned if a requested installation, instance or decoder mapping does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalidConfiguration is wrapped by errors about configurations connctd sent
+var ErrInvalidConfiguration = errors.New("invalid configuration")
+
+// Instance describes an instance for operators. It does not contain the token of the instance.
+type Instance struct {
+	ID             string
+	InstallationID string
+	State          connector.InstantiationState
+	CreatedAt      time.Time
+	// LastEventAt is zero if the instance never received an event
+	LastEventAt time.Time
+	Config      lorawan.InstanceConfig
+}
+
+// Device is the mapping of a device to its thing together with the activity of the device
+type Device struct {
+	lorawan.DeviceMapping
+	Ignored bool
+	Offline bool
+	// LastUplinkAt is zero if no uplink has been recorded yet
+	LastUplinkAt    time.Time
+	LearnedInterval time.Duration
+}
+
+// RemovalReport lists everything the connector removed (or would remove in a dry run)
+// when an installation or instance is removed
+type RemovalReport struct {
+	DryRun         bool     `json:"dryRun"`
+	Installations  []string `json:"installations,omitempty"`
+	Instances      []string `json:"instances,omitempty"`
+	Things         []string `json:"things,omitempty"`
+	Mappings       int      `json:"mappings"`
+	DecoderConfigs int      `json:"decoderConfigs"`
+	DecoderStates  int      `json:"decoderStates"`
+	ArchivedEvents int      `json:"archivedEvents"`
+	// Errors contains failures which did not prevent the removal, e.g. things which
+	// could not be deleted in connctd
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Add merges another report into this one
+func (r *RemovalReport) Add(other *RemovalReport) {
+	r.Installations = append(r.Installations, other.Installations...)
+	r.Instances = append(r.Instances, other.Instances...)
+	r.Things = append(r.Things, other.Things...)
+	r.Mappings += other.Mappings
+	r.DecoderConfigs += other.DecoderConfigs
+	r.DecoderStates += other.DecoderStates
+	r.ArchivedEvents += other.ArchivedEvents
+	r.Errors = append(r.Errors, other.Errors...)
+}
+
+// Store is implemented by all storage backends. Backends need to pass the conformance tests
+// in the storagetest package.
+type Store interface {
+	Database
+	ConnectorStore
+	UplinkStore
+	MonitorStore
+	AdminStore
+	monitor.Elector
+
+	GetInstanceToken(ctx context.Context, instanceID string) (connector.InstantiationToken, error)
+	// PruneArchive deletes all events older than the archive retention of their instance and returns
+	// the number of deleted events
+	PruneArchive(ctx context.Context, now time.Time) (int, error)
+}
+
+// Database manages the database backing the store
+type Database interface {
+	// CreateOrMigrate brings the schema of the database up to date
+	CreateOrMigrate() error
+	Close() error
+	// Ping checks that the database is reachable
+	Ping(ctx context.Context) error
+	// CheckSchema fails if migrations are pending or the database has been migrated by a newer
+	// version of the connector
+	CheckSchema(ctx context.Context) error
+}
+
+// ConnectorStore handles the requests connctd sends to the connector, see connhttp.ConnectorService.
+// Unknown installations and instances are reported as ErrNotFound, invalid configurations as
+// ErrInvalidConfiguration.
+type ConnectorStore interface {
+	AddInstallation(ctx context.Context, req connector.InstallationRequest) error
+	AddInstance(ctx context.Context, req connector.InstantiationRequest) error
+	PerformAction(ctx context.Context, req connector.ActionRequest) (*connector.ActionResponse, error)
+	RemoveInstallation(ctx context.Context, installationID string, dryRun bool) (*RemovalReport, error)
+	RemoveInstance(ctx context.Context, instanceID string, dryRun bool) (*RemovalReport, error)
+}
+
+// UplinkStore is the storage the uplink handler of the lorawan package works with
+type UplinkStore interface {
+	decoder.ContextStateStore
+
+	GetInstallationToken(ctx context.Context, installationID string) (connector.InstallationToken, error)
+	GetInstance(ctx context.Context, instanceID string) (connector.InstantiationRequest, error)
+	GetInstanceConfig(ctx context.Context, instanceID string) (lorawan.InstanceConfig, error)
+	ProvisioningPolicy(ctx context.Context, instanceID string) (lorawan.ProvisioningPolicy, error)
+	DecoderNameForApp(ctx context.Context, instanceID string, appID uint64) (string, error)
+
+	GetDeviceMapping(ctx context.Context, instanceID string, devEUI []byte) (*lorawan.DeviceMapping, error)
+	// StoreDeviceMapping fails if the device is already mapped, so that concurrent provisioning is detected
+	StoreDeviceMapping(ctx context.Context, mapping lorawan.DeviceMapping) error
+	SetDeviceDecoder(ctx context.Context, instanceID string, devEUI []byte, decoderName string, modelVersion uint) error
+	OutdatedDeviceMappings(ctx context.Context, instanceID, decoderName string, modelVersion uint) ([]lorawan.DeviceMapping, error)
+	ReplaceThingID(ctx context.Context, staleThingID string, mapping lorawan.DeviceMapping) error
+	IgnoreDevice(ctx context.Context, instanceID string, devEUI []byte) error
+	LockDevice(ctx context.Context, instanceID string, devEUI []byte) (unlock func(), err error)
+
+	SetLastEvent(ctx context.Context, instanceID string, t time.Time) error
+	RecordUplink(ctx context.Context, instanceID string, devEUI []byte, t time.Time) (wasOffline bool, err error)
+	SetDeviceOnline(ctx context.Context, instanceID string, devEUI []byte) error
+
+	ArchiveEvent(ctx context.Context, event lorawan.ArchivedEvent) error
+	// ListArchivedEvents returns the matching events ordered by the time they were received, or by
+	// their ID if the filter asks for it
+	ListArchivedEvents(ctx context.Context, filter lorawan.ArchiveFilter) ([]lorawan.ArchivedEvent, error)
+	// SetArchivedUpdates replaces the property updates recorded for an archived event
+	SetArchivedUpdates(ctx context.Context, eventID uint64, updates []decoder.PropertyUpdate) error
+}
+
+// MonitorStore is the storage the integration monitor and the offline detector work with
+type MonitorStore interface {
+	ListInstanceActivity(ctx context.Context) ([]monitor.InstanceActivity, error)
+	SetInstanceState(ctx context.Context, instanceID string, state connector.InstantiationState) error
+	ListDeviceActivity(ctx context.Context) ([]monitor.DeviceActivity, error)
+	SetDeviceOffline(ctx context.Context, instanceID string, devEUI []byte) error
+}
+
+// AdminStore is the storage operators inspect and edit through the admin API
+type AdminStore interface {
+	// ListInstances returns all instances ordered by ID
+	ListInstances(ctx context.Context) ([]Instance, error)
+	// GetInstanceDetails returns the instance or ErrNotFound
+	GetInstanceDetails(ctx context.Context, instanceID string) (Instance, error)
+	// ListApplicationDecoders returns the decoders mapped to LoRaWAN applications of the instance
+	ListApplicationDecoders(ctx context.Context, instanceID string) (map[uint64]string, error)
+	// ListDevices returns all devices of the instance including ignored devices, ordered by DevEUI
+	ListDevices(ctx context.Context, instanceID string) ([]Device, error)
+	// GetDevice returns the device including ignored devices, it returns nil if the device is unknown
+	// and ErrNotFound if the instance does not exist
+	GetDevice(ctx context.Context, instanceID string, devEUI []byte) (*Device, error)
+	// ListState returns all decoder state values of the thing by key
+	ListState(ctx context.Context, thingID string) (map[string][]byte, error)
+	SetState(ctx context.Context, thingID, key string, value []byte) error
+	ListArchivedEvents(ctx context.Context, filter lorawan.ArchiveFilter) ([]lorawan.ArchivedEvent, error)
+}
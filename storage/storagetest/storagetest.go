@@ -0,0 +1,600 @@
+// Package storagetest contains the conformance tests all implementations of storage.Store need to pass
+package storagetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/lorawan"
+	"github.com/connctd/lora-connector/lorawan/decoder"
+	_ "github.com/connctd/lora-connector/lorawan/decoder/ldds75"
+	"github.com/connctd/lora-connector/mocks"
+	"github.com/connctd/lora-connector/storage"
+	"github.com/connctd/restapi-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// Host is the public host of the connector stores under test need to be configured with
+const Host = "connector.example.com"
+
+// OpenFunc returns a new and empty store using the given client to talk to connctd
+type OpenFunc func(t *testing.T, client connector.Client) storage.Store
+
+const (
+	installationID = "installation"
+	instanceID     = "instance"
+	configThingID  = "configthing"
+)
+
+var devEUI = []byte{0xa8, 0x40, 0x41, 0x4d, 0x61, 0x82, 0xe0, 0x88}
+
+// Run runs all conformance tests against stores returned by open. Every test opens its own store.
+func Run(t *testing.T, open OpenFunc) {
+	tests := map[string]func(t *testing.T, open OpenFunc){
+		"Installation":     testInstallation,
+		"Instance":         testInstance,
+		"ConfigActions":    testConfigActions,
+		"DeviceMapping":    testDeviceMapping,
+		"ReplaceThingID":   testReplaceThingID,
+		"DecoderState":     testDecoderState,
+		"DecoderActions":   testDecoderActions,
+		"Activity":         testActivity,
+		"LockDevice":       testLockDevice,
+		"Leadership":       testLeadership,
+		"RemoveInstance":   testRemoveInstance,
+		"RemoveUnknown":    testRemoveUnknown,
+		"InvalidConfig":    testInvalidConfig,
+		"IdempotentCreate": testIdempotentCreate,
+		"Archive":          testArchive,
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			test(t, open)
+		})
+	}
+}
+
+func newClient() *mocks.Client {
+	client := &mocks.Client{}
+	client.On("UpdateInstallationState", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	client.On("UpdateInstanceState", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	client.On("UpdateThingPropertyValue", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	client.On("CreateThing", mock.Anything, mock.Anything, mock.Anything).Return(restapi.Thing{ID: configThingID}, nil)
+	client.On("DeleteThing", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	return client
+}
+
+// openWithInstance opens a store containing an installation with a single instance
+func openWithInstance(t *testing.T, open OpenFunc, installationConfig ...connector.Configuration) (storage.Store, *mocks.Client) {
+	client := newClient()
+	store := open(t, client)
+	ctx := context.Background()
+	require.NoError(t, store.AddInstallation(ctx, connector.InstallationRequest{
+		ID:            installationID,
+		Token:         "installationtoken",
+		Configuration: installationConfig,
+	}))
+	require.NoError(t, store.AddInstance(ctx, connector.InstantiationRequest{
+		ID:             instanceID,
+		InstallationID: installationID,
+		Token:          "instancetoken",
+	}))
+	return store, client
+}
+
+func mapping(thingID string) lorawan.DeviceMapping {
+	return lorawan.DeviceMapping{
+		InstanceID:   instanceID,
+		DevEUI:       devEUI,
+		ThingID:      thingID,
+		DecoderName:  "ldds75",
+		ModelVersion: 1,
+	}
+}
+
+func testInstallation(t *testing.T, open OpenFunc) {
+	store := open(t, newClient())
+	require.NoError(t, store.AddInstallation(context.Background(), connector.InstallationRequest{
+		ID:    installationID,
+		Token: "installationtoken",
+	}))
+
+	token, err := store.GetInstallationToken(context.Background(), installationID)
+	require.NoError(t, err)
+	assert.EqualValues(t, "installationtoken", token)
+
+	_, err = store.GetInstallationToken(context.Background(), "unknown")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testInvalidConfig(t *testing.T, open OpenFunc) {
+	client := newClient()
+	store := open(t, client)
+	err := store.AddInstallation(context.Background(), connector.InstallationRequest{
+		ID:    installationID,
+		Token: "installationtoken",
+		Configuration: []connector.Configuration{
+			{ID: lorawan.ConfigTimezone, Value: "Mars/Olympus_Mons"},
+		},
+	})
+	assert.ErrorIs(t, err, storage.ErrInvalidConfiguration)
+	client.AssertCalled(t, "UpdateInstallationState", mock.Anything, connector.InstallationToken("installationtoken"), connector.InstallationStateFailed, mock.Anything)
+}
+
+func testInstance(t *testing.T, open OpenFunc) {
+	store, client := openWithInstance(t, open, connector.Configuration{ID: lorawan.ConfigTimezone, Value: "Europe/Berlin"})
+
+	instance, err := store.GetInstance(context.Background(), instanceID)
+	require.NoError(t, err)
+	assert.Equal(t, instanceID, instance.ID)
+	assert.Equal(t, installationID, instance.InstallationID)
+	assert.EqualValues(t, "instancetoken", instance.Token)
+
+	token, err := store.GetInstanceToken(context.Background(), instanceID)
+	require.NoError(t, err)
+	assert.EqualValues(t, "instancetoken", token)
+
+	config, err := store.GetInstanceConfig(context.Background(), instanceID)
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/Berlin", config.Timezone)
+	assert.Equal(t, lorawan.ProvisioningPolicyRecreate, config.ProvisioningPolicy)
+	assert.Equal(t, lorawan.DefaultArchiveRetentionDays, config.ArchiveRetentionDays)
+
+	_, err = store.GetInstance(context.Background(), "unknown")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = store.GetInstanceConfig(context.Background(), "unknown")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	instances, err := store.ListInstances(context.Background())
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Equal(t, instanceID, instances[0].ID)
+	assert.Equal(t, installationID, instances[0].InstallationID)
+	assert.Equal(t, "Europe/Berlin", instances[0].Config.Timezone)
+	assert.False(t, instances[0].CreatedAt.IsZero())
+	details, err := store.GetInstanceDetails(context.Background(), instanceID)
+	require.NoError(t, err)
+	assert.Equal(t, instances[0], details)
+	_, err = store.GetInstanceDetails(context.Background(), "unknown")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	client.AssertCalled(t, "UpdateThingPropertyValue", mock.Anything, connector.InstantiationToken("instancetoken"), configThingID, "lora", "url", "https://connector.example.com/lorawan/installation/instance", mock.Anything)
+	client.AssertCalled(t, "UpdateInstanceState", mock.Anything, connector.InstantiationToken("instancetoken"), connector.InstantiationStateComplete, mock.Anything)
+}
+
+func testIdempotentCreate(t *testing.T, open OpenFunc) {
+	store, client := openWithInstance(t, open)
+	require.NoError(t, store.AddInstance(context.Background(), connector.InstantiationRequest{
+		ID:             instanceID,
+		InstallationID: installationID,
+		Token:          "newtoken",
+	}))
+	client.AssertNumberOfCalls(t, "CreateThing", 1)
+
+	token, err := store.GetInstanceToken(context.Background(), instanceID)
+	require.NoError(t, err)
+	assert.EqualValues(t, "newtoken", token)
+}
+
+func testConfigActions(t *testing.T, open OpenFunc) {
+	store, _ := openWithInstance(t, open, connector.Configuration{ID: lorawan.ConfigDefaultDecoder, Value: "ldds75"})
+	ctx := context.Background()
+
+	resp, err := store.PerformAction(ctx, connector.ActionRequest{
+		ID:          "action1",
+		ThingID:     configThingID,
+		ComponentID: "lora",
+		ActionID:    "addmapping",
+		Parameters: map[string]string{
+			"ApplicationId":  "42",
+			"PayloadDecoder": "ldds75",
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, restapi.ActionRequestStatusCompleted, resp.Status)
+
+	name, err := store.DecoderNameForApp(context.Background(), instanceID, 42)
+	require.NoError(t, err)
+	assert.Equal(t, "ldds75", name)
+	// Unmapped applications use the default decoder of the instance
+	name, err = store.DecoderNameForApp(context.Background(), instanceID, 43)
+	require.NoError(t, err)
+	assert.Equal(t, "ldds75", name)
+	decoders, err := store.ListApplicationDecoders(ctx, instanceID)
+	require.NoError(t, err)
+	assert.Equal(t, map[uint64]string{42: "ldds75"}, decoders)
+
+	resp, err = store.PerformAction(ctx, connector.ActionRequest{
+		ID:          "action2",
+		ThingID:     configThingID,
+		ComponentID: "lora",
+		ActionID:    "setprovisioningpolicy",
+		Parameters: map[string]string{
+			"Policy": string(lorawan.ProvisioningPolicyIgnoreDeleted),
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, restapi.ActionRequestStatusCompleted, resp.Status)
+
+	policy, err := store.ProvisioningPolicy(context.Background(), instanceID)
+	require.NoError(t, err)
+	assert.Equal(t, lorawan.ProvisioningPolicyIgnoreDeleted, policy)
+
+	resp, err = store.PerformAction(ctx, connector.ActionRequest{
+		ID:          "action3",
+		ThingID:     configThingID,
+		ComponentID: "lora",
+		ActionID:    "selfdestruct",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, restapi.ActionRequestStatusFailed, resp.Status)
+}
+
+func testDeviceMapping(t *testing.T, open OpenFunc) {
+	store, _ := openWithInstance(t, open)
+
+	m, err := store.GetDeviceMapping(context.Background(), instanceID, devEUI)
+	require.NoError(t, err)
+	assert.Nil(t, m)
+
+	legacy := mapping("thing1")
+	legacy.DecoderName = ""
+	require.NoError(t, store.StoreDeviceMapping(context.Background(), legacy))
+	m, err = store.GetDeviceMapping(context.Background(), instanceID, devEUI)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	assert.Equal(t, "thing1", m.ThingID)
+	assert.Equal(t, "", m.DecoderName)
+
+	// Devices provisioned concurrently are detected when storing the mapping
+	assert.Error(t, store.StoreDeviceMapping(context.Background(), mapping("thing2")))
+
+	require.NoError(t, store.SetDeviceDecoder(context.Background(), instanceID, devEUI, "ldds75", 1))
+	m, err = store.GetDeviceMapping(context.Background(), instanceID, devEUI)
+	require.NoError(t, err)
+	assert.Equal(t, mapping("thing1"), *m)
+
+	outdated, err := store.OutdatedDeviceMappings(context.Background(), instanceID, "ldds75", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []lorawan.DeviceMapping{mapping("thing1")}, outdated)
+	outdated, err = store.OutdatedDeviceMappings(context.Background(), "other", "ldds75", 2)
+	require.NoError(t, err)
+	assert.Empty(t, outdated)
+	outdated, err = store.OutdatedDeviceMappings(context.Background(), instanceID, "ldds75", 1)
+	require.NoError(t, err)
+	assert.Empty(t, outdated)
+
+	require.NoError(t, store.IgnoreDevice(context.Background(), instanceID, devEUI))
+	_, err = store.GetDeviceMapping(context.Background(), instanceID, devEUI)
+	assert.ErrorIs(t, err, lorawan.ErrDeviceIgnored)
+	devices, err := store.ListDevices(context.Background(), instanceID)
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, mapping("thing1"), devices[0].DeviceMapping)
+	assert.True(t, devices[0].Ignored)
+	_, err = store.ListDevices(context.Background(), "unknown")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	device, err := store.GetDevice(context.Background(), instanceID, devEUI)
+	require.NoError(t, err)
+	require.NotNil(t, device)
+	assert.Equal(t, devices[0], *device)
+	device, err = store.GetDevice(context.Background(), instanceID, []byte{0, 0, 0, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	assert.Nil(t, device)
+	_, err = store.GetDevice(context.Background(), "unknown", devEUI)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	outdated, err = store.OutdatedDeviceMappings(context.Background(), instanceID, "ldds75", 2)
+	require.NoError(t, err)
+	assert.Empty(t, outdated)
+}
+
+func testReplaceThingID(t *testing.T, open OpenFunc) {
+	store, _ := openWithInstance(t, open)
+	require.NoError(t, store.StoreDeviceMapping(context.Background(), mapping("thing1")))
+	require.NoError(t, store.SetState(context.Background(), "thing1", "ldds75.mountingHeight", []byte("120")))
+
+	replacement := mapping("thing2")
+	replacement.ModelVersion = 2
+	require.NoError(t, store.ReplaceThingID(context.Background(), "thing1", replacement))
+
+	m, err := store.GetDeviceMapping(context.Background(), instanceID, devEUI)
+	require.NoError(t, err)
+	assert.Equal(t, replacement, *m)
+
+	value, err := store.GetState(context.Background(), "thing2", "ldds75.mountingHeight")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("120"), value)
+	_, err = store.GetState(context.Background(), "thing1", "ldds75.mountingHeight")
+	assert.ErrorIs(t, err, decoder.ErrStateNotFound)
+}
+
+func testDecoderState(t *testing.T, open OpenFunc) {
+	store := open(t, newClient())
+
+	_, err := store.GetState(context.Background(), "thing1", "ldds75.mountingHeight")
+	assert.ErrorIs(t, err, decoder.ErrStateNotFound)
+
+	require.NoError(t, store.SetState(context.Background(), "thing1", "ldds75.mountingHeight", []byte("120")))
+	require.NoError(t, store.SetState(context.Background(), "thing1", "ldds75.mountingHeight", []byte("130")))
+	require.NoError(t, store.SetState(context.Background(), "thing2", "ldds75.mountingHeight", []byte("140")))
+
+	value, err := store.GetState(context.Background(), "thing1", "ldds75.mountingHeight")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("130"), value)
+	value, err = store.GetState(context.Background(), "thing2", "ldds75.mountingHeight")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("140"), value)
+
+	require.NoError(t, store.SetState(context.Background(), "thing1", "ldds75.offset", []byte("2")))
+	values, err := store.ListState(context.Background(), "thing1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"ldds75.mountingHeight": []byte("130"), "ldds75.offset": []byte("2")}, values)
+	values, err = store.ListState(context.Background(), "unknown")
+	require.NoError(t, err)
+	assert.Empty(t, values)
+}
+
+func testDecoderActions(t *testing.T, open OpenFunc) {
+	store, _ := openWithInstance(t, open)
+	require.NoError(t, store.StoreDeviceMapping(context.Background(), mapping("thing1")))
+	ctx := context.Background()
+
+	resp, err := store.PerformAction(ctx, connector.ActionRequest{
+		ID:       "action1",
+		ThingID:  "thing1",
+		ActionID: "setMountingHeight",
+		Parameters: map[string]string{
+			"mountingHeight": "123.4",
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, restapi.ActionRequestStatusCompleted, resp.Status)
+	value, err := store.GetState(context.Background(), "thing1", "ldds75.mountingHeight")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("123.4"), value)
+
+	resp, err = store.PerformAction(ctx, connector.ActionRequest{
+		ID:       "action2",
+		ThingID:  "thing1",
+		ActionID: "setWaterLevelOffset",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, restapi.ActionRequestStatusFailed, resp.Status)
+
+	resp, err = store.PerformAction(ctx, connector.ActionRequest{
+		ID:       "action3",
+		ThingID:  "unknown",
+		ActionID: "setMountingHeight",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, restapi.ActionRequestStatusFailed, resp.Status)
+}
+
+func testActivity(t *testing.T, open OpenFunc) {
+	store, _ := openWithInstance(t, open)
+	now := time.Now().Truncate(time.Second)
+
+	require.NoError(t, store.SetLastEvent(context.Background(), instanceID, now))
+	require.NoError(t, store.SetInstanceState(context.Background(), instanceID, connector.InstantiationStateFailed))
+	activities, err := store.ListInstanceActivity(context.Background())
+	require.NoError(t, err)
+	require.Len(t, activities, 1)
+	assert.Equal(t, instanceID, activities[0].InstanceID)
+	assert.EqualValues(t, "instancetoken", activities[0].Token)
+	assert.Equal(t, connector.InstantiationStateFailed, activities[0].State)
+	assert.True(t, now.Equal(activities[0].LastEventAt), "expected %s, got %s", now, activities[0].LastEventAt)
+
+	require.NoError(t, store.StoreDeviceMapping(context.Background(), mapping("thing1")))
+	devices, err := store.ListDeviceActivity(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, devices, "devices without uplinks are not monitored")
+
+	wasOffline, err := store.RecordUplink(context.Background(), instanceID, devEUI, now)
+	require.NoError(t, err)
+	assert.False(t, wasOffline)
+	devices, err = store.ListDeviceActivity(context.Background())
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, "thing1", devices[0].ThingID)
+	assert.Equal(t, devEUI, devices[0].DevEUI)
+	assert.True(t, now.Equal(devices[0].LastUplinkAt))
+
+	require.NoError(t, store.SetDeviceOffline(context.Background(), instanceID, devEUI))
+	devices, err = store.ListDeviceActivity(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, devices, "offline devices are not monitored")
+
+	// The device stays offline until the thing has been marked as available
+	for i := 1; i <= 2; i++ {
+		wasOffline, err = store.RecordUplink(context.Background(), instanceID, devEUI, now.Add(time.Duration(i)*20*time.Minute))
+		require.NoError(t, err)
+		assert.True(t, wasOffline)
+	}
+	require.NoError(t, store.SetDeviceOnline(context.Background(), instanceID, devEUI))
+	wasOffline, err = store.RecordUplink(context.Background(), instanceID, devEUI, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.False(t, wasOffline)
+	devices, err = store.ListDeviceActivity(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, devices, 1)
+}
+
+func testLockDevice(t *testing.T, open OpenFunc) {
+	store := open(t, newClient())
+	ctx := context.Background()
+
+	unlock, err := store.LockDevice(ctx, instanceID, devEUI)
+	require.NoError(t, err)
+
+	acquired := make(chan struct{})
+	go func() {
+		unlockOther, err := store.LockDevice(ctx, instanceID, devEUI)
+		if err == nil {
+			unlockOther()
+		}
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("lock has been acquired twice")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Other devices are not blocked
+	unlockOther, err := store.LockDevice(ctx, instanceID, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	require.NoError(t, err)
+	unlockOther()
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock has not been acquired after being released")
+	}
+}
+
+func testLeadership(t *testing.T, open OpenFunc) {
+	store := open(t, newClient())
+	ctx := context.Background()
+
+	leadership, err := store.TryLeadership(ctx, "job")
+	require.NoError(t, err)
+	require.NotNil(t, leadership)
+	assert.True(t, leadership.Held(ctx))
+
+	other, err := store.TryLeadership(ctx, "job")
+	require.NoError(t, err)
+	assert.Nil(t, other)
+
+	leadership.Release()
+	other, err = store.TryLeadership(ctx, "job")
+	require.NoError(t, err)
+	require.NotNil(t, other)
+	other.Release()
+}
+
+func testRemoveInstance(t *testing.T, open OpenFunc) {
+	store, client := openWithInstance(t, open)
+	ctx := context.Background()
+	require.NoError(t, store.StoreDeviceMapping(context.Background(), mapping("thing1")))
+	require.NoError(t, store.SetState(context.Background(), "thing1", "ldds75.mountingHeight", []byte("120")))
+
+	report, err := store.RemoveInstance(ctx, instanceID, true)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.ElementsMatch(t, []string{configThingID, "thing1"}, report.Things)
+	assert.Equal(t, 1, report.Mappings)
+	assert.Equal(t, 1, report.DecoderStates)
+	client.AssertNotCalled(t, "DeleteThing", mock.Anything, mock.Anything, mock.Anything)
+	_, err = store.GetInstance(context.Background(), instanceID)
+	require.NoError(t, err)
+
+	report, err = store.RemoveInstallation(ctx, installationID, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{installationID}, report.Installations)
+	assert.Equal(t, []string{instanceID}, report.Instances)
+	client.AssertCalled(t, "DeleteThing", mock.Anything, connector.InstantiationToken("instancetoken"), "thing1")
+
+	_, err = store.GetInstance(context.Background(), instanceID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = store.GetInstallationToken(context.Background(), installationID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = store.GetState(context.Background(), "thing1", "ldds75.mountingHeight")
+	assert.ErrorIs(t, err, decoder.ErrStateNotFound)
+
+	// The device can be provisioned again in a new instance
+	_, err = store.GetDeviceMapping(context.Background(), instanceID, devEUI)
+	require.NoError(t, err)
+}
+
+func testRemoveUnknown(t *testing.T, open OpenFunc) {
+	store := open(t, newClient())
+	_, err := store.RemoveInstance(context.Background(), "unknown", false)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = store.RemoveInstallation(context.Background(), "unknown", false)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testArchive(t *testing.T, open OpenFunc) {
+	store, _ := openWithInstance(t, open, connector.Configuration{ID: lorawan.ConfigArchiveRetentionDays, Value: "7"})
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	events := []lorawan.ArchivedEvent{
+		{InstanceID: instanceID, Event: "up", Encoding: lorawan.EncodingJSON, Body: []byte("old"), DevEUI: devEUI, FCnt: 1, ReceivedAt: now.Add(-8 * 24 * time.Hour), Outcome: lorawan.EventOutcomeProcessed},
+		{InstanceID: instanceID, Event: "up", Encoding: lorawan.EncodingJSON, Body: []byte("recent"), DevEUI: devEUI, FCnt: 2, ReceivedAt: now.Add(-time.Hour), Outcome: lorawan.EventOutcomeFailed, Error: "connctd unavailable"},
+		{InstanceID: instanceID, Event: "foo", Encoding: lorawan.EncodingJSON, Body: []byte("unknown"), ReceivedAt: now, Outcome: lorawan.EventOutcomeRejected},
+	}
+	// Archive out of order, events are listed in the order they were received
+	for _, i := range []int{2, 0, 1} {
+		require.NoError(t, store.ArchiveEvent(context.Background(), events[i]))
+	}
+
+	archived, err := store.ListArchivedEvents(ctx, lorawan.ArchiveFilter{InstanceID: instanceID})
+	require.NoError(t, err)
+	require.Len(t, archived, 3)
+	for i, e := range archived {
+		assert.NotZero(t, e.ID)
+		assert.Equal(t, events[i].Body, e.Body)
+		assert.True(t, events[i].ReceivedAt.Equal(e.ReceivedAt), "received at %s, want %s", e.ReceivedAt, events[i].ReceivedAt)
+	}
+	assert.Equal(t, "up", archived[1].Event)
+	assert.Equal(t, lorawan.EncodingJSON, archived[1].Encoding)
+	assert.Equal(t, devEUI, archived[1].DevEUI)
+	assert.Equal(t, uint32(2), archived[1].FCnt)
+	assert.Equal(t, lorawan.EventOutcomeFailed, archived[1].Outcome)
+	assert.Equal(t, "connctd unavailable", archived[1].Error)
+
+	for name, test := range map[string]struct {
+		filter lorawan.ArchiveFilter
+		bodies []string
+	}{
+		"device":   {lorawan.ArchiveFilter{DevEUI: devEUI}, []string{"old", "recent"}},
+		"from":     {lorawan.ArchiveFilter{From: now.Add(-time.Hour)}, []string{"recent", "unknown"}},
+		"to":       {lorawan.ArchiveFilter{To: now}, []string{"old", "recent"}},
+		"limit":    {lorawan.ArchiveFilter{Limit: 1}, []string{"old"}},
+		"latest":   {lorawan.ArchiveFilter{Limit: 2, Latest: true}, []string{"unknown", "recent"}},
+		"by id":    {lorawan.ArchiveFilter{ByID: true}, []string{"unknown", "old", "recent"}},
+		"after id": {lorawan.ArchiveFilter{ByID: true, AfterID: archived[0].ID, Limit: 1}, []string{"recent"}},
+		"outcomes": {lorawan.ArchiveFilter{Outcomes: []lorawan.EventOutcome{lorawan.EventOutcomeFailed, lorawan.EventOutcomeRejected}}, []string{"recent", "unknown"}},
+		"instance": {lorawan.ArchiveFilter{InstanceID: "unknown"}, nil},
+	} {
+		archived, err := store.ListArchivedEvents(ctx, test.filter)
+		require.NoError(t, err, name)
+		var bodies []string
+		for _, e := range archived {
+			bodies = append(bodies, string(e.Body))
+		}
+		assert.Equal(t, test.bodies, bodies, name)
+	}
+
+	updates := []decoder.PropertyUpdate{{ThingID: "thing1", ComponentID: "sensor", PropertyID: "distance", Value: "1200", UpdateTime: now}}
+	require.NoError(t, store.SetArchivedUpdates(context.Background(), archived[1].ID, updates))
+	recent, err := store.ListArchivedEvents(ctx, lorawan.ArchiveFilter{From: now.Add(-time.Hour), Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, recent, 1)
+	require.Len(t, recent[0].Updates, 1)
+	assert.Equal(t, "1200", recent[0].Updates[0].Value)
+	assert.True(t, now.Equal(recent[0].Updates[0].UpdateTime))
+	assert.ErrorIs(t, store.SetArchivedUpdates(context.Background(), archived[2].ID+1000, updates), storage.ErrNotFound)
+
+	pruned, err := store.PruneArchive(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+	archived, err = store.ListArchivedEvents(ctx, lorawan.ArchiveFilter{})
+	require.NoError(t, err)
+	assert.Len(t, archived, 2)
+
+	report, err := store.RemoveInstance(ctx, instanceID, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.ArchivedEvents)
+	_, err = store.RemoveInstance(ctx, instanceID, false)
+	require.NoError(t, err)
+	archived, err = store.ListArchivedEvents(ctx, lorawan.ArchiveFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, archived)
+}
@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/restapi-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedClient starts a client span for every request to the connctd API
+type tracedClient struct {
+	client connector.Client
+}
+
+// InstrumentClient returns a client which traces all requests to the connctd API
+func InstrumentClient(client connector.Client) connector.Client {
+	return &tracedClient{client: client}
+}
+
+func startClient(ctx context.Context, method string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Start(ctx, "connctd."+method, attributes...)
+}
+
+func (c *tracedClient) CreateThing(ctx context.Context, token connector.InstantiationToken, thing restapi.Thing) (result restapi.Thing, err error) {
+	ctx, span := startClient(ctx, "CreateThing", attribute.String("thing.name", thing.Name))
+	defer func() {
+		span.SetAttributes(attribute.String("thing.id", result.ID))
+		End(span, err)
+	}()
+	return c.client.CreateThing(ctx, token, thing)
+}
+
+func (c *tracedClient) UpdateThingPropertyValue(ctx context.Context, token connector.InstantiationToken, thingID string, componentID string, propertyID string, value string, lastUpdate time.Time) (err error) {
+	ctx, span := startClient(ctx, "UpdateThingPropertyValue",
+		attribute.String("thing.id", thingID),
+		attribute.String("thing.component", componentID),
+		attribute.String("thing.property", propertyID),
+	)
+	defer func() { End(span, err) }()
+	return c.client.UpdateThingPropertyValue(ctx, token, thingID, componentID, propertyID, value, lastUpdate)
+}
+
+func (c *tracedClient) UpdateThingStatus(ctx context.Context, token connector.InstantiationToken, thingID string, status restapi.StatusType) (err error) {
+	ctx, span := startClient(ctx, "UpdateThingStatus", attribute.String("thing.id", thingID), attribute.String("thing.status", string(status)))
+	defer func() { End(span, err) }()
+	return c.client.UpdateThingStatus(ctx, token, thingID, status)
+}
+
+func (c *tracedClient) UpdateActionStatus(ctx context.Context, token connector.InstantiationToken, actionRequestID string, status restapi.ActionRequestStatus, e string) (err error) {
+	ctx, span := startClient(ctx, "UpdateActionStatus", attribute.String("action.request", actionRequestID))
+	defer func() { End(span, err) }()
+	return c.client.UpdateActionStatus(ctx, token, actionRequestID, status, e)
+}
+
+func (c *tracedClient) UpdateInstallationState(ctx context.Context, token connector.InstallationToken, state connector.InstallationState, details json.RawMessage) (err error) {
+	ctx, span := startClient(ctx, "UpdateInstallationState")
+	defer func() { End(span, err) }()
+	return c.client.UpdateInstallationState(ctx, token, state, details)
+}
+
+func (c *tracedClient) UpdateInstanceState(ctx context.Context, token connector.InstantiationToken, state connector.InstantiationState, details json.RawMessage) (err error) {
+	ctx, span := startClient(ctx, "UpdateInstanceState")
+	defer func() { End(span, err) }()
+	return c.client.UpdateInstanceState(ctx, token, state, details)
+}
+
+func (c *tracedClient) DeleteThing(ctx context.Context, token connector.InstantiationToken, thingID string) (err error) {
+	ctx, span := startClient(ctx, "DeleteThing", attribute.String("thing.id", thingID))
+	defer func() { End(span, err) }()
+	return c.client.DeleteThing(ctx, token, thingID)
+}
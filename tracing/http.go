@@ -0,0 +1,58 @@
+package tracing
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StartRequest starts a server span for the request. Trace context sent by the caller is continued.
+// The returned request carries the span in its context and the returned writer records the status
+// code of the response in the span. The caller needs to end the span.
+func StartRequest(w http.ResponseWriter, r *http.Request, name string) (http.ResponseWriter, *http.Request, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := otel.Tracer(instrumentationName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", "", r)...),
+	)
+	return &statusRecorder{ResponseWriter: w, span: span}, r.WithContext(ctx), span
+}
+
+// Handler traces all requests to the handler
+func Handler(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w, r, span := StartRequest(w, r, name)
+		defer span.End()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder records the status code of the response in the span
+type statusRecorder struct {
+	http.ResponseWriter
+	span        trace.Span
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.wroteHeader = true
+		s.span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		// Client errors are no errors of the connector
+		if status >= http.StatusInternalServerError {
+			s.span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	return s.ResponseWriter.Write(b)
+}
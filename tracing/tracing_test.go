@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/connctd/connector-go"
+	"github.com/connctd/lora-connector/mocks"
+	"github.com/connctd/restapi-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func attributeValue(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestSetupWithoutEndpoint(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Config{})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+}
+
+func TestInstrumentClient(t *testing.T) {
+	recorder := recordSpans(t)
+	client := new(mocks.Client)
+	client.On("UpdateThingStatus", mock.Anything, connector.InstantiationToken("token"), "thing", restapi.StatusTypeAvailable).Return(nil)
+	client.On("DeleteThing", mock.Anything, connector.InstantiationToken("token"), "thing").Return(errors.New("unavailable"))
+
+	instrumented := InstrumentClient(client)
+	assert.NoError(t, instrumented.UpdateThingStatus(context.Background(), "token", "thing", restapi.StatusTypeAvailable))
+	assert.Error(t, instrumented.DeleteThing(context.Background(), "token", "thing"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "connctd.UpdateThingStatus", spans[0].Name())
+	assert.Equal(t, "thing", attributeValue(spans[0], "thing.id").AsString())
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Equal(t, "connctd.DeleteThing", spans[1].Name())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	client.AssertExpectations(t)
+}
+
+func TestHandler(t *testing.T) {
+	recorder := recordSpans(t)
+	// Setup installs the propagator which continues the trace of the caller
+	_, err := Setup(context.Background(), Config{})
+	require.NoError(t, err)
+	handler := Handler("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "child")
+		span.End()
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/missing", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/fail", nil))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 4)
+	child, server := spans[0], spans[1]
+	assert.Equal(t, server.SpanContext().SpanID(), child.Parent().SpanID())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, int64(http.StatusNotFound), attributeValue(server, "http.status_code").AsInt64())
+	assert.Equal(t, codes.Unset, server.Status().Code)
+	assert.Equal(t, codes.Error, spans[3].Status().Code)
+}